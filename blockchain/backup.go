@@ -0,0 +1,307 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/network"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A backup file holds a copy of the database read from a snapshot, so it can be
+// taken while the chain is in use, and optionally the wallets file
+//
+//	magic     4 bytes, "BCBK"
+//	header    uvarint length, then in the binary encoding: version uvarint 1,
+//	          network string, schema version uvarint, height of the last block
+//	          uvarint, last hash bytes and the optional wallets file bytes
+//	database  the badger backup of every key, blocks and indexes
+//	checksum  32 bytes, sha256 of everything before it
+var backupMagic = []byte("BCBK")
+
+const (
+	backupVersion = 1
+
+	// maxBackupHeaderSize bounds the header read so a corrupted length
+	// can't make the restore allocate more than a wallets file can hold
+	maxBackupHeaderSize = 32 << 20
+
+	// backupPendingWrites is the number of writes badger keeps in flight when restoring
+	backupPendingWrites = 256
+)
+
+// Backup describes the chain held by a backup file
+type Backup struct {
+	Network       string
+	SchemaVersion int
+	Height        int
+	LastHash      []byte
+	Wallets       []byte // Content of the wallets file, nil when it was left out
+}
+
+func (b *Backup) encode(e *encoder) {
+	e.uvarint(backupVersion)
+	e.string(b.Network)
+	e.uvarint(uint64(b.SchemaVersion))
+	e.uvarint(uint64(b.Height))
+	e.bytes(b.LastHash)
+	e.flag(b.Wallets != nil)
+	if b.Wallets != nil {
+		e.bytes(b.Wallets)
+	}
+}
+
+func (b *Backup) decode(d *decoder) error {
+	if version := d.uvarint(); d.err == nil && version != backupVersion {
+		return fmt.Errorf("unknown backup file version %d", version)
+	}
+
+	b.Network = d.string()
+	b.SchemaVersion = int(d.uvarint())
+	b.Height = int(d.uvarint())
+	b.LastHash = d.bytes()
+	if d.flag() {
+		b.Wallets = d.bytes()
+		if b.Wallets == nil {
+			b.Wallets = []byte{}
+		}
+	}
+
+	if d.err == nil && len(d.data) > 0 {
+		return errors.New("the backup header has trailing data")
+	}
+
+	return d.err
+}
+
+// WriteBackup writes the database to a backup file with the content of the wallets
+// file when it's not nil. The keys are read from a snapshot so blocks stored while
+// it's written aren't part of it, it's up to the caller to not store blocks meanwhile
+// for the header to describe the same chain
+func (chain *BlockChain) WriteBackup(w io.Writer, wallets []byte) (*Backup, error) {
+	version, err := chain.storedSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &Backup{
+		Network:       network.Active.Name,
+		SchemaVersion: version,
+		Height:        chain.GetBestHeight(),
+		LastHash:      chain.LastHash,
+		Wallets:       wallets,
+	}
+
+	checksum := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	var header encoder
+	backup.encode(&header)
+
+	var e encoder
+	e.buf.Write(backupMagic)
+	e.bytes(header.buf.Bytes())
+	if _, err := bw.Write(e.buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if _, err := chain.Database.Backup(bw, 0); err != nil {
+		return nil, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(checksum.Sum(nil)); err != nil {
+		return nil, err
+	}
+
+	return backup, nil
+}
+
+// RestoreBackup restores the database of a backup file to DataDir, which can't hold
+// a chain already. The checksum of the file is checked before anything is written
+// and the chain restored is walked from its last block to the genesis block in a
+// directory next to DataDir, it's only moved to DataDir once it matches the header
+func RestoreBackup(r io.ReadSeeker) (*Backup, error) {
+	if DBExits() {
+		return nil, fmt.Errorf("a blockchain already exists in %s, restore to another -datadir", DataDir)
+	}
+
+	size, err := checkBackupChecksum(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(io.LimitReader(r, size-sha256.Size))
+
+	backup, err := readBackupHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Network != network.Active.Name {
+		return nil, fmt.Errorf("the backup holds a %s chain, run with -network %s", backup.Network, backup.Network)
+	}
+	if backup.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("the backup has the schema version %d but this binary only reads up to %d, upgrade the binary to restore it", backup.SchemaVersion, schemaVersion)
+	}
+
+	restoreDir := filepath.Clean(DataDir) + ".restore"
+	if err := os.RemoveAll(restoreDir); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(restoreDir)
+
+	if err := os.MkdirAll(restoreDir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := loadBackup(restoreDir, br, backup); err != nil {
+		return nil, err
+	}
+
+	// The database files are moved, the wallets file of DataDir is left to the caller
+	if err := os.MkdirAll(DataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(restoreDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := os.Rename(filepath.Join(restoreDir, f.Name()), filepath.Join(DataDir, f.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return backup, nil
+}
+
+// checkBackupChecksum checks the checksum at the end of the file and returns its size
+func checkBackupChecksum(r io.ReadSeeker) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if size < int64(len(backupMagic)+sha256.Size) {
+		return 0, errors.New("the file is not a backup file")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	checksum := sha256.New()
+	if _, err := io.CopyN(checksum, r, size-sha256.Size); err != nil {
+		return 0, err
+	}
+
+	expected := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, expected); err != nil {
+		return 0, err
+	}
+	if bytes.Equal(checksum.Sum(nil), expected) == false {
+		return 0, errors.New("the backup file doesn't match its checksum, it's truncated or corrupted")
+	}
+
+	return size, nil
+}
+
+// readBackupHeader reads the magic and the header of the backup file
+func readBackupHeader(r *bufio.Reader) (*Backup, error) {
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || bytes.Equal(magic, backupMagic) == false {
+		return nil, errors.New("the file is not a backup file")
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxBackupHeaderSize {
+		return nil, fmt.Errorf("a header of %d bytes is more than the %d allowed", n, maxBackupHeaderSize)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	backup := &Backup{}
+	if err := backup.decode(&decoder{data: data}); err != nil {
+		return nil, err
+	}
+
+	return backup, nil
+}
+
+// loadBackup loads the database of the backup in the directory and checks it holds the
+// chain described by the header, every block down to the genesis block under its hash
+func loadBackup(dir string, r io.Reader, backup *Backup) error {
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Load(r, backupPendingWrites); err != nil {
+		return err
+	}
+
+	return db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("lh"))
+		if err != nil {
+			return fmt.Errorf("the last hash is missing: %s", err)
+		}
+		lastHash, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(lastHash, backup.LastHash) == false {
+			return fmt.Errorf("the last block is %x instead of %x", lastHash, backup.LastHash)
+		}
+
+		hash := lastHash
+		for height := backup.Height; ; height-- {
+			item, err := txn.Get(hash)
+			if err != nil {
+				return fmt.Errorf("block %x at height %d is missing", hash, height)
+			}
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			block, err := DeserializeBlock(data)
+			if err != nil {
+				return fmt.Errorf("block %x at height %d: %s", hash, height, err)
+			}
+			if bytes.Equal(block.Hash, hash) == false {
+				return fmt.Errorf("the block stored under %x has the hash %x", hash, block.Hash)
+			}
+
+			if len(block.PrevHash) == 0 {
+				if height != 0 {
+					return fmt.Errorf("the chain ends at height %d instead of the genesis block", height)
+				}
+				return nil
+			}
+			if height == 0 {
+				return fmt.Errorf("the chain has more than the %d blocks of the backup", backup.Height+1)
+			}
+
+			hash = block.PrevHash
+		}
+	})
+}
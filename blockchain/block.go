@@ -7,9 +7,13 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/gob"
+	"fmt"
+	"github.com/sheghun/blockchain/wallet"
 	"log"
+	"time"
 )
 
 // The block
@@ -19,6 +23,15 @@ type Block struct {
 	Transactions []*Transaction // Block data
 	PrevHash     []byte         // Previous Block hash
 	Nonce        int            // Nonce that qualifies the target
+	Timestamp    int64          // Unix time the block was mined, rolled forward when the nonces run out
+	Difficulty   int            // Difficulty the block was sealed at, 0 on blocks from before it was recorded
+	Consensus    string         // Consensus engine of the chain, only set on the genesis block
+	Network      string         // Network of the chain, only set on the genesis block
+	Signers      [][]byte       // Public key hashes of the first authorities, only set on proof of authority genesis blocks
+	Signer       []byte         // Public key of the authority that sealed the block
+	SignerCurve  wallet.Curve   // Curve of the signer key, empty on blocks signed before it was recorded
+	Signature    []byte         // Signature of the authority over the block hash
+	Vote         *SignerVote    // Proposal of the sealing authority to add or remove a signer
 }
 
 // HashTransactions hashes and returns the sha256 hash of all the transaction
@@ -35,45 +48,109 @@ func (b *Block) HashTransactions() []byte {
 	return txHash[:]
 }
 
+// chainParams returns the parameters of the chain the genesis block commits to
+func (b *Block) chainParams() []byte {
+	params := []byte(b.Consensus)
+
+	if b.Network != "" {
+		params = append(append(params, 0), b.Network...)
+	}
+
+	return params
+}
+
+// Legacy tells the block was mined by the binary from before blocks recorded their
+// timestamp and difficulty, its transactions were signed without hash types. Every
+// block since has a difficulty so legacy blocks can only start the chain
+func (b *Block) Legacy() bool {
+	return b.Timestamp == 0 && b.Difficulty == 0
+}
+
+// MaxFutureBlockTime is how many seconds the timestamp of a block can be ahead of the clock
+const MaxFutureBlockTime = 2 * 60 * 60
+
+// checkTimestamp checks the block was mined after prev, nil for the genesis block, and
+// not more than MaxFutureBlockTime ahead of the clock. The lock times of the refunds
+// are checked at the timestamp so it can't be moved to make them valid early
+func (b *Block) checkTimestamp(prev *Block) error {
+	if b.Legacy() {
+		return nil
+	}
+
+	if b.Timestamp < 0 {
+		return fmt.Errorf("it has the negative timestamp %d", b.Timestamp)
+	}
+	if prev != nil && b.Timestamp <= prev.Timestamp {
+		return fmt.Errorf("its timestamp %d isn't after the timestamp %d of the previous block", b.Timestamp, prev.Timestamp)
+	}
+	if limit := time.Now().Unix() + MaxFutureBlockTime; b.Timestamp > limit {
+		return fmt.Errorf("its timestamp %d is more than %d seconds ahead of the clock", b.Timestamp, MaxFutureBlockTime)
+	}
+
+	return nil
+}
+
 // CreateBlock creates a new block
 func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
-	b := &Block{Transactions: txs, Hash: []byte{}, PrevHash: prevHash, Nonce: 0}
-	p := NewProof(b) // Generate new proof of work
-	n, h := p.Run()  // Returns the block hash and nonce
+	b, _, err := CreateBlockContext(context.Background(), txs, prevHash)
+	Handle(err)
 
-	b.Hash = h[:]
-	b.Nonce = n
 	return b
 }
 
+// CreateBlockContext creates a new block with proof of work, mining stops with the
+// context error when the context is cancelled, e.g. when a competing block arrives
+func CreateBlockContext(ctx context.Context, txs []*Transaction, prevHash []byte) (*Block, MiningStats, error) {
+	b := &Block{Transactions: txs, Hash: []byte{}, PrevHash: prevHash, Nonce: 0, Timestamp: time.Now().Unix(), Difficulty: Difficulty}
+
+	stats, err := (&PowEngine{}).Seal(ctx, nil, b, MinerWorkers)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return b, stats, nil
+}
+
+// rollHeader changes the mined data once the nonces are exhausted, the timestamp
+// is moved to the clock or a second forward when it's already ahead of it
+func (b *Block) rollHeader() {
+	now := time.Now().Unix()
+
+	if now <= b.Timestamp {
+		now = b.Timestamp + 1
+	}
+
+	b.Timestamp = now
+}
+
 // Genesis creates the first block in the blockchain
 func Genesis(coinbase *Transaction) *Block {
 	return CreateBlock([]*Transaction{coinbase}, []byte{})
 }
 
-// Serialize converts the block into a gob byte
+// Serialize converts the block into its binary encoding
 func (b *Block) Serialize() []byte {
-	buffer := new(bytes.Buffer)
+	var e encoder
+	b.encode(&e)
 
-	encoder := gob.NewEncoder(buffer)
-
-	err := encoder.Encode(b)
-	Handle(err)
-
-	return buffer.Bytes()
+	return e.buf.Bytes()
 }
 
 // Deserialize converts the supplied byte into a block
 func Deserialize(data []byte) *Block {
-	var b Block
+	b, err := DeserializeBlock(data)
+	Handle(err)
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
+	return b
+}
 
-	err := decoder.Decode(&b)
-	Handle(err)
+// deserializeGob decodes a block stored before the binary encoding
+func deserializeGob(data []byte) (*Block, error) {
+	var b Block
 
-	return &b
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&b)
 
+	return &b, err
 }
 
 // Handle takes the error and prints it out
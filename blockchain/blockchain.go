@@ -2,26 +2,30 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/wallet"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
-const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST"
-	genesisData = "First Transaction from Genesis"
-)
+// DataDir is the directory holding the blockchain database
+// it can be changed before opening the chain to run several chains side by side
+var DataDir = "./tmp/blocks"
 
 // BlockChain the chain(slice) containing the blocks
 type BlockChain struct {
 	LastHash []byte
 	Database *badger.DB
+	Engine   Consensus // Seals and verifies the blocks, picked from the genesis block
+	TxIndex  bool      // Keeps an index of transaction IDs, without it transactions are found by walking the chain
 }
 
 // Iterator loops through the database and retrieves all blocks
@@ -31,7 +35,7 @@ type Iterator struct {
 }
 
 func DBExits() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+	if _, err := os.Stat(filepath.Join(DataDir, "MANIFEST")); os.IsNotExist(err) {
 		return false
 	}
 
@@ -39,15 +43,27 @@ func DBExits() bool {
 }
 
 //InitBlockChain starts the blockchain system
-func InitBlockChain(address string) *BlockChain {
+// sealing its blocks with the named consensus engine
+func InitBlockChain(address, consensus string) *BlockChain {
+	return InitBlockChainGenesis(DefaultGenesis(address, consensus))
+}
+
+// InitBlockChainGenesis starts the blockchain system with the configured genesis block
+func InitBlockChainGenesis(genesis *GenesisConfig) *BlockChain {
 	var lastHash []byte
 
+	err := genesis.Validate()
+	Handle(err)
+
+	engine, err := NewConsensus(genesis.Consensus)
+	Handle(err)
+
 	if DBExits() {
 		str := []string{
 			"\n\n\n\n",
 			"----------------",
 			"Blockchain database has already been created",
-			"delete database files at './tmp/blocks/' to",
+			fmt.Sprintf("delete database files at '%s' to", DataDir),
 			"create a new blockchain",
 			"----------------",
 			"\n\n\n\n\n",
@@ -57,30 +73,132 @@ func InitBlockChain(address string) *BlockChain {
 		runtime.Goexit()
 	}
 
-	opts := badger.DefaultOptions(dbPath)
+	opts := badger.DefaultOptions(DataDir)
 
 	db, err := badger.Open(opts)
 	Handle(err)
 
-	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
+	chain := &BlockChain{nil, db, engine, true}
 
-		gen := Genesis(cbtx)
+	err = db.Update(func(txn *badger.Txn) error {
+		gen := &Block{
+			Transactions: []*Transaction{genesis.coinbase()},
+			Hash:         []byte{},
+			PrevHash:     []byte{},
+			Timestamp:    genesis.Timestamp,
+			Difficulty:   genesis.Difficulty,
+			Consensus:    engine.Name(),
+			Network:      genesis.Network,
+			Signers:      genesis.signers(),
+		}
+		if gen.Difficulty == 0 {
+			gen.Difficulty = engine.NextDifficulty(chain, nil)
+		}
 
-		fmt.Println("Genesis proved")
+		// A single worker always finds the same nonce so the same genesis gets the same hash
+		stats, err := engine.Seal(context.Background(), chain, gen, 1)
+		Handle(err)
 
-		err = txn.Set(gen.Hash, gen.Serialize())
+		err = chain.VerifyBlock(gen)
 		Handle(err)
 
-		err = txn.Set([]byte("lh"), gen.Hash)
+		// Engines that don't mine have no statistics to show
+		if stats.Attempts > 0 {
+			fmt.Printf("Genesis proved: %s\n", stats)
+		}
+
+		err = chain.storeGenesis(txn, gen)
 
 		lastHash = gen.Hash
 
 		return err
 	})
 	Handle(err)
+
 	// Return blockchain instance
-	return &BlockChain{lastHash, db}
+	chain.LastHash = lastHash
+	return chain
+}
+
+// networkKey holds the magic of the network of the chain, a database is only
+// opened on the network it was created on
+var networkKey = []byte("magic")
+
+// genesisNetwork returns the network named by the genesis block, the chains
+// created before networks existed are mainnet chains
+func genesisNetwork(genesis *Block) (*network.Params, error) {
+	if genesis.Network == "" {
+		return &network.Mainnet, nil
+	}
+
+	return network.Get(genesis.Network)
+}
+
+// storedNetwork returns the network whose magic is recorded in the database
+func (chain *BlockChain) storedNetwork() (*network.Params, error) {
+	var params *network.Params
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(networkKey)
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			if params = network.ForMagic(val); params == nil {
+				return fmt.Errorf("the network magic %x is unknown", val)
+			}
+			return nil
+		})
+	})
+
+	return params, err
+}
+
+// migrateNetwork records the network of the chains created before its magic was stored
+func (chain *BlockChain) migrateNetwork() error {
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		return err
+	}
+
+	params, err := genesisNetwork(genesis)
+	if err != nil {
+		return err
+	}
+
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(networkKey, params.Magic[:])
+	})
+}
+
+// storeGenesis writes the genesis block of a new database with its indexes, schema version and network
+func (chain *BlockChain) storeGenesis(txn *badger.Txn, gen *Block) error {
+	if err := txn.Set(gen.Hash, gen.Serialize()); err != nil {
+		return err
+	}
+
+	params, err := genesisNetwork(gen)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(networkKey, params.Magic[:]); err != nil {
+		return err
+	}
+
+	if err := setSchemaVersion(txn, schemaVersion); err != nil {
+		return err
+	}
+
+	if err := chain.indexBlock(txn, gen, 0); err != nil {
+		return err
+	}
+
+	if err := chain.setTxIndexed(txn); err != nil {
+		return err
+	}
+
+	return txn.Set([]byte("lh"), gen.Hash)
 }
 
 // ContinueBlockChain retrieves the last hash ID on the database
@@ -92,7 +210,7 @@ func ContinueBlockChain() *BlockChain {
 
 	var lastHash []byte
 
-	opts := badger.DefaultOptions(dbPath)
+	opts := badger.DefaultOptions(DataDir)
 
 	db, err := badger.Open(opts)
 	Handle(err)
@@ -109,7 +227,38 @@ func ContinueBlockChain() *BlockChain {
 		return err
 	})
 	Handle(err)
-	chain := BlockChain{lastHash, db}
+	chain := BlockChain{lastHash, db, nil, true}
+
+	// The database keeps the transaction index setting, SetTxIndex changes it
+	err = chain.loadTxIndexSetting()
+	Handle(err)
+
+	// Databases written by older binaries are upgraded, newer ones refused
+	err = chain.migrate()
+	Handle(err)
+
+	// An interrupted rebuild left the indexes incomplete
+	err = chain.resumeReindex()
+	Handle(err)
+
+	// Indexes built by older binaries may not match the setting
+	err = chain.checkTxIndex()
+	Handle(err)
+
+	// The genesis block names the engine the chain was created with
+	genesis, err := chain.GetBlockByHeight(0)
+	Handle(err)
+
+	chain.Engine, err = NewConsensus(genesis.Consensus)
+	Handle(err)
+
+	// The magic stored with the genesis block identifies the network of the chain
+	params, err := chain.storedNetwork()
+	Handle(err)
+
+	if params.Magic != network.Active.Magic {
+		Handle(fmt.Errorf("the blockchain in %s belongs to %s, run with -network %s", DataDir, params.Name, params.Name))
+	}
 
 	return &chain
 }
@@ -177,6 +326,17 @@ func (chain *BlockChain) FindUTXO(pubKeyHash []byte) []TxOutput {
 
 }
 
+// GetBalance returns the sum of the unspent outputs locked to the public key hash
+func (chain *BlockChain) GetBalance(pubKeyHash []byte) int {
+	balance := 0
+
+	for _, out := range chain.FindUTXO(pubKeyHash) {
+		balance += out.Value
+	}
+
+	return balance
+}
+
 func (chain *BlockChain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
 	unspentOuts := make(map[string][]int)
 	unSpentTxs := chain.FindUnspentTransactions(pubKeyHash)
@@ -203,6 +363,17 @@ Work:
 
 // FindTransaction finds and returns a transaction using the supplied Id
 func (chain *BlockChain) FindTransaction(Id []byte) (Transaction, error) {
+	tx, _, err := chain.GetTransaction(Id)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return *tx, nil
+}
+
+// findTransactionBlock walks the chain from the last block to find the transaction
+// it's used when the transaction index is turned off
+func (chain *BlockChain) findTransactionBlock(Id []byte) (*Transaction, []byte, error) {
 	iter := chain.Iterator()
 
 	for {
@@ -210,7 +381,7 @@ func (chain *BlockChain) FindTransaction(Id []byte) (Transaction, error) {
 
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, Id) == 0 {
-				return *tx, nil
+				return tx, block.Hash, nil
 			}
 		}
 
@@ -222,10 +393,125 @@ func (chain *BlockChain) FindTransaction(Id []byte) (Transaction, error) {
 	}
 
 	// Means transaction was not found
-	return Transaction{}, errors.New("transaction does not exists")
+	return nil, nil, errors.New("transaction does not exists")
+}
+
+// VerifyBlock checks the seal of the block with the consensus engine of the chain
+func (chain *BlockChain) VerifyBlock(b *Block) error {
+	return chain.Engine.VerifySeal(chain, b)
+}
+
+// checkBlock checks the block can follow prev, the last block of the chain, by the
+// rules every block meets whether it was mined here or received from another node
+func (chain *BlockChain) checkBlock(block, prev *Block) error {
+	if err := chain.checkHeader(block, prev); err != nil {
+		return err
+	}
+
+	return chain.checkTransactions(block)
 }
 
-func (chain *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+// checkHeader checks the block links to prev after it and is sealed by the engine,
+// the engine checks the difficulty of the block
+func (chain *BlockChain) checkHeader(block, prev *Block) error {
+	if bytes.Equal(block.PrevHash, prev.Hash) == false {
+		return fmt.Errorf("it follows %x instead of the last block %x", block.PrevHash, prev.Hash)
+	}
+
+	if err := block.checkTimestamp(prev); err != nil {
+		return err
+	}
+
+	return chain.VerifyBlock(block)
+}
+
+// checkTransactions checks the transactions of the block match their IDs and spend
+// outputs on the chain with valid signatures, coins are only created by the genesis block
+func (chain *BlockChain) checkTransactions(block *Block) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			return fmt.Errorf("transaction %x is a coinbase outside the genesis block", tx.ID)
+		}
+		if err := tx.checkID(block.Legacy()); err != nil {
+			return fmt.Errorf("transaction %x: %s", tx.ID, err)
+		}
+
+		// Checked first as verifying a transaction spending a missing one panics
+		if _, err := chain.findPrevTransactions(tx); err != nil {
+			return fmt.Errorf("transaction %x: %s", tx.ID, err)
+		}
+	}
+
+	return chain.VerifyTransactions(block.Transactions, block.Timestamp, block.Legacy())
+}
+
+// GetBlock returns the block stored with the supplied hash
+func (chain *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(hash)
+		if err == badger.ErrKeyNotFound {
+			return errors.New("block does not exists")
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			block = Deserialize(val)
+			return nil
+		})
+	})
+
+	return block, err
+}
+
+// GetBestHeight returns the height of the last block, the genesis block is at height 0
+func (chain *BlockChain) GetBestHeight() int {
+	height, err := chain.GetBlockHeight(chain.LastHash)
+	Handle(err)
+
+	return height
+}
+
+// GetBlockHeight returns the height of the block with the hash
+func (chain *BlockChain) GetBlockHeight(hash []byte) (int, error) {
+	var height int
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		height, err = readHeight(txn, hash)
+		return err
+	})
+
+	return height, err
+}
+
+// GetBlockByHeight returns the block at the height, the genesis block is at height 0
+func (chain *BlockChain) GetBlockByHeight(height int) (*Block, error) {
+	var hash []byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightIndexKey(height))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("no block at height %d", height)
+		}
+		if err != nil {
+			return err
+		}
+
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.GetBlock(hash)
+}
+
+func (chain *BlockChain) SignTransaction(tx *Transaction, w wallet.Wallet) {
 	prevTxs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
@@ -234,25 +520,90 @@ func (chain *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateK
 		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
 
-	tx.Sign(privKey, prevTxs)
+	tx.Sign(w, prevTxs)
 }
 
 // VerifyTransaction verifies all the utxo's and utx inputs in the transaction
 // returns false if one of them fail and returns true if all them passes
-func (chain *BlockChain) VerifyTransaction(tx *Transaction) bool {
+func (chain *BlockChain) VerifyTransaction(tx *Transaction, blockTime int64) bool {
+	return chain.verifyTransaction(tx, blockTime, false)
+}
+
+// verifyTransaction verifies the transaction of a block mined at blockTime, inputs signed
+// before hash types are only accepted in legacy blocks
+func (chain *BlockChain) verifyTransaction(tx *Transaction, blockTime int64, legacy bool) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	batch := &wallet.BatchVerifier{}
+
+	return tx.verifyInputs(chain.prevTransactions(tx), blockTime, legacy, batch) && batch.Verify()
+}
+
+// VerifyTransactions verifies the transactions of a block mined at blockTime, the
+// signatures of all their inputs are verified together in one batch. Inputs signed
+// before hash types are only accepted when the block is legacy, see Block.Legacy
+func (chain *BlockChain) VerifyTransactions(txs []*Transaction, blockTime int64, legacy bool) error {
+	batch := &wallet.BatchVerifier{}
+
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		if tx.verifyInputs(chain.prevTransactions(tx), blockTime, legacy, batch) == false {
+			return fmt.Errorf("transaction %x is invalid", tx.ID)
+		}
+	}
+
+	if batch.Verify() {
+		return nil
+	}
+
+	// The batch only tells a signature is wrong, find the transaction it belongs to
+	for _, tx := range txs {
+		if chain.verifyTransaction(tx, blockTime, legacy) == false {
+			return fmt.Errorf("transaction %x is invalid", tx.ID)
+		}
+	}
+
+	return errors.New("the signatures of the block are invalid")
+}
+
+// prevTransactions returns the transactions spent by the inputs of the transaction
+func (chain *BlockChain) prevTransactions(tx *Transaction) map[string]Transaction {
+	prevTxs, err := chain.findPrevTransactions(tx)
+	Handle(err)
+
+	return prevTxs
+}
+
+// findPrevTransactions returns the transactions spent by the inputs of the
+// transaction or an error when one of them is not on the chain
+func (chain *BlockChain) findPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTxs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
 		prevTx, err := chain.FindTransaction(in.ID)
-		Handle(err)
+		if err != nil {
+			return nil, err
+		}
 		prevTxs[hex.EncodeToString(in.ID)] = prevTx
 	}
 
-	return tx.Verify(prevTxs)
+	return prevTxs, nil
 }
 
 // AddBlock adds a new block to the chain
 func (chain *BlockChain) AddBlock(txn []*Transaction) {
+	_, _, err := chain.AddBlockContext(context.Background(), txn)
+	Handle(err)
+}
+
+// AddBlockContext mines and adds a new block to the chain, mining stops with
+// the context error when the context is cancelled before the block is found
+func (chain *BlockChain) AddBlockContext(ctx context.Context, txn []*Transaction) (*Block, MiningStats, error) {
 	var lastHash []byte
 
 	err := chain.Database.View(func(txn *badger.Txn) error {
@@ -267,26 +618,103 @@ func (chain *BlockChain) AddBlock(txn []*Transaction) {
 	})
 	Handle(err)
 
-	newBlock := CreateBlock(txn, lastHash)
+	prev, err := chain.GetBlock(lastHash)
+	Handle(err)
+
+	// Blocks mined within a second of the previous one are still mined after it
+	timestamp := time.Now().Unix()
+	if timestamp <= prev.Timestamp {
+		timestamp = prev.Timestamp + 1
+	}
+
+	newBlock := &Block{
+		Transactions: txn,
+		Hash:         []byte{},
+		PrevHash:     lastHash,
+		Timestamp:    timestamp,
+		Difficulty:   chain.Engine.NextDifficulty(chain, prev),
+	}
+
+	// Sealing only moves the timestamp forward, lock times passed at it stay passed
+	if err := chain.checkTransactions(newBlock); err != nil {
+		return nil, MiningStats{}, err
+	}
+
+	stats, err := chain.Engine.Seal(ctx, chain, newBlock, MinerWorkers)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	// The block is checked like a received one, a faulty engine can't store blocks the chain refuses
+	if err := chain.checkHeader(newBlock, prev); err != nil {
+		return nil, stats, err
+	}
 
 	err = chain.Database.Update(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
 		Handle(err)
 
 		err = item.Value(func(val []byte) error {
+			// Another block was connected while this one was mined
+			if bytes.Equal(val, lastHash) == false {
+				return errors.New("the last block changed while mining")
+			}
+
 			err = txn.Set([]byte("lh"), newBlock.Hash)
 
 			chain.LastHash = newBlock.Hash
 			return err
 		})
+		if err != nil {
+			return err
+		}
+
+		height, err := readHeight(txn, lastHash)
+		Handle(err)
 
+		err = chain.indexBlock(txn, newBlock, height+1)
 		Handle(err)
 
 		err = txn.Set(newBlock.Hash, newBlock.Serialize())
 		return err
 	})
-	Handle(err)
+	if err != nil {
+		return nil, stats, err
+	}
 
+	return newBlock, stats, nil
+}
+
+// DisconnectTip removes the last block from the chain and the indexes
+// so a competing branch can be connected in its place during a reorganisation
+func (chain *BlockChain) DisconnectTip() (*Block, error) {
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(block.PrevHash) == 0 {
+		return nil, errors.New("the genesis block can not be disconnected")
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := chain.unindexBlock(txn, block); err != nil {
+			return err
+		}
+
+		if err := txn.Set([]byte("lh"), block.PrevHash); err != nil {
+			return err
+		}
+
+		return txn.Delete(block.Hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chain.LastHash = block.PrevHash
+
+	return block, nil
 }
 
 // Returns the iterator struct to iterate the blocks in the database
@@ -296,6 +724,29 @@ func (chain *BlockChain) Iterator() *Iterator {
 	return iter
 }
 
+// ForwardIterator walks the blocks from a height towards the last block
+type ForwardIterator struct {
+	height int
+	chain  *BlockChain
+}
+
+// ForwardIterator returns an iterator starting at the block at the height
+func (chain *BlockChain) ForwardIterator(height int) *ForwardIterator {
+	return &ForwardIterator{height, chain}
+}
+
+// Next returns the next block towards the last block, nil once past the last block
+func (iter *ForwardIterator) Next() *Block {
+	block, err := iter.chain.GetBlockByHeight(iter.height)
+	if err != nil {
+		return nil
+	}
+
+	iter.height++
+
+	return block
+}
+
 // Returns the next block on the blockchain
 func (iter *Iterator) Next() *Block {
 	var block *Block
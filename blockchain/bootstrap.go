@@ -0,0 +1,332 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/network"
+	"io"
+)
+
+// A bootstrap file holds the blocks of a chain from the genesis block so a new node
+// can be seeded with it instead of receiving the blocks one by one
+//
+//	magic     4 bytes, "BCBF"
+//	version   uvarint, 2
+//	network   4 bytes, the magic of the network of the chain, version 1 files have none
+//	name      uvarint length and the name of the network
+//	count     uvarint, number of blocks in the file
+//	blocks    per block from the genesis block: uvarint length, the block in
+//	          the binary encoding and the first 4 bytes of its double sha256
+//
+// The count tells a file cut short from a complete one
+var bootstrapMagic = []byte("BCBF")
+
+const (
+	bootstrapVersion = 2
+
+	// maxBootstrapBlockSize bounds the length read before a block so a corrupted
+	// length can't make the import allocate more than a block can hold
+	maxBootstrapBlockSize = 32 << 20
+
+	bootstrapChecksumLength = 4
+)
+
+// ExportChain writes the blocks from the genesis block to the last one as a bootstrap file,
+// progress is called after every block with its height and the number of blocks
+func (chain *BlockChain) ExportChain(w io.Writer, progress func(height, count int)) error {
+	count := chain.GetBestHeight() + 1
+	bw := bufio.NewWriter(w)
+
+	var header encoder
+	header.buf.Write(bootstrapMagic)
+	header.uvarint(bootstrapVersion)
+	header.buf.Write(network.Active.Magic[:])
+	header.string(network.Active.Name)
+	header.uvarint(uint64(count))
+	if _, err := bw.Write(header.buf.Bytes()); err != nil {
+		return err
+	}
+
+	iter := chain.ForwardIterator(0)
+	for height := 0; height < count; height++ {
+		block := iter.Next()
+		if block == nil {
+			return fmt.Errorf("block %d is missing from the height index", height)
+		}
+
+		data := block.Serialize()
+		checksum := doubleSha256(data)
+
+		var record encoder
+		record.bytes(data)
+		record.buf.Write(checksum[:bootstrapChecksumLength])
+		if _, err := bw.Write(record.buf.Bytes()); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(height, count)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// bootstrapReader reads the blocks of a bootstrap file
+type bootstrapReader struct {
+	r       *bufio.Reader
+	network *network.Params
+	count   int
+	read    int
+}
+
+// newBootstrapReader reads the header of the bootstrap file
+func newBootstrapReader(r io.Reader) (*bootstrapReader, error) {
+	br := &bootstrapReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(bootstrapMagic))
+	if _, err := io.ReadFull(br.r, magic); err != nil || bytes.Equal(magic, bootstrapMagic) == false {
+		return nil, errors.New("the file is not a bootstrap file")
+	}
+
+	version, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > bootstrapVersion {
+		return nil, fmt.Errorf("unknown bootstrap file version %d", version)
+	}
+
+	// Files written before the magic was recorded only have the name of the network
+	var networkMagic [4]byte
+	if version > 1 {
+		if _, err := io.ReadFull(br.r, networkMagic[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	name, err := br.readBytes(64)
+	if err != nil {
+		return nil, err
+	}
+	if br.network, err = network.Get(string(name)); err != nil {
+		return nil, err
+	}
+	if version > 1 && networkMagic != br.network.Magic {
+		return nil, fmt.Errorf("the network magic %x of the bootstrap file isn't the one of %s", networkMagic, br.network.Name)
+	}
+
+	count, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.New("the bootstrap file holds no blocks")
+	}
+	br.count = int(count)
+
+	return br, nil
+}
+
+// readBytes reads a byte string prefixed with its length
+func (br *bootstrapReader) readBytes(max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, fmt.Errorf("a length of %d bytes is more than the %d allowed", n, max)
+	}
+
+	data := make([]byte, n)
+	_, err = io.ReadFull(br.r, data)
+
+	return data, err
+}
+
+// next returns the next block of the file and checks its checksum, nil once all were read
+func (br *bootstrapReader) next() (*Block, error) {
+	if br.read == br.count {
+		return nil, nil
+	}
+
+	data, err := br.readBytes(maxBootstrapBlockSize)
+	if err == nil {
+		checksum := make([]byte, bootstrapChecksumLength)
+		if _, err = io.ReadFull(br.r, checksum); err == nil {
+			if hash := doubleSha256(data); bytes.Equal(hash[:bootstrapChecksumLength], checksum) == false {
+				return nil, fmt.Errorf("block %d doesn't match its checksum", br.read)
+			}
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("the file ends after %d of its %d blocks", br.read, br.count)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := DeserializeBlock(data)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %s", br.read, err)
+	}
+	br.read++
+
+	return block, nil
+}
+
+// ImportChain connects the blocks of the bootstrap file to the chain in DataDir, it's
+// created from the genesis block of the file when there's none. Every block is checked
+// like a mined one before it's connected and indexed, the blocks the chain already has
+// are skipped so an interrupted import carries on where it stopped. progress is called
+// after every block with its height, the number of blocks and whether it was imported
+func ImportChain(r io.Reader, progress func(height, count int, imported bool)) (int, error) {
+	br, err := newBootstrapReader(r)
+	if err != nil {
+		return 0, err
+	}
+	if br.network.Magic != network.Active.Magic {
+		return 0, fmt.Errorf("the bootstrap file holds a %s chain, run with -network %s", br.network.Name, br.network.Name)
+	}
+
+	genesis, err := br.next()
+	if err != nil {
+		return 0, err
+	}
+
+	var chain *BlockChain
+	created := DBExits() == false
+
+	if created {
+		if chain, err = createChain(genesis); err != nil {
+			return 0, err
+		}
+	} else {
+		chain = ContinueBlockChain()
+	}
+	defer chain.Database.Close()
+
+	best := chain.GetBestHeight()
+	imported := 0
+
+	for height, block := 0, genesis; block != nil; height++ {
+		connected := height == 0 && created
+
+		if height <= best {
+			// Already in the chain, it has to be the same block
+			stored, err := chain.GetBlockByHeight(height)
+			if err != nil {
+				return imported, err
+			}
+			if bytes.Equal(stored.Hash, block.Hash) == false {
+				return imported, fmt.Errorf("block %d of the file is %x but the chain has %x, it's another chain", height, block.Hash, stored.Hash)
+			}
+		} else {
+			if err := chain.connectBlock(block, height); err != nil {
+				return imported, fmt.Errorf("block %d (%x): %s", height, block.Hash, err)
+			}
+			connected = true
+		}
+
+		if connected {
+			imported++
+		}
+		if progress != nil {
+			progress(height, br.count, connected)
+		}
+
+		if block, err = br.next(); err != nil {
+			return imported, err
+		}
+	}
+
+	return imported, nil
+}
+
+// createChain creates the database of a chain starting with the genesis block
+func createChain(genesis *Block) (*BlockChain, error) {
+	if len(genesis.PrevHash) != 0 {
+		return nil, errors.New("the first block of the file is not a genesis block")
+	}
+
+	name := genesis.Network
+	if name == "" {
+		name = network.Mainnet.Name
+	}
+	if name != network.Active.Name {
+		return nil, fmt.Errorf("the genesis block belongs to %s, not %s", name, network.Active.Name)
+	}
+
+	engine, err := NewConsensus(genesis.Consensus)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked before the database is created, the genesis seal doesn't depend on other blocks
+	chain := &BlockChain{nil, nil, engine, true}
+	if err := genesis.checkTimestamp(nil); err != nil {
+		return nil, fmt.Errorf("genesis block: %s", err)
+	}
+	if err := chain.VerifyBlock(genesis); err != nil {
+		return nil, fmt.Errorf("genesis block: %s", err)
+	}
+	for _, tx := range genesis.Transactions {
+		if err := tx.checkID(genesis.Legacy()); err != nil {
+			return nil, fmt.Errorf("genesis block: transaction %x: %s", tx.ID, err)
+		}
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(DataDir))
+	if err != nil {
+		return nil, err
+	}
+	chain.Database = db
+
+	err = db.Update(func(txn *badger.Txn) error {
+		return chain.storeGenesis(txn, genesis)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	chain.LastHash = genesis.Hash
+
+	return chain, nil
+}
+
+// connectBlock checks the block received for the height follows the last block like a
+// mined one, see checkBlock, then stores and indexes it
+func (chain *BlockChain) connectBlock(block *Block, height int) error {
+	prev, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	if err := chain.checkBlock(block, prev); err != nil {
+		return err
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+
+		if err := chain.indexBlock(txn, block, height); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.LastHash = block.Hash
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/network"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exportTestChain builds a chain of three blocks, exports it and closes it, it returns
+// the bootstrap file and the hashes of the blocks by height
+func exportTestChain(t *testing.T, dir string, addresses map[string]string) ([]byte, [][]byte) {
+	t.Helper()
+
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	chain.AddBlock([]*Transaction{NewTransaction(alice, bob, 20, chain)})
+	chain.AddBlock([]*Transaction{NewTransaction(bob, alice, 5, chain)})
+
+	var hashes [][]byte
+	for height := 0; height <= chain.GetBestHeight(); height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, block.Hash)
+	}
+
+	var file bytes.Buffer
+	if err := chain.ExportChain(&file, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Bytes(), hashes
+}
+
+// useImportDir points DataDir to an empty directory to import into
+func useImportDir(t *testing.T, dir string) {
+	t.Helper()
+
+	DataDir = filepath.Join(dir, "imported")
+	if err := os.MkdirAll(DataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportChain(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	file, hashes := exportTestChain(t, dir, addresses)
+
+	useImportDir(t, dir)
+
+	var progress []bool
+	imported, err := ImportChain(bytes.NewReader(file), func(height, count int, connected bool) {
+		if count != len(hashes) {
+			t.Errorf("the file holds %d blocks instead of %d", count, len(hashes))
+		}
+		progress = append(progress, connected)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != len(hashes) || len(progress) != len(hashes) {
+		t.Fatalf("%d of %d blocks were imported, progress was called %d times", imported, len(hashes), len(progress))
+	}
+
+	chain := ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if bytes.Equal(chain.LastHash, hashes[len(hashes)-1]) == false {
+		t.Errorf("the last block is %x instead of %x", chain.LastHash, hashes[len(hashes)-1])
+	}
+	for height, hash := range hashes {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil || bytes.Equal(block.Hash, hash) == false {
+			t.Errorf("block %d wasn't imported as %x: %v", height, hash, err)
+		}
+	}
+
+	if got := balance(t, chain, addresses["alice"]); got != 35 {
+		t.Errorf("alice has %d instead of 35", got)
+	}
+	if got := balance(t, chain, addresses["bob"]); got != 15 {
+		t.Errorf("bob has %d instead of 15", got)
+	}
+}
+
+func TestImportCorruptedChecksum(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	file, hashes := exportTestChain(t, dir, addresses)
+
+	// The checksum of the last block ends the file
+	corrupted := append([]byte{}, file...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	useImportDir(t, dir)
+
+	imported, err := ImportChain(bytes.NewReader(corrupted), nil)
+	if err == nil || strings.Contains(err.Error(), "checksum") == false {
+		t.Fatalf("the corrupted block gave the error %v", err)
+	}
+	if imported != len(hashes)-1 {
+		t.Errorf("%d blocks were imported before the corrupted one instead of %d", imported, len(hashes)-1)
+	}
+}
+
+func TestImportResumes(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	file, hashes := exportTestChain(t, dir, addresses)
+
+	useImportDir(t, dir)
+
+	// Interrupted in the last block
+	imported, err := ImportChain(bytes.NewReader(file[:len(file)-10]), nil)
+	if err == nil || strings.Contains(err.Error(), "ends after") == false {
+		t.Fatalf("the truncated file gave the error %v", err)
+	}
+	if imported != len(hashes)-1 {
+		t.Fatalf("%d blocks were imported from the truncated file instead of %d", imported, len(hashes)-1)
+	}
+
+	// The blocks the chain has are skipped
+	var connected []bool
+	imported, err = ImportChain(bytes.NewReader(file), func(height, count int, imported bool) {
+		connected = append(connected, imported)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 1 {
+		t.Errorf("%d blocks were imported again instead of the last one", imported)
+	}
+	for height, c := range connected {
+		if c != (height == len(hashes)-1) {
+			t.Errorf("block %d was imported %v", height, c)
+		}
+	}
+
+	chain := ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if chain.GetBestHeight() != len(hashes)-1 || bytes.Equal(chain.LastHash, hashes[len(hashes)-1]) == false {
+		t.Errorf("the import ended at height %d with %x", chain.GetBestHeight(), chain.LastHash)
+	}
+}
+
+// The magic in the header tells the network of the chain, files written before it
+// was recorded are read with the name of the network
+func TestImportNetworkMagic(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	file, hashes := exportTestChain(t, dir, addresses)
+
+	// The network magic follows the file magic and the one byte version
+	magicAt := len(bootstrapMagic) + 1
+	if bytes.Equal(file[magicAt:magicAt+4], network.Regtest.Magic[:]) == false {
+		t.Fatalf("the header holds the magic %x instead of %x", file[magicAt:magicAt+4], network.Regtest.Magic)
+	}
+
+	forged := append([]byte{}, file...)
+	copy(forged[magicAt:], network.Testnet.Magic[:])
+
+	useImportDir(t, dir)
+	if _, err := ImportChain(bytes.NewReader(forged), nil); err == nil || strings.Contains(err.Error(), "magic") == false {
+		t.Errorf("the file with the magic of another network gave the error %v", err)
+	}
+
+	network.Active = &network.Testnet
+	if _, err := ImportChain(bytes.NewReader(file), nil); err == nil || strings.Contains(err.Error(), "-network regtest") == false {
+		t.Errorf("the regtest file imported on testnet gave the error %v", err)
+	}
+	network.Active = &network.Regtest
+
+	// Version 1 had no network magic
+	v1 := append([]byte{}, file[:magicAt]...)
+	v1[magicAt-1] = 1
+	v1 = append(v1, file[magicAt+4:]...)
+
+	imported, err := ImportChain(bytes.NewReader(v1), nil)
+	if err != nil || imported != len(hashes) {
+		t.Fatalf("%d of %d blocks of the version 1 file were imported, %v", imported, len(hashes), err)
+	}
+
+	chain := ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if params, err := chain.storedNetwork(); err != nil || params != &network.Regtest {
+		t.Errorf("the imported chain belongs to %v, %v", params, err)
+	}
+}
+
+// storeLegacyChain stores the baseline transactions in legacy blocks gob encoded without a
+// schema version, like the binary from before hash types did, and returns the hashes
+func storeLegacyChain(t *testing.T) [][]byte {
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions(DataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var hashes [][]byte
+	prevHash := []byte{}
+
+	for _, data := range []string{baselineCoinbase, baselineSpend, baselineTwoInputs} {
+		block := &Block{Transactions: []*Transaction{decodeBaselineTransaction(t, data)}, Hash: []byte{}, PrevHash: prevHash}
+		if _, err := (&PowEngine{}).Seal(context.Background(), nil, block, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		var encoded bytes.Buffer
+		if err := gob.NewEncoder(&encoded).Encode(block); err != nil {
+			t.Fatal(err)
+		}
+		err := db.Update(func(txn *badger.Txn) error {
+			if err := txn.Set(block.Hash, encoded.Bytes()); err != nil {
+				return err
+			}
+			return txn.Set([]byte("lh"), block.Hash)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prevHash = block.Hash
+		hashes = append(hashes, block.Hash)
+	}
+
+	return hashes
+}
+
+// A chain of the binary from before hash types is upgraded, exported and imported again
+func TestExportImportLegacyChain(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	// Chains from before networks existed are mainnet chains
+	network.Active = &network.Mainnet
+	hashes := storeLegacyChain(t)
+
+	chain := ContinueBlockChain()
+	var file bytes.Buffer
+	err := chain.ExportChain(&file, nil)
+	chain.Database.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useImportDir(t, dir)
+
+	imported, err := ImportChain(&file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != len(hashes) {
+		t.Fatalf("%d of %d blocks were imported", imported, len(hashes))
+	}
+
+	chain = ContinueBlockChain()
+	defer chain.Database.Close()
+
+	for height, hash := range hashes {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil || bytes.Equal(block.Hash, hash) == false || block.Legacy() == false {
+			t.Errorf("block %d wasn't imported as the legacy block %x: %v", height, hash, err)
+		}
+	}
+	receiver := decodeBaselineTransaction(t, baselineTwoInputs).Outputs[0].PubKeyHash
+	if got := chain.GetBalance(receiver); got != 100 {
+		t.Errorf("the receiver of the last transaction has %d instead of 100", got)
+	}
+
+	// Blocks mined now follow the legacy blocks, legacy blocks can't follow them
+	chain.AddBlock(nil)
+
+	block := &Block{Hash: []byte{}, PrevHash: chain.LastHash}
+	if _, err := chain.Engine.Seal(context.Background(), chain, block, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.connectBlock(block, len(hashes)+1); err == nil {
+		t.Error("a legacy block was connected after a block with a timestamp")
+	}
+}
+
+// Mined and imported blocks follow the same rules, coins are only created by the genesis block
+func TestCoinbaseOnlyInGenesis(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice")
+	chain := newTestChain(t, dir, addresses["alice"])
+	defer chain.Database.Close()
+
+	coinbase := CoinbaseTx(addresses["alice"], "")
+	if _, _, err := chain.AddBlockContext(context.Background(), []*Transaction{coinbase}); err == nil {
+		t.Error("a block with a coinbase was mined")
+	}
+
+	prev, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{Transactions: []*Transaction{coinbase}, Hash: []byte{}, PrevHash: prev.Hash, Timestamp: prev.Timestamp + 1, Difficulty: chain.Engine.NextDifficulty(chain, prev)}
+	if _, err := chain.Engine.Seal(context.Background(), chain, block, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.connectBlock(block, 1); err == nil || strings.Contains(err.Error(), "coinbase") == false {
+		t.Errorf("the block with a coinbase gave the error %v", err)
+	}
+	if chain.GetBestHeight() != 0 {
+		t.Error("a block with a coinbase was stored")
+	}
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/wallet"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDirs runs the test on regtest, where blocks are mined instantly, with the
+// chains and wallets in a temporary directory. The returned func restores them
+func testDirs(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir, walletDir, active := DataDir, wallet.DataDir, network.Active
+	DataDir = filepath.Join(dir, "chain")
+	wallet.DataDir = filepath.Join(dir, "wallets")
+	network.Active = &network.Regtest
+
+	return dir, func() {
+		DataDir, wallet.DataDir, network.Active = dataDir, walletDir, active
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestWallets creates and saves a wallet for every name and returns their addresses
+func newTestWallets(t *testing.T, names ...string) map[string]string {
+	t.Helper()
+
+	wallets := wallet.CreateWallets()
+	addresses := make(map[string]string)
+	for _, name := range names {
+		addresses[name] = wallets.AddWallet(wallet.Secp256k1, wallet.Base58)
+	}
+	wallets.SaveFile()
+
+	return addresses
+}
+
+// newTestChain creates a chain in the directory paying the genesis reward to the address
+func newTestChain(t *testing.T, dir, address string) *BlockChain {
+	t.Helper()
+
+	DataDir = dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return InitBlockChain(address, DefaultConsensus)
+}
+
+func balance(t *testing.T, chain *BlockChain, address string) int {
+	t.Helper()
+
+	pubKeyHash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain.GetBalance(pubKeyHash)
+}
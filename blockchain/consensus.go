@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DefaultConsensus is the engine of new chains and of chains created before engines were recorded
+const DefaultConsensus = "pow"
+
+// Consensus decides how blocks are sealed and which seals are valid
+type Consensus interface {
+	// Name is recorded in the genesis block to pick the engine when the chain is opened
+	Name() string
+
+	// NextDifficulty returns the difficulty of the block following prev, prev is nil for the genesis block
+	NextDifficulty(chain *BlockChain, prev *Block) int
+
+	// Seal fills in the nonce, hash or signature of the block so it's valid,
+	// engines that mine use up to the number of workers goroutines
+	Seal(ctx context.Context, chain *BlockChain, b *Block, workers int) (MiningStats, error)
+
+	// VerifySeal checks the block was sealed by the rules of the engine
+	VerifySeal(chain *BlockChain, b *Block) error
+}
+
+var engines = map[string]func() Consensus{
+	DefaultConsensus: func() Consensus { return &PowEngine{} },
+}
+
+// RegisterConsensus makes an engine available to new and existing chains under its name
+func RegisterConsensus(name string, factory func() Consensus) {
+	engines[name] = factory
+}
+
+// NewConsensus returns the engine registered with the name
+func NewConsensus(name string) (Consensus, error) {
+	if name == "" {
+		name = DefaultConsensus
+	}
+
+	factory, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown consensus engine %q", name)
+	}
+
+	return factory(), nil
+}
+
+// ConsensusEngines returns the names of the registered engines
+func ConsensusEngines() []string {
+	var names []string
+
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// MaxDifficulty is the highest difficulty of a block, a hash has 256 bits
+const MaxDifficulty = 255
+
+// checkDifficulty checks the block has the difficulty the engine gives the block
+// following its previous block in the chain, the genesis block has the one of the
+// chain config. Legacy blocks have none and only follow legacy blocks
+func checkDifficulty(engine Consensus, chain *BlockChain, b *Block) error {
+	if b.Difficulty < 0 || b.Difficulty > MaxDifficulty {
+		return fmt.Errorf("difficulty %d is not between 0 and %d", b.Difficulty, MaxDifficulty)
+	}
+
+	if len(b.PrevHash) == 0 {
+		if b.Difficulty == 0 && b.Legacy() == false {
+			return errors.New("genesis block has no difficulty")
+		}
+		return nil
+	}
+
+	prev, err := chain.GetBlock(b.PrevHash)
+	if err != nil {
+		return err
+	}
+
+	// Legacy blocks only come before the first block mined by this binary
+	if b.Legacy() {
+		if prev.Legacy() == false {
+			return errors.New("it has no timestamp and difficulty but follows a block that has them")
+		}
+		return nil
+	}
+
+	if next := engine.NextDifficulty(chain, prev); b.Difficulty != next {
+		return fmt.Errorf("it has the difficulty %d instead of %d", b.Difficulty, next)
+	}
+
+	return nil
+}
+
+// PowEngine seals blocks with proof of work
+type PowEngine struct {
+	Hash *PowHash // Hash function the blocks are mined with, single sha256 when nil
+}
+
+// Name returns the name of the proof of work engine
+func (e *PowEngine) Name() string {
+	if e.Hash == nil || e.Hash == DefaultPowHash {
+		return DefaultConsensus
+	}
+
+	return DefaultConsensus + "-" + e.Hash.Name
+}
+
+// NextDifficulty keeps the difficulty of the previous block
+func (e *PowEngine) NextDifficulty(chain *BlockChain, prev *Block) int {
+	if prev != nil && prev.Difficulty != 0 {
+		return prev.Difficulty
+	}
+
+	return e.hash().Difficulty
+}
+
+// hash returns the hash function of the engine
+func (e *PowEngine) hash() *PowHash {
+	if e.Hash == nil {
+		return DefaultPowHash
+	}
+
+	return e.Hash
+}
+
+// proof returns the proof of work of the block with the hash function of the engine
+func (e *PowEngine) proof(b *Block) *ProofOfWork {
+	p := NewProof(b)
+	p.Hash = e.hash()
+
+	return p
+}
+
+// Seal mines the block, rolling the header whenever the nonces run out
+func (e *PowEngine) Seal(ctx context.Context, chain *BlockChain, b *Block, workers int) (MiningStats, error) {
+	p := e.proof(b) // Generate new proof of work
+
+	var total MiningStats
+
+	for {
+		n, h, stats, err := p.Mine(ctx, workers) // Returns the block hash and nonce
+
+		total.Attempts += stats.Attempts
+		total.Duration += stats.Duration
+		total.Workers = stats.Workers
+
+		// Every nonce failed for this header, change it and search again
+		if err == ErrNonceSpaceExhausted {
+			b.rollHeader()
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+
+		b.Hash = h[:]
+		b.Nonce = n
+		return total, nil
+	}
+}
+
+// VerifySeal checks the block has the expected difficulty and its hash meets the
+// target and matches its data
+func (e *PowEngine) VerifySeal(chain *BlockChain, b *Block) error {
+	if err := checkDifficulty(e, chain, b); err != nil {
+		return err
+	}
+
+	p := e.proof(b)
+
+	if p.Validate() == false {
+		return errors.New("proof of work does not meet the target")
+	}
+
+	hash := p.Hash.Sum(p.InitData(b.Nonce))
+	if bytes.Equal(hash[:], b.Hash) == false {
+		return errors.New("block hash does not match its data")
+	}
+
+	return nil
+}
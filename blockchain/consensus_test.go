@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// brokenEngine seals blocks with a hash which doesn't match their data
+type brokenEngine struct {
+	PowEngine
+}
+
+func (e *brokenEngine) Name() string {
+	return "broken"
+}
+
+func (e *brokenEngine) Seal(ctx context.Context, chain *BlockChain, b *Block, workers int) (MiningStats, error) {
+	stats, err := e.PowEngine.Seal(ctx, chain, b, workers)
+	b.Hash = bytes.Repeat([]byte{0}, 32)
+
+	return stats, err
+}
+
+func TestNewConsensus(t *testing.T) {
+	for _, name := range []string{"", DefaultConsensus} {
+		engine, err := NewConsensus(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if engine.Name() != DefaultConsensus {
+			t.Errorf("the engine %q is %s instead of %s", name, engine.Name(), DefaultConsensus)
+		}
+	}
+
+	if engine, err := NewConsensus(PoaConsensus); err != nil || engine.Name() != PoaConsensus {
+		t.Errorf("the engine %s is %v, %v", PoaConsensus, engine, err)
+	}
+
+	if _, err := NewConsensus("unknown"); err == nil {
+		t.Error("an unknown engine was created")
+	}
+}
+
+func TestRegisterConsensus(t *testing.T) {
+	RegisterConsensus("broken", func() Consensus { return &brokenEngine{} })
+	defer delete(engines, "broken")
+
+	found := false
+	for _, name := range ConsensusEngines() {
+		found = found || name == "broken"
+	}
+	if !found {
+		t.Fatalf("the registered engine is missing from %v", ConsensusEngines())
+	}
+
+	first, err := NewConsensus("broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewConsensus("broken")
+	if first == second {
+		t.Error("the chains share the same engine")
+	}
+}
+
+func TestAddBlockVerifiesSeal(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "miner", "receiver")
+	chain := newTestChain(t, dir, addresses["miner"])
+	defer chain.Database.Close()
+
+	lastHash := chain.LastHash
+	chain.Engine = &brokenEngine{}
+
+	tx := NewTransaction(addresses["miner"], addresses["receiver"], 10, chain)
+	if _, _, err := chain.AddBlockContext(context.Background(), []*Transaction{tx}); err == nil {
+		t.Fatal("a block with an invalid seal was added")
+	}
+
+	if !bytes.Equal(chain.LastHash, lastHash) {
+		t.Error("the chain moved to a block with an invalid seal")
+	}
+	if got := balance(t, chain, addresses["receiver"]); got != 0 {
+		t.Errorf("the receiver of the refused block has a balance of %d", got)
+	}
+}
+
+// The seal of a block is checked at the difficulty of the chain, not the one the block claims
+func TestVerifySealDifficulty(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "miner")
+	chain := newTestChain(t, dir, addresses["miner"])
+	defer chain.Database.Close()
+
+	prev, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := chain.Engine.NextDifficulty(chain, prev)
+
+	tests := []struct {
+		name       string
+		prevHash   []byte
+		difficulty int
+	}{
+		{"below the difficulty of the chain", prev.Hash, next - 1},
+		{"above the difficulty of the chain", prev.Hash, next + 1},
+		{"without a difficulty", prev.Hash, 0},
+		{"with a negative difficulty", prev.Hash, -1},
+		{"beyond the length of the hash", prev.Hash, MaxDifficulty + 1},
+		{"in a genesis block without a difficulty", nil, 0},
+		{"in a genesis block beyond the length of the hash", nil, 1000},
+	}
+
+	for _, test := range tests {
+		b := &Block{Hash: []byte{}, PrevHash: test.prevHash, Timestamp: prev.Timestamp + 1, Difficulty: test.difficulty}
+		if test.difficulty >= 0 && test.difficulty <= MaxDifficulty {
+			if _, err := chain.Engine.Seal(context.Background(), chain, b, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := chain.VerifyBlock(b); err == nil || strings.Contains(err.Error(), "difficulty") == false {
+			t.Errorf("a block %s gave the error %v", test.name, err)
+		}
+	}
+
+	b := &Block{Hash: []byte{}, PrevHash: prev.Hash, Timestamp: prev.Timestamp + 1, Difficulty: next}
+	if _, err := chain.Engine.Seal(context.Background(), chain, b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyBlock(b); err != nil {
+		t.Errorf("the block at the difficulty of the chain was refused: %s", err)
+	}
+}
@@ -0,0 +1,448 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/wallet"
+)
+
+// The blocks and transactions are stored and hashed with this binary encoding
+// instead of gob, it only depends on the values so other programs can reproduce
+// the transaction IDs and it doesn't change when a struct gets a new field
+//
+// Unsigned numbers are varints (LEB128), signed numbers zigzag varints, byte strings
+// and strings are prefixed with their length as a varint and optional values with
+// a byte, 1 when the value follows and 0 when it's missing. Both encodings start
+// with their version, a decoder refuses versions it doesn't know. The values added
+// in version 2 are only there in version 2, transactions and blocks without them
+// are written with version 1 so their IDs and encodings don't change
+//
+// Transaction:
+//
+//	version     uvarint, 2, or 1 when no input has a curve and there is no extra nonce
+//	id          bytes, left out when hashing the transaction for its ID
+//	inputs      uvarint count, then per input: txid bytes, out varint, signature bytes,
+//	            pubkey bytes, preimage bytes, sighash byte, curve string (version 2)
+//	outputs     uvarint count, then per output: value varint, pubkeyhash bytes, optional
+//	            HTLC of secrethash bytes, receiverhash bytes, refundhash bytes, locktime varint
+//	extranonce  uvarint (version 2)
+//
+// Block:
+//
+//	version       uvarint, 2, or 1 when the signer has no curve
+//	hash          bytes
+//	prevhash      bytes
+//	nonce         varint
+//	timestamp     varint
+//	difficulty    varint
+//	consensus     string
+//	network       string
+//	signers       uvarint count, then bytes per signer
+//	signer        bytes
+//	signature     bytes
+//	signercurve   string (version 2)
+//	vote          optional signer bytes, authorize byte
+//	transactions  uvarint count, then per transaction its encoding prefixed by its length as a uvarint
+const (
+	firstEncodingVersion = 1
+	txEncodingVersion    = 2
+	blockEncodingVersion = 2
+)
+
+// encodingKey marked the databases holding binary encoded blocks before
+// the schema version was recorded, it's replaced by the schema version
+var encodingKey = []byte("enc")
+
+var errTruncated = errors.New("the data ends before the value")
+
+// encoder appends values to a buffer in the binary encoding
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uvarint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (e *encoder) varint(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) flag(set bool) {
+	if set {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+}
+
+// decoder reads values in the binary encoding, the first error is kept
+// and every read after it returns zero values
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[size:]
+
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, size := binary.Varint(d.data)
+	if size <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[size:]
+
+	return n
+}
+
+// count reads the number of the following items, each one takes at least a byte
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail(errTruncated)
+		return 0
+	}
+
+	return int(n)
+}
+
+// bytes reads a byte string, empty ones are returned as nil
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) readByte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = errTruncated
+		return 0
+	}
+
+	b := d.data[0]
+	d.data = d.data[1:]
+
+	return b
+}
+
+func (d *decoder) flag() bool {
+	switch d.readByte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+
+	d.fail(errors.New("an optional value is neither missing nor set"))
+	return false
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// finish returns the first error of the decoder, data left after the value is an error
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d bytes left after the value", len(d.data))
+	}
+
+	return d.err
+}
+
+// encodingVersion returns the first version with all the values of the transaction
+func (t *Transaction) encodingVersion() uint64 {
+	if t.ExtraNonce != 0 {
+		return txEncodingVersion
+	}
+	for _, in := range t.Inputs {
+		if in.Curve != "" {
+			return txEncodingVersion
+		}
+	}
+
+	return firstEncodingVersion
+}
+
+// encode writes the transaction, the ID is left out when hashing the transaction for it
+func (t *Transaction) encode(e *encoder, withID bool) {
+	version := t.encodingVersion()
+
+	e.uvarint(version)
+	if withID {
+		e.bytes(t.ID)
+	}
+
+	e.uvarint(uint64(len(t.Inputs)))
+	for _, in := range t.Inputs {
+		e.bytes(in.ID)
+		e.varint(int64(in.Out))
+		e.bytes(in.Signature)
+		e.bytes(in.PubKey)
+		e.bytes(in.Preimage)
+		e.buf.WriteByte(byte(in.SigHash))
+		if version == txEncodingVersion {
+			e.string(string(in.Curve))
+		}
+	}
+
+	e.uvarint(uint64(len(t.Outputs)))
+	for _, out := range t.Outputs {
+		e.varint(int64(out.Value))
+		e.bytes(out.PubKeyHash)
+
+		e.flag(out.HTLC != nil)
+		if out.HTLC != nil {
+			e.bytes(out.HTLC.SecretHash)
+			e.bytes(out.HTLC.ReceiverHash)
+			e.bytes(out.HTLC.RefundHash)
+			e.varint(out.HTLC.LockTime)
+		}
+	}
+
+	if version == txEncodingVersion {
+		e.uvarint(t.ExtraNonce)
+	}
+}
+
+// decodeTransaction reads a transaction encoded with its ID
+func decodeTransaction(d *decoder) *Transaction {
+	version := d.uvarint()
+	if d.err == nil && version != txEncodingVersion && version != firstEncodingVersion {
+		d.fail(fmt.Errorf("unknown transaction encoding version %d", version))
+		return nil
+	}
+
+	t := &Transaction{ID: d.bytes()}
+
+	inputs := d.count()
+	for i := 0; i < inputs && d.err == nil; i++ {
+		var in TxInput
+		in.ID = d.bytes()
+		in.Out = int(d.varint())
+		in.Signature = d.bytes()
+		in.PubKey = d.bytes()
+		in.Preimage = d.bytes()
+		in.SigHash = SigHashType(d.readByte())
+		if version == txEncodingVersion {
+			in.Curve = wallet.Curve(d.string())
+		}
+
+		t.Inputs = append(t.Inputs, in)
+	}
+
+	outputs := d.count()
+	for i := 0; i < outputs && d.err == nil; i++ {
+		var out TxOutput
+		out.Value = int(d.varint())
+		out.PubKeyHash = d.bytes()
+
+		if d.flag() {
+			out.HTLC = &HTLC{}
+			out.HTLC.SecretHash = d.bytes()
+			out.HTLC.ReceiverHash = d.bytes()
+			out.HTLC.RefundHash = d.bytes()
+			out.HTLC.LockTime = d.varint()
+		}
+
+		t.Outputs = append(t.Outputs, out)
+	}
+
+	if version == txEncodingVersion {
+		t.ExtraNonce = d.uvarint()
+	}
+
+	return t
+}
+
+// DeserializeTransaction decodes a transaction serialized with Serialize
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{data: data}
+	t := decodeTransaction(d)
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decoding transaction: %s", err)
+	}
+
+	return t, nil
+}
+
+// encode writes the block with its transactions
+func (b *Block) encode(e *encoder) {
+	version := uint64(firstEncodingVersion)
+	if b.SignerCurve != "" {
+		version = blockEncodingVersion
+	}
+
+	e.uvarint(version)
+	e.bytes(b.Hash)
+	e.bytes(b.PrevHash)
+	e.varint(int64(b.Nonce))
+	e.varint(b.Timestamp)
+	e.varint(int64(b.Difficulty))
+	e.string(b.Consensus)
+	e.string(b.Network)
+
+	e.uvarint(uint64(len(b.Signers)))
+	for _, signer := range b.Signers {
+		e.bytes(signer)
+	}
+	e.bytes(b.Signer)
+	e.bytes(b.Signature)
+	if version == blockEncodingVersion {
+		e.string(string(b.SignerCurve))
+	}
+
+	e.flag(b.Vote != nil)
+	if b.Vote != nil {
+		e.bytes(b.Vote.Signer)
+		e.flag(b.Vote.Authorize)
+	}
+
+	e.uvarint(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		e.bytes(tx.Serialize())
+	}
+}
+
+// DeserializeBlock decodes a block serialized with Serialize
+func DeserializeBlock(data []byte) (*Block, error) {
+	d := &decoder{data: data}
+
+	version := d.uvarint()
+	if d.err == nil && version != blockEncodingVersion && version != firstEncodingVersion {
+		return nil, fmt.Errorf("unknown block encoding version %d", version)
+	}
+
+	b := &Block{}
+	b.Hash = d.bytes()
+	b.PrevHash = d.bytes()
+	b.Nonce = int(d.varint())
+	b.Timestamp = d.varint()
+	b.Difficulty = int(d.varint())
+	b.Consensus = d.string()
+	b.Network = d.string()
+
+	signers := d.count()
+	for i := 0; i < signers && d.err == nil; i++ {
+		b.Signers = append(b.Signers, d.bytes())
+	}
+	b.Signer = d.bytes()
+	b.Signature = d.bytes()
+	if version == blockEncodingVersion {
+		b.SignerCurve = wallet.Curve(d.string())
+	}
+
+	if d.flag() {
+		b.Vote = &SignerVote{Signer: d.bytes(), Authorize: d.flag()}
+	}
+
+	txs := d.count()
+	for i := 0; i < txs && d.err == nil; i++ {
+		tx, err := DeserializeTransaction(d.bytes())
+		if err != nil {
+			d.fail(err)
+			break
+		}
+
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decoding block: %s", err)
+	}
+
+	return b, nil
+}
+
+// migrateBinaryEncoding converts the blocks of the databases created before the
+// binary encoding, blocks already converted by an interrupted run are left alone
+func (chain *BlockChain) migrateBinaryEncoding() error {
+	if chain.hasKey(encodingKey) {
+		return chain.Database.Update(func(txn *badger.Txn) error {
+			return txn.Delete(encodingKey)
+		})
+	}
+
+	hash := chain.LastHash
+	for len(hash) > 0 {
+		var block *Block
+
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(hash)
+			if err != nil {
+				return err
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if block, err = DeserializeBlock(val); err == nil {
+				return nil
+			}
+
+			if block, err = deserializeGob(val); err != nil {
+				return err
+			}
+
+			return txn.Set(hash, block.Serialize())
+		})
+		if err != nil {
+			return fmt.Errorf("converting block %x: %s", hash, err)
+		}
+
+		hash = block.PrevHash
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/wallet"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// goldenTransactions returns a transaction of each encoding version with every value set
+func goldenTransactions() (v1, v2 *Transaction) {
+	htlc := &HTLC{bytes.Repeat([]byte{0x31}, 32), bytes.Repeat([]byte{0x32}, 20), bytes.Repeat([]byte{0x33}, 20), -1600000000}
+
+	v1 = &Transaction{nil,
+		[]TxInput{
+			{bytes.Repeat([]byte{0x11}, 32), 1, []byte{0x30, 0x44}, []byte{0x02, 0x21}, nil, SigHashAll, ""},
+			{bytes.Repeat([]byte{0x12}, 32), 0, []byte{0x30}, []byte{0x03}, []byte("secret"), SigHashLegacy, ""},
+		},
+		[]TxOutput{{-5, bytes.Repeat([]byte{0x21}, 20), nil}, {300, nil, htlc}},
+		0,
+	}
+	v1.SetID()
+
+	v2 = &Transaction{nil,
+		[]TxInput{{bytes.Repeat([]byte{0x13}, 32), 2, []byte{0x40}, []byte{0x04}, nil, SigHashSingle | SigHashAnyoneCanPay, wallet.Schnorr}},
+		[]TxOutput{{70, bytes.Repeat([]byte{0x22}, 20), nil}},
+		300,
+	}
+	v2.SetID()
+
+	return v1, v2
+}
+
+// goldenBlock returns a block with every value set, with a signer curve when version is 2
+func goldenBlock(version int) *Block {
+	v1, v2 := goldenTransactions()
+
+	b := &Block{
+		Timestamp:    1600000000,
+		Hash:         bytes.Repeat([]byte{0x41}, 32),
+		Transactions: []*Transaction{v1, v2},
+		PrevHash:     bytes.Repeat([]byte{0x42}, 32),
+		Nonce:        -3,
+		Difficulty:   12,
+		Consensus:    PoaConsensus,
+		Network:      "regtest",
+		Signers:      [][]byte{{0x51}, {0x52, 0x53}},
+		Signer:       []byte{0x54},
+		Signature:    []byte{0x55, 0x56},
+		Vote:         &SignerVote{[]byte{0x57}, true},
+	}
+	if version == blockEncodingVersion {
+		b.SignerCurve = wallet.Secp256k1
+	}
+
+	return b
+}
+
+func TestGoldenTransactionEncoding(t *testing.T) {
+	v1, v2 := goldenTransactions()
+
+	golden := []struct {
+		tx       *Transaction
+		version  uint64
+		id       string
+		encoding string
+	}{
+		{v1, firstEncodingVersion, "720cd299b7a9b079445feeb309e68714cf92e5c95ce5ef6d2d95921f5b0e8188",
+			"0120720cd299b7a9b079445feeb309e68714cf92e5c95ce5ef6d2d95921f5b0e81880220111111111111111111111111" +
+				"111111111111111111111111111111111111111102023044020221000120121212121212121212121212121212121212" +
+				"121212121212121212121212121200013001030673656372657400020914212121212121212121212121212121212121" +
+				"212100d80400012031313131313131313131313131313131313131313131313131313131313131311432323232323232" +
+				"32323232323232323232323232143333333333333333333333333333333333333333ffbff0f50b"},
+		{v2, txEncodingVersion, "8cdbc01bf62fdddcdd4edfed7f54fac89d9335ff841f52115108a13ddde10646",
+			"02208cdbc01bf62fdddcdd4edfed7f54fac89d9335ff841f52115108a13ddde106460120131313131313131313131313" +
+				"131313131313131313131313131313131313131304014001040083077363686e6f7272018c0114222222222222222222" +
+				"222222222222222222222200ac02"},
+	}
+
+	for _, g := range golden {
+		if got := g.tx.encodingVersion(); got != g.version {
+			t.Errorf("the transaction is encoded with version %d instead of %d", got, g.version)
+		}
+		if id := hex.EncodeToString(g.tx.ID); id != g.id {
+			t.Errorf("the version %d transaction has the ID %s instead of %s", g.version, id, g.id)
+		}
+
+		encoding := g.tx.Serialize()
+		if hex.EncodeToString(encoding) != g.encoding {
+			t.Errorf("the version %d transaction is encoded as %x instead of %s", g.version, encoding, g.encoding)
+		}
+
+		decoded, err := DeserializeTransaction(encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(decoded, g.tx) == false {
+			t.Errorf("the version %d transaction decodes to %+v", g.version, decoded)
+		}
+	}
+}
+
+func TestGoldenBlockEncoding(t *testing.T) {
+	golden := []struct {
+		version  int
+		txsHash  string
+		encoding string
+	}{
+		{firstEncodingVersion, "3377ec1773cf1f77154018e8dae15b35430a1b5ff86f2b6746a1a241150ad425",
+			"012041414141414141414141414141414141414141414141414141414141414141412042424242424242424242424242" +
+				"424242424242424242424242424242424242420580c0f0f50b1803706f61077265677465737402015102525301540255" +
+				"560101570102e7010120720cd299b7a9b079445feeb309e68714cf92e5c95ce5ef6d2d95921f5b0e8188022011111111" +
+				"111111111111111111111111111111111111111111111111111111110202304402022100012012121212121212121212" +
+				"121212121212121212121212121212121212121212120001300103067365637265740002091421212121212121212121" +
+				"2121212121212121212100d8040001203131313131313131313131313131313131313131313131313131313131313131" +
+				"143232323232323232323232323232323232323232143333333333333333333333333333333333333333ffbff0f50b6e" +
+				"02208cdbc01bf62fdddcdd4edfed7f54fac89d9335ff841f52115108a13ddde106460120131313131313131313131313" +
+				"131313131313131313131313131313131313131304014001040083077363686e6f7272018c0114222222222222222222" +
+				"222222222222222222222200ac02"},
+		{blockEncodingVersion, "3377ec1773cf1f77154018e8dae15b35430a1b5ff86f2b6746a1a241150ad425",
+			"022041414141414141414141414141414141414141414141414141414141414141412042424242424242424242424242" +
+				"424242424242424242424242424242424242420580c0f0f50b1803706f61077265677465737402015102525301540255" +
+				"5609736563703235366b310101570102e7010120720cd299b7a9b079445feeb309e68714cf92e5c95ce5ef6d2d95921f" +
+				"5b0e81880220111111111111111111111111111111111111111111111111111111111111111102023044020221000120" +
+				"121212121212121212121212121212121212121212121212121212121212121200013001030673656372657400020914" +
+				"212121212121212121212121212121212121212100d80400012031313131313131313131313131313131313131313131" +
+				"313131313131313131311432323232323232323232323232323232323232321433333333333333333333333333333333" +
+				"33333333ffbff0f50b6e02208cdbc01bf62fdddcdd4edfed7f54fac89d9335ff841f52115108a13ddde1064601201313" +
+				"13131313131313131313131313131313131313131313131313131313131304014001040083077363686e6f7272018c01" +
+				"14222222222222222222222222222222222222222200ac02"},
+	}
+
+	for _, g := range golden {
+		b := goldenBlock(g.version)
+
+		if hash := hex.EncodeToString(b.HashTransactions()); hash != g.txsHash {
+			t.Errorf("the transactions of the version %d block hash to %s instead of %s", g.version, hash, g.txsHash)
+		}
+
+		encoding := b.Serialize()
+		if hex.EncodeToString(encoding) != g.encoding {
+			t.Errorf("the version %d block is encoded as %x instead of %s", g.version, encoding, g.encoding)
+		}
+
+		decoded, err := DeserializeBlock(encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(decoded, b) == false {
+			t.Errorf("the version %d block decodes to %+v", g.version, decoded)
+		}
+	}
+}
+
+func TestDecodeRefusesBadEncodings(t *testing.T) {
+	v1, _ := goldenTransactions()
+	tx := v1.Serialize()
+	block := goldenBlock(blockEncodingVersion).Serialize()
+
+	bad := map[string][]byte{
+		"a transaction of an unknown version": append([]byte{txEncodingVersion + 1}, tx[1:]...),
+		"a truncated transaction":             tx[:len(tx)-1],
+		"a transaction with trailing data":    append(append([]byte{}, tx...), 0),
+	}
+	for name, data := range bad {
+		if _, err := DeserializeTransaction(data); err == nil {
+			t.Errorf("%s was decoded", name)
+		}
+	}
+
+	bad = map[string][]byte{
+		"a block of an unknown version": append([]byte{blockEncodingVersion + 1}, block[1:]...),
+		"a truncated block":             block[:len(block)-1],
+		"a block with trailing data":    append(append([]byte{}, block...), 0),
+	}
+	for name, data := range bad {
+		if _, err := DeserializeBlock(data); err == nil {
+			t.Errorf("%s was decoded", name)
+		}
+	}
+}
+
+// storeGobBlocks rewrites the blocks of the chain with gob like before the binary
+// encoding, skipping the last skip blocks, and removes the schema version
+func storeGobBlocks(t *testing.T, chain *BlockChain, skip int) {
+	t.Helper()
+
+	iter := chain.Iterator()
+	for height := 0; ; height++ {
+		block := iter.Next()
+
+		if height >= skip {
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(block); err != nil {
+				t.Fatal(err)
+			}
+			err := chain.Database.Update(func(txn *badger.Txn) error {
+				return txn.Set(block.Hash, encoded.Bytes())
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(schemaKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBinaryEncoding(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "miner", "receiver")
+
+	// Every block in gob, and a run interrupted after converting the last block
+	for _, skip := range []int{0, 1} {
+		chain := newTestChain(t, filepath.Join(dir, fmt.Sprintf("chain%d", skip)), addresses["miner"])
+		for i := 0; i < 2; i++ {
+			tx := NewTransaction(addresses["miner"], addresses["receiver"], 10, chain)
+			chain.AddBlock([]*Transaction{tx})
+		}
+
+		var blocks [][]byte
+		for iter := chain.Iterator(); ; {
+			block := iter.Next()
+			blocks = append(blocks, block.Serialize())
+			if len(block.PrevHash) == 0 {
+				break
+			}
+		}
+
+		storeGobBlocks(t, chain, skip)
+		chain.Database.Close()
+
+		chain = ContinueBlockChain()
+
+		if version, err := chain.storedSchemaVersion(); err != nil || version != schemaVersion {
+			t.Errorf("the schema version is %d instead of %d, %v", version, schemaVersion, err)
+		}
+
+		i := 0
+		for iter := chain.Iterator(); ; i++ {
+			block := iter.Next()
+
+			var stored []byte
+			err := chain.Database.View(func(txn *badger.Txn) error {
+				item, err := txn.Get(block.Hash)
+				if err != nil {
+					return err
+				}
+				stored, err = item.ValueCopy(nil)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(stored, blocks[i]) == false {
+				t.Errorf("block %x isn't stored with the binary encoding it had", block.Hash)
+			}
+
+			if len(block.PrevHash) == 0 {
+				break
+			}
+		}
+
+		if got := balance(t, chain, addresses["receiver"]); got != 20 {
+			t.Errorf("the receiver has a balance of %d after the migration", got)
+		}
+
+		chain.Database.Close()
+	}
+}
+
+// Databases converted before the schema version was recorded are marked with the encoding key
+func TestMigrateEncodingKey(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "miner")
+	chain := newTestChain(t, dir, addresses["miner"])
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(schemaKey); err != nil {
+			return err
+		}
+		return txn.Set(encodingKey, []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Database.Close()
+
+	chain = ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if chain.hasKey(encodingKey) {
+		t.Error("the encoding key was kept")
+	}
+	if version, err := chain.storedSchemaVersion(); err != nil || version != schemaVersion {
+		t.Errorf("the schema version is %d instead of %d, %v", version, schemaVersion, err)
+	}
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/wallet"
+)
+
+// GenesisConfig configures the first block of a chain, the same configuration
+// always produces the same genesis block and hash
+type GenesisConfig struct {
+	Network    string         `json:"network"`    // Name of the network the chain belongs to
+	Consensus  string         `json:"consensus"`  // Consensus engine sealing the blocks
+	Difficulty int            `json:"difficulty"` // Initial difficulty, the engine default when 0
+	Reward     int            `json:"reward"`     // Coins allocated to the address creating the chain when there are no allocations, only the genesis block pays it
+	Timestamp  int64          `json:"timestamp"`  // Unix time of the genesis block
+	Message    string         `json:"message"`    // Data of the genesis coinbase input
+	Alloc      []GenesisAlloc `json:"alloc"`      // Coins paid out by the genesis block
+	Signers    []string       `json:"signers"`    // First proof of authority signers
+}
+
+// GenesisAlloc is an amount paid to an address by the genesis block
+type GenesisAlloc struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// DefaultGenesis pays the reward of the active network to the address at the current time
+func DefaultGenesis(address, consensus string) *GenesisConfig {
+	params := network.Active
+
+	return &GenesisConfig{
+		Network:    params.Name,
+		Consensus:  consensus,
+		Difficulty: params.Difficulty,
+		Reward:     params.Reward,
+		Timestamp:  time.Now().Unix(),
+		Message:    params.GenesisData,
+		Alloc:      []GenesisAlloc{{address, params.Reward}},
+	}
+}
+
+// LoadGenesis reads a genesis configuration from a JSON file
+func LoadGenesis(file string) (*GenesisConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var g GenesisConfig
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&g); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	// Missing values are taken from the active network
+	if g.Network == "" {
+		g.Network = network.Active.Name
+	}
+	if g.Message == "" {
+		g.Message = network.Active.GenesisData
+	}
+	if g.Reward == 0 {
+		g.Reward = network.Active.Reward
+	}
+
+	return &g, nil
+}
+
+// Validate checks the configuration can create a chain
+func (g *GenesisConfig) Validate() error {
+	if g.Network != network.Active.Name {
+		return fmt.Errorf("the genesis block is for %s, run with -network %s", g.Network, g.Network)
+	}
+
+	if _, err := NewConsensus(g.Consensus); err != nil {
+		return err
+	}
+
+	if g.Difficulty < 0 || g.Difficulty > MaxDifficulty {
+		return fmt.Errorf("difficulty %d is not between 0 and %d", g.Difficulty, MaxDifficulty)
+	}
+	if g.Reward < 0 {
+		return fmt.Errorf("reward %d is negative", g.Reward)
+	}
+	if g.Timestamp < 0 {
+		return fmt.Errorf("timestamp %d is negative", g.Timestamp)
+	}
+	if g.Timestamp > time.Now().Unix()+MaxFutureBlockTime {
+		return fmt.Errorf("timestamp %d is more than %d seconds ahead of the clock", g.Timestamp, MaxFutureBlockTime)
+	}
+
+	if len(g.Alloc) == 0 {
+		return fmt.Errorf("the genesis block allocates no coins")
+	}
+	for _, alloc := range g.Alloc {
+		if err := wallet.ValidateAddress(alloc.Address); err != nil {
+			return fmt.Errorf("allocation: %s", err)
+		}
+		if alloc.Amount <= 0 {
+			return fmt.Errorf("allocation of %d to %s is not positive", alloc.Amount, alloc.Address)
+		}
+	}
+
+	if len(g.Signers) > 0 && g.Consensus != PoaConsensus {
+		return fmt.Errorf("signers are only used by the %s consensus", PoaConsensus)
+	}
+	for _, signer := range g.Signers {
+		if err := wallet.ValidateAddress(signer); err != nil {
+			return fmt.Errorf("signer: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// coinbase returns the transaction paying the allocations
+func (g *GenesisConfig) coinbase() *Transaction {
+	var outputs []TxOutput
+
+	for _, alloc := range g.Alloc {
+		out, err := NewTxOutput(alloc.Amount, alloc.Address)
+		Handle(err)
+
+		outputs = append(outputs, *out)
+	}
+
+	txin := TxInput{[]byte{}, -1, nil, []byte(g.Message), nil, SigHashLegacy, ""}
+
+	tx := Transaction{nil, []TxInput{txin}, outputs, 0}
+	tx.SetID()
+
+	return &tx
+}
+
+// signers returns the public key hashes of the signers
+func (g *GenesisConfig) signers() [][]byte {
+	var signers [][]byte
+
+	for _, signer := range g.Signers {
+		pubKeyHash, err := wallet.DecodeAddress(signer)
+		Handle(err)
+
+		signers = append(signers, pubKeyHash)
+	}
+
+	return signers
+}
@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The genesis block is mined by a single worker whatever the number of miners
+func TestGenesisHashIsReproducible(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	defer func(workers int) { MinerWorkers = workers }(MinerWorkers)
+	MinerWorkers = 4
+
+	addresses := newTestWallets(t, "miner")
+	genesis := DefaultGenesis(addresses["miner"], DefaultConsensus)
+	genesis.Difficulty = 12
+
+	var hashes [][]byte
+	for _, name := range []string{"first", "second"} {
+		DataDir = filepath.Join(dir, name)
+		if err := os.MkdirAll(DataDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		chain := InitBlockChainGenesis(genesis)
+		hashes = append(hashes, chain.LastHash)
+		chain.Database.Close()
+	}
+
+	if !bytes.Equal(hashes[0], hashes[1]) {
+		t.Errorf("the same genesis configuration created the blocks %x and %x", hashes[0], hashes[1])
+	}
+	if MinerWorkers != 4 {
+		t.Errorf("creating the chain changed the miners to %d", MinerWorkers)
+	}
+}
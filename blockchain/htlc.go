@@ -0,0 +1,157 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sheghun/blockchain/wallet"
+	"time"
+)
+
+// HTLC holds the terms of a hash time-locked contract output
+// The receiver redeems the output by revealing the preimage of SecretHash,
+// after LockTime has passed the sender can take the coins back instead
+type HTLC struct {
+	SecretHash   []byte // sha256 hash of the secret
+	ReceiverHash []byte // Public key hash allowed to redeem with the secret
+	RefundHash   []byte // Public key hash allowed to refund after LockTime
+	LockTime     int64  // Unix time after which the output can be refunded
+}
+
+// NewHTLCOutput creates an output that can be redeemed by the receiver
+// with the preimage of secretHash or refunded to the sender after lockTime
+func NewHTLCOutput(value int, to, refund string, secretHash []byte, lockTime int64) (*TxOutput, error) {
+	receiverHash, err := wallet.DecodeAddress(to)
+	if err != nil {
+		return nil, err
+	}
+
+	refundHash, err := wallet.DecodeAddress(refund)
+	if err != nil {
+		return nil, err
+	}
+
+	htlc := &HTLC{secretHash, receiverHash, refundHash, lockTime}
+
+	return &TxOutput{value, nil, htlc}, nil
+}
+
+// Unlocks checks if the preimage and public key of the input
+// satisfy the contract at the supplied unix time
+func (h *HTLC) Unlocks(in *TxInput, now int64) bool {
+	// Redeem path, the receiver reveals the secret
+	if len(in.Preimage) > 0 {
+		secretHash := sha256.Sum256(in.Preimage)
+
+		return bytes.Compare(secretHash[:], h.SecretHash) == 0 && in.UsesKey(h.ReceiverHash)
+	}
+
+	// Refund path, only once the lock time has passed
+	return now >= h.LockTime && in.UsesKey(h.RefundHash)
+}
+
+// NewHTLCTransaction locks the amount from the sender in a hash time-locked
+// contract, the change goes back to the sender like a normal transaction
+func NewHTLCTransaction(from, to string, amount int, secretHash []byte, lockTime int64, chain *BlockChain) *Transaction {
+	wallets := wallet.CreateWallets()
+	w := wallets.GetWallet(from)
+
+	contract, err := NewHTLCOutput(amount, to, from, secretHash, lockTime)
+	Handle(err)
+
+	acc, inputs := fundingInputs(w, amount, chain)
+
+	var outputs []TxOutput
+	outputs = append(outputs, *contract)
+
+	if acc > amount {
+		change, err := NewTxOutput(acc-amount, from)
+		Handle(err)
+
+		outputs = append(outputs, *change)
+	}
+
+	txn := &Transaction{nil, inputs, outputs, 0}
+	chain.SignTransaction(txn, w)
+	txn.SetID()
+
+	return txn
+}
+
+// NewHTLCRedeem spends a hash time-locked output to the supplied address
+// With a preimage the receiver claims the output, without one the sender refunds it
+func NewHTLCRedeem(txID []byte, out int, preimage []byte, to string, chain *BlockChain) *Transaction {
+	htlcTx, err := chain.FindHTLC(txID, out)
+	Handle(err)
+
+	htlcOut := htlcTx.Outputs[out]
+	h := htlcOut.HTLC
+
+	owner := h.RefundHash
+	if len(preimage) > 0 {
+		secretHash := sha256.Sum256(preimage)
+		if bytes.Compare(secretHash[:], h.SecretHash) != 0 {
+			Handle(errors.New("preimage does not match the contract secret hash"))
+		}
+		owner = h.ReceiverHash
+	} else if time.Now().Unix() < h.LockTime {
+		Handle(fmt.Errorf("contract can not be refunded before %s", time.Unix(h.LockTime, 0)))
+	}
+
+	wallets := wallet.CreateWallets()
+	w, address := wallets.GetWalletByPubKeyHash(owner)
+	if w == nil {
+		Handle(errors.New("no wallet found for the contract"))
+	}
+
+	if to == "" {
+		to = address
+	}
+
+	redeemed, err := NewTxOutput(htlcOut.Value, to)
+	Handle(err)
+
+	inputs := []TxInput{{txID, out, nil, w.PublicKey, preimage, SigHashLegacy, w.Curve}}
+	outputs := []TxOutput{*redeemed}
+
+	txn := &Transaction{nil, inputs, outputs, 0}
+	chain.SignTransaction(txn, *w)
+	txn.SetID()
+
+	return txn
+}
+
+// FindHTLC returns the transaction holding the hash time-locked output
+// it returns an error if the output does not exist or was already spent
+func (chain *BlockChain) FindHTLC(txID []byte, out int) (Transaction, error) {
+	tx, err := chain.FindTransaction(txID)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	if out < 0 || out >= len(tx.Outputs) || tx.Outputs[out].HTLC == nil {
+		return Transaction{}, fmt.Errorf("output %d of transaction %x is not a contract", out, txID)
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, t := range block.Transactions {
+			for _, in := range t.Inputs {
+				if bytes.Compare(in.ID, txID) == 0 && in.Out == out {
+					return Transaction{}, fmt.Errorf("contract was already spent in transaction %s", hex.EncodeToString(t.ID))
+				}
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return tx, nil
+}
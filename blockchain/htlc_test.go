@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/sheghun/blockchain/wallet"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAtomicSwap(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chainA := newTestChain(t, filepath.Join(dir, "a"), alice)
+	defer chainA.Database.Close()
+	chainB := newTestChain(t, filepath.Join(dir, "b"), bob)
+	defer chainB.Database.Close()
+
+	secret := []byte("the secret of the swap")
+	secretHash := sha256.Sum256(secret)
+	day := int64(24 * 60 * 60)
+	now := time.Now().Unix()
+
+	// Alice locks her coins for longer so bob has time to claim once she revealed the secret
+	lockA := NewHTLCTransaction(alice, bob, 40, secretHash[:], now+2*day, chainA)
+	chainA.AddBlock([]*Transaction{lockA})
+
+	lockB := NewHTLCTransaction(bob, alice, 30, secretHash[:], now+day, chainB)
+	chainB.AddBlock([]*Transaction{lockB})
+
+	// Alice claims on chain B with the secret
+	claimB := NewHTLCRedeem(lockB.ID, 0, secret, "", chainB)
+	chainB.AddBlock([]*Transaction{claimB})
+
+	// Bob reads the secret from her claim and claims on chain A
+	block, err := chainB.GetBlock(chainB.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed := block.Transactions[0].Inputs[0].Preimage
+
+	claimA := NewHTLCRedeem(lockA.ID, 0, revealed, "", chainA)
+	chainA.AddBlock([]*Transaction{claimA})
+
+	expected := []struct {
+		chain   *BlockChain
+		name    string
+		address string
+		balance int
+	}{
+		{chainA, "alice on chain A", alice, 10},
+		{chainA, "bob on chain A", bob, 40},
+		{chainB, "alice on chain B", alice, 30},
+		{chainB, "bob on chain B", bob, 20},
+	}
+	for _, e := range expected {
+		if b := balance(t, e.chain, e.address); b != e.balance {
+			t.Errorf("%s has %d instead of %d", e.name, b, e.balance)
+		}
+	}
+
+	if _, err := chainA.FindHTLC(lockA.ID, 0); err == nil {
+		t.Error("the contract on chain A can still be redeemed")
+	}
+	if _, err := chainB.FindHTLC(lockB.ID, 0); err == nil {
+		t.Error("the contract on chain B can still be redeemed")
+	}
+}
+
+func TestHTLCRefundAtBlockTime(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, filepath.Join(dir, "a"), alice)
+	defer chain.Database.Close()
+
+	secretHash := sha256.Sum256([]byte("never revealed"))
+	lockTime := int64(1700000000)
+
+	lock := NewHTLCTransaction(alice, bob, 20, secretHash[:], lockTime, chain)
+	chain.AddBlock([]*Transaction{lock})
+
+	refund := NewHTLCRedeem(lock.ID, 0, nil, "", chain)
+
+	// The refund is valid in the blocks mined once the lock time passed, whatever the clock says
+	if err := chain.VerifyTransactions([]*Transaction{refund}, lockTime-1, false); err == nil {
+		t.Error("a refund was accepted in a block mined before the lock time")
+	}
+	if err := chain.VerifyTransactions([]*Transaction{refund}, lockTime, false); err != nil {
+		t.Errorf("a refund was refused in a block mined at the lock time: %s", err)
+	}
+
+	chain.AddBlock([]*Transaction{refund})
+	if b := balance(t, chain, alice); b != 50 {
+		t.Errorf("alice has %d instead of 50 once refunded", b)
+	}
+}
+
+// A miner can't pick the timestamp of a block to refund a contract before its lock time
+func TestHTLCRefundBlockTimestamps(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	secretHash := sha256.Sum256([]byte("the secret"))
+	lockTime := time.Now().Unix() + 24*60*60
+
+	lock := NewHTLCTransaction(alice, bob, 20, secretHash[:], lockTime, chain)
+	chain.AddBlock([]*Transaction{lock})
+
+	// NewHTLCRedeem refuses refunds before the lock time, so alice builds hers by hand
+	w := wallet.CreateWallets().GetWallet(alice)
+	refundOut, err := NewTxOutput(20, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund := &Transaction{nil, []TxInput{{lock.ID, 0, nil, w.PublicKey, nil, SigHashLegacy, w.Curve}}, []TxOutput{*refundOut}, 0}
+	chain.SignTransaction(refund, w)
+	refund.SetID()
+
+	prev, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamps := []struct {
+		name      string
+		timestamp int64
+	}{
+		{"at the lock time, a day ahead of the clock", lockTime},
+		{"without a timestamp", 0},
+		{"at the timestamp of the previous block", prev.Timestamp},
+		{"before the previous block", prev.Timestamp - 1},
+	}
+	for _, ts := range timestamps {
+		block := &Block{Transactions: []*Transaction{refund}, Hash: []byte{}, PrevHash: prev.Hash, Timestamp: ts.timestamp, Difficulty: chain.Engine.NextDifficulty(chain, prev)}
+		if _, err := chain.Engine.Seal(context.Background(), chain, block, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := chain.connectBlock(block, 2); err == nil {
+			t.Errorf("a refund block %s was connected", ts.name)
+		}
+	}
+
+	if chain.GetBestHeight() != 1 {
+		t.Error("a block with a wrong timestamp was stored")
+	}
+	if got := balance(t, chain, alice); got != 30 {
+		t.Errorf("alice has %d instead of 30", got)
+	}
+}
@@ -0,0 +1,689 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/wallet"
+)
+
+// Index keys are prefixed and never 32 bytes long so they can't collide with the block hashes,
+// public key hashes are prefixed with their length so one is never the start of another
+var (
+	txIndexPrefix     = []byte("t")           // t + transaction ID -> block hash and position
+	addrIndexPrefix   = []byte("a")           // a + public key hash + height + position -> transaction touching the address
+	addrOutputPrefix  = []byte("u")           // u + public key hash + transaction ID + output -> coins paid to the address
+	heightIndexPrefix = []byte("h")           // h + height -> block hash
+	blockHeightPrefix = []byte("n")           // n + block hash -> height
+	indexedKey        = []byte("ix")          // Version of the indexes built before they were part of the schema
+	txIndexedKey      = []byte("ixt")         // Set when the transaction index is built
+	txIndexSettingKey = []byte("cfg.txindex") // 1 when the transaction index is turned on, 0 when off
+	reindexKey        = []byte("reindex")     // Set while the indexes are rebuilt
+)
+
+// lastIndexVersion is the version of the indexes when they became part of the schema,
+// changes to the index entries are now migrations rebuilding them
+const lastIndexVersion = byte(3)
+
+// Direction tells if coins came in or went out of an address in a transaction
+type Direction int
+
+const (
+	Incoming Direction = iota // The address received more than it spent
+	Outgoing                  // The address spent more than it received
+	Internal                  // Nothing moved, e.g. a contract naming the address
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Incoming:
+		return "incoming"
+	case Outgoing:
+		return "outgoing"
+	default:
+		return "internal"
+	}
+}
+
+// AddressTx is an entry of the address index
+type AddressTx struct {
+	TxID      []byte    // Transaction touching the address
+	BlockHash []byte    // Block the transaction was mined in
+	Direction Direction // Whether coins came in or went out
+	Amount    int       // Coins that came in or went out
+}
+
+// TxLocation is an entry of the transaction index
+type TxLocation struct {
+	BlockHash []byte
+	Index     int // Position of the transaction in the block
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+// addrKey returns the prefix of the keys of the address in the index with the prefix
+func addrKey(prefix, pubKeyHash []byte) []byte {
+	key := append(append([]byte{}, prefix...), byte(len(pubKeyHash)))
+	return append(key, pubKeyHash...)
+}
+
+func addrIndexKey(pubKeyHash []byte, height, position int) []byte {
+	key := append(addrKey(addrIndexPrefix, pubKeyHash), ToHex(int64(height))...)
+	return append(key, ToHex(int64(position))[4:]...)
+}
+
+func addrOutputKey(pubKeyHash, txID []byte, out int) []byte {
+	key := append(addrKey(addrOutputPrefix, pubKeyHash), txID...)
+	return append(key, ToHex(int64(out))[4:]...)
+}
+
+func heightIndexKey(height int) []byte {
+	return append(append([]byte{}, heightIndexPrefix...), ToHex(int64(height))...)
+}
+
+func blockHeightKey(hash []byte) []byte {
+	return append(append([]byte{}, blockHeightPrefix...), hash...)
+}
+
+// readHeight returns the height of the block with the hash
+func readHeight(txn *badger.Txn, hash []byte) (int, error) {
+	var height int
+
+	item, err := txn.Get(blockHeightKey(hash))
+	if err == badger.ErrKeyNotFound {
+		return 0, fmt.Errorf("block %x is not in the chain", hash)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		height = int(binary.BigEndian.Uint64(val))
+		return nil
+	})
+
+	return height, err
+}
+
+// touchedAddresses returns the public key hashes the transaction sends to or spends from
+func touchedAddresses(tx *Transaction) [][]byte {
+	var hashes [][]byte
+
+	add := func(hash []byte) {
+		if len(hash) == 0 {
+			return
+		}
+		for _, h := range hashes {
+			if bytes.Equal(h, hash) {
+				return
+			}
+		}
+		hashes = append(hashes, hash)
+	}
+
+	for _, out := range tx.Outputs {
+		add(out.PubKeyHash)
+		if out.HTLC != nil {
+			add(out.HTLC.ReceiverHash)
+			add(out.HTLC.RefundHash)
+		}
+	}
+
+	if tx.IsCoinbase() == false {
+		for _, in := range tx.Inputs {
+			add(wallet.PublicKeyHash(in.PubKey))
+		}
+	}
+
+	return hashes
+}
+
+// indexBlock adds the block at the height to the height index
+// and its transactions to the transaction and address indexes
+func (chain *BlockChain) indexBlock(txn *badger.Txn, block *Block, height int) error {
+	if err := txn.Set(heightIndexKey(height), block.Hash); err != nil {
+		return err
+	}
+
+	if err := txn.Set(blockHeightKey(block.Hash), ToHex(int64(height))); err != nil {
+		return err
+	}
+
+	if chain.TxIndex {
+		for i, tx := range block.Transactions {
+			loc := TxLocation{block.Hash, i}
+			if err := txn.Set(txIndexKey(tx.ID), encodeIndex(loc)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return indexAddresses(txn, block, height)
+}
+
+// indexAddresses adds an entry for every address a transaction of the block touches
+// and one for every output paying an address, nothing already stored is rewritten
+func indexAddresses(txn *badger.Txn, block *Block, height int) error {
+	for i, tx := range block.Transactions {
+		changes, err := addressChanges(txn, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, pubKeyHash := range touchedAddresses(tx) {
+			entry := AddressTx{tx.ID, block.Hash, Internal, 0}
+			if change := changes[string(pubKeyHash)]; change > 0 {
+				entry.Direction, entry.Amount = Incoming, change
+			} else if change < 0 {
+				entry.Direction, entry.Amount = Outgoing, -change
+			}
+
+			if err := txn.Set(addrIndexKey(pubKeyHash, height, i), encodeIndex(entry)); err != nil {
+				return err
+			}
+		}
+
+		for out, output := range tx.Outputs {
+			for owner, value := range outputOwners(output) {
+				if err := txn.Set(addrOutputKey([]byte(owner), tx.ID, out), ToHex(int64(value))); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// outputOwners returns the public key hashes able to spend the output with the coins
+// paid to each. The receiver and sender of a contract can spend it, the coins count once it's redeemed
+func outputOwners(output TxOutput) map[string]int {
+	owners := map[string]int{string(output.PubKeyHash): output.Value}
+	if output.HTLC != nil {
+		owners = map[string]int{string(output.HTLC.ReceiverHash): 0, string(output.HTLC.RefundHash): 0}
+	}
+	delete(owners, "")
+
+	return owners
+}
+
+// unindexBlock removes the block from the height index and its transactions
+// from the transaction and address indexes when it's disconnected from the chain
+func (chain *BlockChain) unindexBlock(txn *badger.Txn, block *Block) error {
+	height, err := readHeight(txn, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Delete(heightIndexKey(height)); err != nil {
+		return err
+	}
+
+	if err := txn.Delete(blockHeightKey(block.Hash)); err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
+		if chain.TxIndex {
+			if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+				return err
+			}
+		}
+
+		for _, pubKeyHash := range touchedAddresses(tx) {
+			if err := txn.Delete(addrIndexKey(pubKeyHash, height, i)); err != nil {
+				return err
+			}
+		}
+
+		for out, output := range tx.Outputs {
+			for owner := range outputOwners(output) {
+				if err := txn.Delete(addrOutputKey([]byte(owner), tx.ID, out)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// addressChanges returns how much the plain balance of each public key hash changes
+// with the transaction, contract outputs don't count until they are redeemed
+func addressChanges(txn *badger.Txn, tx *Transaction) (map[string]int, error) {
+	changes := make(map[string]int)
+
+	for _, out := range tx.Outputs {
+		if len(out.PubKeyHash) > 0 {
+			changes[string(out.PubKeyHash)] += out.Value
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return changes, nil
+	}
+
+	for _, in := range tx.Inputs {
+		pubKeyHash := wallet.PublicKeyHash(in.PubKey)
+
+		value, err := spentValue(txn, pubKeyHash, &in)
+		if err != nil {
+			return nil, err
+		}
+
+		if value > 0 {
+			changes[string(pubKeyHash)] -= value
+		}
+	}
+
+	return changes, nil
+}
+
+// spentValue returns the coins of the plain output spent by the input, 0 for a contract,
+// it's in the address index of the spender as every output is indexed under its owners.
+// Outputs spent in the block being indexed are read from the same badger transaction
+func spentValue(txn *badger.Txn, pubKeyHash []byte, in *TxInput) (int, error) {
+	item, err := txn.Get(addrOutputKey(pubKeyHash, in.ID, in.Out))
+	if err == badger.ErrKeyNotFound {
+		return 0, fmt.Errorf("output %x:%d spent by the input was not found", in.ID, in.Out)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var value int
+	err = item.Value(func(val []byte) error {
+		value = int(binary.BigEndian.Uint64(val))
+		return nil
+	})
+
+	return value, err
+}
+
+// readAddressIndex returns the index entries of the address, oldest first
+func readAddressIndex(txn *badger.Txn, pubKeyHash []byte) ([]AddressTx, error) {
+	var entries []AddressTx
+
+	prefix := addrKey(addrIndexPrefix, pubKeyHash)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		var entry AddressTx
+
+		err := it.Item().Value(func(val []byte) error {
+			return gob.NewDecoder(bytes.NewReader(val)).Decode(&entry)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func encodeIndex(v interface{}) []byte {
+	var buffer bytes.Buffer
+
+	err := gob.NewEncoder(&buffer).Encode(v)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+// dropIndex deletes the index entries with the prefix
+// block hashes starting with the same byte are left alone
+func (chain *BlockChain) dropIndex(prefix []byte) error {
+	var keys [][]byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if key := it.Item().KeyCopy(nil); len(key) != sha256.Size {
+				keys = append(keys, key)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	txn := chain.Database.NewTransaction(true)
+	for _, key := range keys {
+		err := txn.Delete(key)
+
+		// Commit what fits in the transaction and carry on in a new one
+		if err == badger.ErrTxnTooBig {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = chain.Database.NewTransaction(true)
+			err = txn.Delete(key)
+		}
+		if err != nil {
+			txn.Discard()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// Reindex drops and rebuilds the height, transaction and address indexes from all blocks
+func (chain *BlockChain) Reindex() error {
+	prefixes := [][]byte{txIndexPrefix, addrIndexPrefix, addrOutputPrefix, heightIndexPrefix, blockHeightPrefix}
+
+	return chain.rebuildIndexes(prefixes, chain.indexBlock, chain.setTxIndexed)
+}
+
+// rebuildIndexes drops the indexes with the prefixes and indexes every block again, then
+// runs done in the transaction ending the rebuild. The work is spread over many badger
+// transactions so the reindex key is set until the end, a rebuild stopped halfway is
+// run again from the start when the chain is opened
+func (chain *BlockChain) rebuildIndexes(prefixes [][]byte, index func(txn *badger.Txn, block *Block, height int) error, done func(txn *badger.Txn) error) error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(reindexKey, []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		if err := chain.dropIndex(prefix); err != nil {
+			return err
+		}
+	}
+
+	// Index from the genesis block so address histories stay in chain order
+	var hashes [][]byte
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		hashes = append(hashes, block.Hash)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := chain.GetBlock(hashes[i])
+		if err != nil {
+			return err
+		}
+
+		height := len(hashes) - 1 - i
+
+		err = chain.Database.Update(func(txn *badger.Txn) error {
+			return index(txn, block, height)
+		})
+		if err != nil {
+			return fmt.Errorf("indexing block %x: %s", block.Hash, err)
+		}
+	}
+
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		if done != nil {
+			if err := done(txn); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(reindexKey)
+	})
+}
+
+// resumeReindex rebuilds the indexes when a rebuild was stopped before the end
+func (chain *BlockChain) resumeReindex() error {
+	if chain.hasKey(reindexKey) == false {
+		return nil
+	}
+
+	fmt.Println("Rebuilding the indexes, the last rebuild was interrupted")
+
+	return chain.Reindex()
+}
+
+// migrateAddressIndex rebuilds the address index with an entry per transaction and output
+// in place of the list of every transaction of an address rewritten with each new one
+func (chain *BlockChain) migrateAddressIndex() error {
+	return chain.rebuildIndexes([][]byte{addrIndexPrefix, addrOutputPrefix}, indexAddresses, nil)
+}
+
+// setTxIndexed records the transaction index setting and whether the index is built
+func (chain *BlockChain) setTxIndexed(txn *badger.Txn) error {
+	if chain.TxIndex {
+		if err := txn.Set(txIndexSettingKey, []byte{1}); err != nil {
+			return err
+		}
+		return txn.Set(txIndexedKey, []byte{1})
+	}
+
+	if err := txn.Set(txIndexSettingKey, []byte{0}); err != nil {
+		return err
+	}
+	return txn.Delete(txIndexedKey)
+}
+
+// loadTxIndexSetting sets TxIndex to the setting stored in the database,
+// databases from before the setting was stored keep the index on
+func (chain *BlockChain) loadTxIndexSetting() error {
+	return chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txIndexSettingKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			chain.TxIndex = len(val) > 0 && val[0] == 1
+			return nil
+		})
+	})
+}
+
+// hasKey checks if the key is stored in the database
+func (chain *BlockChain) hasKey(key []byte) bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		return err
+	})
+
+	return err == nil
+}
+
+// indexedVersion returns the version of the indexes built before they were part of the schema, 0 if none
+func (chain *BlockChain) indexedVersion() byte {
+	var version byte
+
+	_ = chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(indexedKey)
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			if len(val) > 0 {
+				version = val[0]
+			}
+			return nil
+		})
+	})
+
+	return version
+}
+
+// migrateIndexes builds the indexes of the databases created before they were
+// part of the schema, indexes already built with the last version are kept
+func (chain *BlockChain) migrateIndexes() error {
+	if chain.indexedVersion() != lastIndexVersion {
+		if err := chain.Reindex(); err != nil {
+			return err
+		}
+	}
+
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(indexedKey)
+	})
+}
+
+// SetTxIndex turns the transaction index of the chain on or off, the index is
+// built or dropped now and the database keeps the setting for the following runs
+func (chain *BlockChain) SetTxIndex(on bool) error {
+	chain.TxIndex = on
+
+	return chain.checkTxIndex()
+}
+
+// checkTxIndex builds or drops the transaction index when it doesn't match the setting, a
+// transaction index left from a run with it on is dropped so it can't go stale
+func (chain *BlockChain) checkTxIndex() error {
+	if chain.hasKey(txIndexedKey) == chain.TxIndex {
+		return nil
+	}
+
+	if chain.TxIndex == false {
+		if err := chain.dropIndex(txIndexPrefix); err != nil {
+			return err
+		}
+
+		return chain.Database.Update(chain.setTxIndexed)
+	}
+
+	fmt.Println("Building the transaction index")
+
+	return chain.Reindex()
+}
+
+// GetTransaction looks the transaction up in the index
+// and returns it with the hash of the block containing it
+func (chain *BlockChain) GetTransaction(txID []byte) (*Transaction, []byte, error) {
+	var loc TxLocation
+
+	if chain.TxIndex == false {
+		return chain.findTransactionBlock(txID)
+	}
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txIndexKey(txID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return gob.NewDecoder(bytes.NewReader(val)).Decode(&loc)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, nil, fmt.Errorf("transaction %x does not exists", txID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := chain.GetBlock(loc.BlockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if loc.Index >= len(block.Transactions) || bytes.Equal(block.Transactions[loc.Index].ID, txID) == false {
+		return nil, nil, fmt.Errorf("transaction %x is not in block %x", txID, loc.BlockHash)
+	}
+
+	return block.Transactions[loc.Index], block.Hash, nil
+}
+
+// GetAddressTransactions returns the transactions touching the address, oldest first
+func (chain *BlockChain) GetAddressTransactions(pubKeyHash []byte) ([]AddressTx, error) {
+	var entries []AddressTx
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		entries, err = readAddressIndex(txn, pubKeyHash)
+		return err
+	})
+
+	return entries, err
+}
+
+// GetAddressHistory returns a page of the transactions touching the address, newest first
+// along with the total number of transactions in the history
+func (chain *BlockChain) GetAddressHistory(pubKeyHash []byte, offset, limit int) ([]AddressTx, int, error) {
+	entries, err := chain.GetAddressTransactions(pubKeyHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(entries)
+	var page []AddressTx
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, entries[i])
+	}
+
+	return page, total, nil
+}
+
+// UTXO is an unspent output with its location
+type UTXO struct {
+	TxID   []byte
+	Out    int
+	Output TxOutput
+}
+
+// GetAddressUTXOs returns the unspent outputs locked to the address using the address index
+// every transaction spending those outputs touches the address so its history is enough
+func (chain *BlockChain) GetAddressUTXOs(pubKeyHash []byte) ([]UTXO, error) {
+	entries, err := chain.GetAddressTransactions(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*Transaction
+	spent := make(map[string]bool)
+
+	for _, entry := range entries {
+		tx, _, err := chain.GetTransaction(entry.TxID)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+		}
+	}
+
+	var utxos []UTXO
+	for _, tx := range txs {
+		for outIdx, out := range tx.Outputs {
+			if out.IsLockedWithKey(pubKeyHash) && !spent[fmt.Sprintf("%x:%d", tx.ID, outIdx)] {
+				utxos = append(utxos, UTXO{tx.ID, outIdx, out})
+			}
+		}
+	}
+
+	return utxos, nil
+}
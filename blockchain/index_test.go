@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/wallet"
+	"path/filepath"
+	"testing"
+)
+
+func TestTxIndexSettingIsKept(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, filepath.Join(dir, "chain"), alice)
+	tx := NewTransaction(alice, bob, 10, chain)
+	chain.AddBlock([]*Transaction{tx})
+	chain.Database.Close()
+
+	// Every run opens the chain like a new process, the flag given or not
+	runs := []struct {
+		name    string
+		flag    *bool
+		indexed bool
+	}{
+		{"-txindex=false", newBool(false), false},
+		{"no flag after -txindex=false", nil, false},
+		{"-txindex=true", newBool(true), true},
+		{"no flag after -txindex=true", nil, true},
+	}
+	for _, run := range runs {
+		chain := ContinueBlockChain()
+		if run.flag != nil {
+			if err := chain.SetTxIndex(*run.flag); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if chain.TxIndex != run.indexed {
+			t.Errorf("%s: the transaction index is %t", run.name, chain.TxIndex)
+		}
+		if chain.hasKey(txIndexKey(tx.ID)) != run.indexed {
+			t.Errorf("%s: the transaction index entries don't match the setting", run.name)
+		}
+		if found, _, err := chain.GetTransaction(tx.ID); err != nil || found == nil {
+			t.Errorf("%s: the transaction wasn't found: %v", run.name, err)
+		}
+
+		chain.Database.Close()
+	}
+}
+
+func newBool(b bool) *bool {
+	return &b
+}
+
+// countKeys returns the number of keys starting with the prefix
+func countKeys(t *testing.T, chain *BlockChain, prefix []byte) int {
+	t.Helper()
+
+	count := 0
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+// Every transaction and output of an address has its own entry, indexing a block adds entries only
+func TestAddressIndexEntries(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	bobHash, err := wallet.DecodeAddress(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	var txs []*Transaction
+	for _, send := range []struct {
+		from, to string
+		amount   int
+	}{{alice, bob, 10}, {bob, alice, 3}, {alice, bob, 5}} {
+		tx := NewTransaction(send.from, send.to, send.amount, chain)
+		chain.AddBlock([]*Transaction{tx})
+		txs = append(txs, tx)
+	}
+
+	entries, err := chain.GetAddressTransactions(bobHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []AddressTx{{txs[0].ID, nil, Incoming, 10}, {txs[1].ID, nil, Outgoing, 3}, {txs[2].ID, nil, Incoming, 5}}
+	if len(entries) != len(want) {
+		t.Fatalf("bob has %d entries instead of %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if bytes.Equal(entry.TxID, want[i].TxID) == false || entry.Direction != want[i].Direction || entry.Amount != want[i].Amount {
+			t.Errorf("entry %d is %x %s %d instead of %x %s %d", i, entry.TxID, entry.Direction, entry.Amount, want[i].TxID, want[i].Direction, want[i].Amount)
+		}
+	}
+
+	// Received 10, the change of 7 and 5
+	if got := countKeys(t, chain, addrKey(addrIndexPrefix, bobHash)); got != 3 {
+		t.Errorf("bob has %d transaction keys instead of 3", got)
+	}
+	if got := countKeys(t, chain, addrKey(addrOutputPrefix, bobHash)); got != 3 {
+		t.Errorf("bob has %d output keys instead of 3", got)
+	}
+}
+
+// A rebuild stopped after the indexes were dropped is run again when the chain is opened
+func TestReindexResumes(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, dir, alice)
+	tx := NewTransaction(alice, bob, 10, chain)
+	chain.AddBlock([]*Transaction{tx})
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(reindexKey, []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range [][]byte{txIndexPrefix, addrIndexPrefix, addrOutputPrefix} {
+		if err := chain.dropIndex(prefix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chain.Database.Close()
+
+	chain = ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if chain.hasKey(reindexKey) {
+		t.Error("the rebuild is still marked as running")
+	}
+	if found, _, err := chain.GetTransaction(tx.ID); err != nil || found == nil {
+		t.Errorf("the transaction index wasn't rebuilt: %v", err)
+	}
+	if got := balance(t, chain, bob); got != 10 {
+		t.Errorf("bob has %d instead of 10", got)
+	}
+	bobHash, _ := wallet.DecodeAddress(bob)
+	if entries, err := chain.GetAddressTransactions(bobHash); err != nil || len(entries) != 1 {
+		t.Errorf("bob has %d transactions in the address index, %v", len(entries), err)
+	}
+}
+
+// Disconnecting the last block removes its index entries, the chain is then as before the block
+func TestDisconnectTip(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	bobHash, err := wallet.DecodeAddress(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	chain.AddBlock([]*Transaction{NewTransaction(alice, bob, 10, chain)})
+	chain.AddBlock([]*Transaction{NewTransaction(bob, alice, 3, chain)})
+
+	tip, err := chain.DisconnectTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tip.Transactions[0]
+
+	if height := chain.GetBestHeight(); height != 1 {
+		t.Errorf("the chain ends at the height %d instead of 1", height)
+	}
+	if _, err := chain.GetBlockByHeight(2); err == nil {
+		t.Error("the disconnected block is still in the height index")
+	}
+	if chain.hasKey(blockHeightKey(tip.Hash)) || chain.hasKey(tip.Hash) {
+		t.Error("the disconnected block is still stored")
+	}
+	if chain.hasKey(txIndexKey(tx.ID)) {
+		t.Error("the transaction of the disconnected block is still in the transaction index")
+	}
+	if got := balance(t, chain, bob); got != 10 {
+		t.Errorf("bob has %d instead of 10", got)
+	}
+	if entries, err := chain.GetAddressTransactions(bobHash); err != nil || len(entries) != 1 {
+		t.Errorf("bob has %d transactions in the address index, %v", len(entries), err)
+	}
+	if got := countKeys(t, chain, addrKey(addrOutputPrefix, bobHash)); got != 1 {
+		t.Errorf("bob has %d output keys instead of 1", got)
+	}
+
+	// The coins spent by the disconnected block can be spent again
+	chain.AddBlock([]*Transaction{NewTransaction(bob, alice, 4, chain)})
+	if got := balance(t, chain, bob); got != 6 {
+		t.Errorf("bob has %d instead of 6 after the new block", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := chain.DisconnectTip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := chain.DisconnectTip(); err == nil {
+		t.Error("the genesis block was disconnected")
+	}
+}
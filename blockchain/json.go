@@ -0,0 +1,271 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sheghun/blockchain/wallet"
+)
+
+// The JSON encoding of the blocks and transactions, hashes, keys and signatures
+// are hex and public key hashes are also given as the Base58 address of the
+// active network. The fields are always written in the same order, the values
+// spent by the inputs and the fees are only known with the chain holding them.
+// The commands, the JSON-RPC server and the explorer all use this schema
+
+// JSONBlock is the JSON encoding of a block
+type JSONBlock struct {
+	Hash         string            `json:"hash"`
+	PrevHash     string            `json:"prevHash"`
+	Nonce        int               `json:"nonce"`
+	Timestamp    int64             `json:"timestamp"`
+	Difficulty   int               `json:"difficulty"`
+	Consensus    string            `json:"consensus,omitempty"`
+	Network      string            `json:"network,omitempty"`
+	Signers      []string          `json:"signers,omitempty"`
+	Signer       string            `json:"signer,omitempty"`
+	SignerCurve  string            `json:"signerCurve,omitempty"`
+	Signature    string            `json:"signature,omitempty"`
+	Vote         *JSONVote         `json:"vote,omitempty"`
+	Fees         *int              `json:"fees,omitempty"`
+	Transactions []JSONTransaction `json:"transactions"`
+}
+
+// JSONVote is the JSON encoding of the vote of a block signer
+type JSONVote struct {
+	Signer    string `json:"signer"`
+	Authorize bool   `json:"authorize"`
+}
+
+// JSONTransaction is the JSON encoding of a transaction
+type JSONTransaction struct {
+	ID         string       `json:"id"`
+	Coinbase   bool         `json:"coinbase"`
+	Inputs     []JSONInput  `json:"inputs"`
+	Outputs    []JSONOutput `json:"outputs"`
+	Value      int          `json:"value"` // Sum of the outputs
+	Fee        *int         `json:"fee,omitempty"`
+	ExtraNonce uint64       `json:"extraNonce,omitempty"`
+}
+
+// JSONInput is the JSON encoding of a transaction input
+type JSONInput struct {
+	TxID      string `json:"txid"`
+	Out       int    `json:"vout"`
+	Signature string `json:"signature"`
+	PubKey    string `json:"pubKey"`
+	Address   string `json:"address,omitempty"`
+	Preimage  string `json:"preimage,omitempty"`
+	SigHash   string `json:"sigHash"`
+	Curve     string `json:"curve,omitempty"`
+	Value     *int   `json:"value,omitempty"` // Value of the output spent
+}
+
+// JSONOutput is the JSON encoding of a transaction output
+type JSONOutput struct {
+	Value      int       `json:"value"`
+	PubKeyHash string    `json:"pubKeyHash"`
+	Address    string    `json:"address,omitempty"` // Missing on contract outputs
+	HTLC       *JSONHTLC `json:"htlc,omitempty"`
+}
+
+// JSONHTLC is the JSON encoding of a hash time lock
+type JSONHTLC struct {
+	SecretHash string `json:"secretHash"`
+	Receiver   string `json:"receiver"`
+	Refund     string `json:"refund"`
+	LockTime   int64  `json:"lockTime"`
+}
+
+// MarshalJSON encodes the block without the fees of its transactions
+func (b Block) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.jsonValue(nil))
+}
+
+// MarshalJSON encodes the transaction without its fee
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.jsonValue(nil))
+}
+
+// MarshalJSON encodes the input without the value it spends
+func (in TxInput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(in.jsonValue(nil))
+}
+
+// MarshalJSON encodes the output with the address it's locked to
+func (out TxOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(out.jsonValue())
+}
+
+// BlockJSON returns the JSON encoding of the block with the values spent by the inputs and the fees
+func (chain *BlockChain) BlockJSON(b *Block) (JSONBlock, error) {
+	prevTxs := make(map[string]Transaction)
+
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		txs, err := chain.findPrevTransactions(tx)
+		if err != nil {
+			return JSONBlock{}, err
+		}
+		for id, prevTx := range txs {
+			prevTxs[id] = prevTx
+		}
+	}
+
+	return b.jsonValue(prevTxs), nil
+}
+
+// TransactionJSON returns the JSON encoding of the transaction with the values spent by
+// the inputs and the fee, they are left out when the chain doesn't hold the outputs spent
+func (chain *BlockChain) TransactionJSON(tx *Transaction) JSONTransaction {
+	if tx.IsCoinbase() {
+		return tx.jsonValue(nil)
+	}
+
+	prevTxs, err := chain.findPrevTransactions(tx)
+	if err != nil {
+		prevTxs = nil
+	}
+
+	return tx.jsonValue(prevTxs)
+}
+
+// Fee returns the value of the outputs spent by the transaction left over by its outputs
+func (t *Transaction) Fee(prevTxs map[string]Transaction) (int, error) {
+	if t.IsCoinbase() {
+		return 0, nil
+	}
+
+	fee := 0
+	for _, in := range t.Inputs {
+		prevTx, ok := prevTxs[hex.EncodeToString(in.ID)]
+		if ok == false || in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return 0, fmt.Errorf("output %d of transaction %x spent by the input was not found", in.Out, in.ID)
+		}
+		fee += prevTx.Outputs[in.Out].Value
+	}
+
+	for _, out := range t.Outputs {
+		fee -= out.Value
+	}
+
+	return fee, nil
+}
+
+func (b *Block) jsonValue(prevTxs map[string]Transaction) JSONBlock {
+	block := JSONBlock{
+		Hash:         hex.EncodeToString(b.Hash),
+		PrevHash:     hex.EncodeToString(b.PrevHash),
+		Nonce:        b.Nonce,
+		Timestamp:    b.Timestamp,
+		Difficulty:   b.Difficulty,
+		Consensus:    b.Consensus,
+		Network:      b.Network,
+		Signer:       hex.EncodeToString(b.Signer),
+		SignerCurve:  string(b.SignerCurve),
+		Signature:    hex.EncodeToString(b.Signature),
+		Transactions: []JSONTransaction{},
+	}
+
+	for _, signer := range b.Signers {
+		block.Signers = append(block.Signers, wallet.PubKeyHashToAddress(signer))
+	}
+	if b.Vote != nil {
+		block.Vote = &JSONVote{wallet.PubKeyHashToAddress(b.Vote.Signer), b.Vote.Authorize}
+	}
+
+	if prevTxs != nil {
+		block.Fees = new(int)
+	}
+	for _, tx := range b.Transactions {
+		t := tx.jsonValue(prevTxs)
+		if block.Fees != nil && t.Fee != nil {
+			*block.Fees += *t.Fee
+		}
+
+		block.Transactions = append(block.Transactions, t)
+	}
+
+	return block
+}
+
+func (t *Transaction) jsonValue(prevTxs map[string]Transaction) JSONTransaction {
+	coinbase := t.IsCoinbase()
+	tx := JSONTransaction{
+		ID:         hex.EncodeToString(t.ID),
+		Coinbase:   coinbase,
+		Inputs:     []JSONInput{},
+		Outputs:    []JSONOutput{},
+		ExtraNonce: t.ExtraNonce,
+	}
+
+	for _, in := range t.Inputs {
+		var prevOut *TxOutput
+		if prevTx, ok := prevTxs[hex.EncodeToString(in.ID)]; ok && in.Out >= 0 && in.Out < len(prevTx.Outputs) {
+			prevOut = &prevTx.Outputs[in.Out]
+		}
+
+		tx.Inputs = append(tx.Inputs, in.jsonValue(prevOut))
+	}
+
+	for _, out := range t.Outputs {
+		tx.Outputs = append(tx.Outputs, out.jsonValue())
+		tx.Value += out.Value
+	}
+
+	if prevTxs != nil && coinbase == false {
+		if fee, err := t.Fee(prevTxs); err == nil {
+			tx.Fee = &fee
+		}
+	}
+
+	return tx
+}
+
+// jsonValue converts the input, the public key of a coinbase input holds its data and has no address
+func (in *TxInput) jsonValue(prevOut *TxOutput) JSONInput {
+	input := JSONInput{
+		TxID:      hex.EncodeToString(in.ID),
+		Out:       in.Out,
+		Signature: hex.EncodeToString(in.Signature),
+		PubKey:    hex.EncodeToString(in.PubKey),
+		Preimage:  hex.EncodeToString(in.Preimage),
+		SigHash:   in.SigHash.String(),
+		Curve:     string(in.Curve),
+	}
+
+	if len(in.ID) > 0 || in.Out != -1 {
+		input.Address = wallet.PubKeyHashToAddress(wallet.PublicKeyHash(in.PubKey))
+	}
+	if prevOut != nil {
+		value := prevOut.Value
+		input.Value = &value
+	}
+
+	return input
+}
+
+func (out *TxOutput) jsonValue() JSONOutput {
+	output := JSONOutput{
+		Value:      out.Value,
+		PubKeyHash: hex.EncodeToString(out.PubKeyHash),
+	}
+
+	if len(out.PubKeyHash) > 0 {
+		output.Address = wallet.PubKeyHashToAddress(out.PubKeyHash)
+	}
+
+	if out.HTLC != nil {
+		output.HTLC = &JSONHTLC{
+			SecretHash: hex.EncodeToString(out.HTLC.SecretHash),
+			Receiver:   wallet.PubKeyHashToAddress(out.HTLC.ReceiverHash),
+			Refund:     wallet.PubKeyHashToAddress(out.HTLC.RefundHash),
+			LockTime:   out.HTLC.LockTime,
+		}
+	}
+
+	return output
+}
@@ -0,0 +1,279 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+)
+
+// gob numbers the types of a process in the order they are first used and writes
+// the numbers in its streams, so the legacy hash of the same transaction depends on
+// what the process encoded or decoded before. The transactions were signed by
+// processes that used the transaction types first, the legacy encoding renumbers
+// its types from the first number gob gives to a type to hash the same stream
+
+// firstGobTypeID is the number gob gives to the first type used in a process,
+// the smaller numbers belong to its builtin types
+const firstGobTypeID = 64
+
+// Kinds of the types defined in a gob stream, the field deltas of the wireType holding them
+const (
+	gobSliceType  = 2
+	gobStructType = 3
+)
+
+// gobReader reads the values of a gob stream
+type gobReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *gobReader) uint() (uint64, error) {
+	if r.pos >= len(r.data) {
+		return 0, errTruncated
+	}
+	c := r.data[r.pos]
+	r.pos++
+	if c < 0x80 {
+		return uint64(c), nil
+	}
+
+	n := int(-int8(c))
+	if n > 8 || r.pos+n > len(r.data) {
+		return 0, errTruncated
+	}
+
+	var x uint64
+	for _, b := range r.data[r.pos : r.pos+n] {
+		x = x<<8 | uint64(b)
+	}
+	r.pos += n
+
+	return x, nil
+}
+
+func (r *gobReader) int() (int64, error) {
+	u, err := r.uint()
+	if u&1 == 1 {
+		return ^int64(u >> 1), err
+	}
+
+	return int64(u >> 1), err
+}
+
+func (r *gobReader) bytes() ([]byte, error) {
+	n, err := r.uint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)-r.pos) < n {
+		return nil, errTruncated
+	}
+
+	data := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+
+	return data, nil
+}
+
+// gobWriter writes the values of a gob stream
+type gobWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *gobWriter) uint(x uint64) {
+	if x < 0x80 {
+		w.buf.WriteByte(byte(x))
+		return
+	}
+
+	var b [8]byte
+	n := 8
+	for ; x > 0; x >>= 8 {
+		n--
+		b[n] = byte(x)
+	}
+	w.buf.WriteByte(byte(-int8(8 - n)))
+	w.buf.Write(b[n:])
+}
+
+func (w *gobWriter) int(x int64) {
+	if x < 0 {
+		w.uint(uint64(^x)<<1 | 1)
+		return
+	}
+	w.uint(uint64(x) << 1)
+}
+
+func (w *gobWriter) bytes(data []byte) {
+	w.uint(uint64(len(data)))
+	w.buf.Write(data)
+}
+
+// renumberGobTypes rewrites the type numbers of the stream so the type of
+// the value written is the first type of the process and the types it
+// defines follow it, the builtin types keep their numbers
+func renumberGobTypes(stream []byte) ([]byte, error) {
+	r := &gobReader{data: stream}
+	var valueID int64
+
+	// The value comes last, after the definitions of its types
+	for r.pos < len(r.data) {
+		n, err := r.uint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(r.data)-r.pos) < n {
+			return nil, errTruncated
+		}
+		end := r.pos + int(n)
+
+		if valueID, err = r.int(); err != nil {
+			return nil, err
+		}
+		r.pos = end
+	}
+	if valueID < firstGobTypeID {
+		return nil, errors.New("the gob stream holds no value of a defined type")
+	}
+
+	renumber := func(id int64) int64 {
+		if id < firstGobTypeID {
+			return id
+		}
+		return id - valueID + firstGobTypeID
+	}
+
+	var out bytes.Buffer
+	r.pos = 0
+
+	for r.pos < len(r.data) {
+		n, _ := r.uint()
+		message := &gobReader{data: r.data[r.pos : r.pos+int(n)]}
+		r.pos += int(n)
+
+		var w gobWriter
+		if err := renumberGobMessage(message, &w, renumber); err != nil {
+			return nil, err
+		}
+
+		var length gobWriter
+		length.uint(uint64(w.buf.Len()))
+		out.Write(length.buf.Bytes())
+		out.Write(w.buf.Bytes())
+	}
+
+	return out.Bytes(), nil
+}
+
+// renumberGobMessage copies a message of the stream with the type numbers renumbered,
+// a message defines a type with a negative number or holds a value of a type
+func renumberGobMessage(r *gobReader, w *gobWriter, renumber func(int64) int64) error {
+	id, err := r.int()
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		w.int(renumber(id))
+		w.buf.Write(r.data[r.pos:])
+		return nil
+	}
+	w.int(-renumber(-id))
+
+	// A wireType with a single field, the slice or struct type
+	kind, err := r.uint()
+	if err != nil {
+		return err
+	}
+	if kind != gobSliceType && kind != gobStructType {
+		return errors.New("the gob stream defines a type that is not a slice or a struct")
+	}
+	w.uint(kind)
+
+	// Its CommonType, the name and number of the type
+	if err := copyGobFields(r, w, []byte{1, 1}); err != nil {
+		return err
+	}
+	name, err := r.bytes()
+	if err != nil {
+		return err
+	}
+	w.bytes(name)
+	if err := copyGobFields(r, w, []byte{1}); err != nil {
+		return err
+	}
+	if err := renumberGobField(r, w, renumber); err != nil {
+		return err
+	}
+
+	switch kind {
+	case gobSliceType:
+		// Ends the CommonType, then the element type of the slice
+		if err := copyGobFields(r, w, []byte{0, 1}); err != nil {
+			return err
+		}
+		if err := renumberGobField(r, w, renumber); err != nil {
+			return err
+		}
+
+	case gobStructType:
+		// Ends the CommonType, then the name and type of every field
+		if err := copyGobFields(r, w, []byte{0, 1}); err != nil {
+			return err
+		}
+		count, err := r.uint()
+		if err != nil {
+			return err
+		}
+		w.uint(count)
+
+		for i := uint64(0); i < count; i++ {
+			if err := copyGobFields(r, w, []byte{1}); err != nil {
+				return err
+			}
+			name, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			w.bytes(name)
+			if err := copyGobFields(r, w, []byte{1}); err != nil {
+				return err
+			}
+			if err := renumberGobField(r, w, renumber); err != nil {
+				return err
+			}
+			if err := copyGobFields(r, w, []byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Ends the slice or struct type and the wireType
+	return copyGobFields(r, w, []byte{0, 0})
+}
+
+// copyGobFields copies the field deltas and struct ends the definition has to hold next
+func copyGobFields(r *gobReader, w *gobWriter, expected []byte) error {
+	for _, e := range expected {
+		delta, err := r.uint()
+		if err != nil {
+			return err
+		}
+		if delta != uint64(e) {
+			return errors.New("the gob stream defines a type with unexpected fields")
+		}
+		w.uint(delta)
+	}
+
+	return nil
+}
+
+// renumberGobField copies a type number with its new number
+func renumberGobField(r *gobReader, w *gobWriter, renumber func(int64) int64) error {
+	id, err := r.int()
+	if err != nil {
+		return err
+	}
+	w.int(renumber(id))
+
+	return nil
+}
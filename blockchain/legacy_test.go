@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"github.com/sheghun/blockchain/wallet"
+	"testing"
+	"time"
+)
+
+// Gob encodings of a coinbase and the transactions spending it as written by the
+// binary from before hash types, the transactions were signed by it with P-256
+var (
+	baselineCoinbase = "387f0301010b5472616e73616374696f6e01ff8000010301024944010a000106496e7075747301ff840001074f75747075747301ff8800000023ff83020101145b5d626c6f636b636861696e2e547849" +
+		"6e70757401ff840001ff8200003dff81030101075478496e70757401ff8200010401024944010a0001034f757401040001095369676e6174757265010a0001065075624b6579010a00000024ff870201" +
+		"01155b5d626c6f636b636861696e2e54784f757470757401ff880001ff8600002fff850301010854784f757470757401ff86000102010556616c7565010400010a5075624b657948617368010a000000" +
+		"66ff800120e10ea2d687ff53bb519a8eb0c27acb263c27da02b90422d1647ea4bfb43e928101010201021e4669727374205472616e73616374696f6e2066726f6d2047656e6573697300010101ffc801" +
+		"14e791872ec9107477e6aa244f5056e1c46be63bbd0000"
+
+	// Spends the coinbase, paying two outputs back to its owner
+	baselineSpend = "387f0301010b5472616e73616374696f6e01ff8000010301024944010a000106496e7075747301ff840001074f75747075747301ff8800000023ff83020101145b5d626c6f636b636861696e2e547849" +
+		"6e70757401ff840001ff8200003dff81030101075478496e70757401ff8200010401024944010a0001034f757401040001095369676e6174757265010a0001065075624b6579010a00000024ff870201" +
+		"01155b5d626c6f636b636861696e2e54784f757470757401ff880001ff8600002fff850301010854784f757470757401ff86000102010556616c7565010400010a5075624b657948617368010a000000" +
+		"fe0103ff800120864db4e79f9ad31ced4e13505b625253f3aa7e9891974a9b489cc62711587bf401010120e10ea2d687ff53bb519a8eb0c27acb263c27da02b90422d1647ea4bfb43e928102407d7d2d" +
+		"60c8f3ae48733a5a6fe352d446b4a2345429c33b04eb6944bbb88dacc3b18b6bc2b064045e5878e54fb22eb5ecb4d27b97a448f63917d280d719816ecc014045e42e7e8560185e6df4a074665e2ce72c" +
+		"a3c6d62f8ec688907bad8439dfdee2aeb712f05b94978fe52c6898ae212a4327f5d2ecab30bed5f26d9eda22e50f86000102013c0114e791872ec9107477e6aa244f5056e1c46be63bbd0001ff8c0114" +
+		"e791872ec9107477e6aa244f5056e1c46be63bbd0000"
+
+	// Spends both outputs of baselineSpend
+	baselineTwoInputs = "387f0301010b5472616e73616374696f6e01ff8000010301024944010a000106496e7075747301ff840001074f75747075747301ff8800000023ff83020101145b5d626c6f636b636861696e2e547849" +
+		"6e70757401ff840001ff8200003dff81030101075478496e70757401ff8200010401024944010a0001034f757401040001095369676e6174757265010a0001065075624b6579010a00000024ff870201" +
+		"01155b5d626c6f636b636861696e2e54784f757470757401ff880001ff8600002fff850301010854784f757470757401ff86000102010556616c7565010400010a5075624b657948617368010a000000" +
+		"fe0193ff80012013ef00dbefbb089db2a2c6e4ff6307de3798ab411ea03ba4a04840779bca6dfd01020120864db4e79f9ad31ced4e13505b625253f3aa7e9891974a9b489cc62711587bf402403a8b8a" +
+		"4bbe18acbc7c6bd068159d706fa0f5c65e95901b021fd7c42b689382821c9ff3d1b3c252ba47e296914062649688934e4a4d779ec9fd141f2ea5f352f5014045e42e7e8560185e6df4a074665e2ce72c" +
+		"a3c6d62f8ec688907bad8439dfdee2aeb712f05b94978fe52c6898ae212a4327f5d2ecab30bed5f26d9eda22e50f86000120864db4e79f9ad31ced4e13505b625253f3aa7e9891974a9b489cc6271158" +
+		"7bf401020140cf6ebb4445166b3e468c6a92d3c6e4c102503a5f5d8c5171a1e64f1febae8e879e98e1e974b1cc91fe3c5d70b82a179c550be7ebb7eb3c7206acaff0844577c4014045e42e7e8560185e" +
+		"6df4a074665e2ce72ca3c6d62f8ec688907bad8439dfdee2aeb712f05b94978fe52c6898ae212a4327f5d2ecab30bed5f26d9eda22e50f8600010101ffc8011494edce9e7f42fe411b7e11e85fca58d2" +
+		"082d6c4e0000"
+)
+
+// decodeBaselineTransaction decodes a transaction gob encoded before the binary encoding
+func decodeBaselineTransaction(t *testing.T, data string) *Transaction {
+	t.Helper()
+
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tx Transaction
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&tx); err != nil {
+		t.Fatal(err)
+	}
+
+	return &tx
+}
+
+// registerGobTypes makes gob number types the way a process that encoded other values first does
+func registerGobTypes(t *testing.T) {
+	t.Helper()
+
+	type first struct{ A []int }
+	type second struct {
+		B []first
+		C map[string]int
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(second{B: []first{{A: []int{1}}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacySignatures(t *testing.T) {
+	// The types are numbered before the legacy types are first encoded
+	registerGobTypes(t)
+
+	coinbase := decodeBaselineTransaction(t, baselineCoinbase)
+	spend := decodeBaselineTransaction(t, baselineSpend)
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		prev *Transaction
+	}{
+		{"a transaction spending a coinbase", spend, coinbase},
+		{"a transaction with two inputs", decodeBaselineTransaction(t, baselineTwoInputs), spend},
+	}
+
+	for _, test := range tests {
+		tx := test.tx
+		prevTxs := map[string]Transaction{hex.EncodeToString(test.prev.ID): *test.prev}
+
+		for _, in := range tx.Inputs {
+			if in.SigHash != SigHashLegacy || in.Curve != "" {
+				t.Fatalf("%s: the input has the hash type %s and the curve %q", test.name, in.SigHash, in.Curve)
+			}
+		}
+
+		// The transactions are verified as if they were in the chain
+		verify := func() bool {
+			batch := &wallet.BatchVerifier{}
+			return tx.verifyInputs(prevTxs, time.Now().Unix(), true, batch) && batch.Verify()
+		}
+
+		if verify() == false {
+			t.Errorf("%s: the signature doesn't verify", test.name)
+		}
+		if tx.Verify(prevTxs, time.Now().Unix()) {
+			t.Errorf("%s: the signature verifies for a new transaction", test.name)
+		}
+
+		// Values the transactions couldn't hold then aren't hashed
+		tx.Outputs[0].HTLC = &HTLC{make([]byte, 32), tx.Outputs[0].PubKeyHash, tx.Outputs[0].PubKeyHash, 1}
+		if verify() {
+			t.Errorf("%s: the signature verifies with a hash time locked output", test.name)
+		}
+		tx.Outputs[0].HTLC = nil
+
+		tx.Outputs[0].Value++
+		if verify() {
+			t.Errorf("%s: the signature verifies with a changed output", test.name)
+		}
+		tx.Outputs[0].Value--
+	}
+}
+
+// The binary from before hash types set the IDs before signing
+func TestLegacyIDs(t *testing.T) {
+	registerGobTypes(t)
+
+	for _, data := range []string{baselineCoinbase, baselineSpend, baselineTwoInputs} {
+		tx := decodeBaselineTransaction(t, data)
+
+		if err := tx.checkID(true); err != nil {
+			t.Errorf("transaction %x: %s", tx.ID, err)
+		}
+		if err := tx.checkID(false); err == nil {
+			t.Errorf("transaction %x matches its ID outside a legacy block", tx.ID)
+		}
+	}
+}
+
+func TestRenumberGobTypes(t *testing.T) {
+	type item struct{ N int }
+	type value struct{ Items []item }
+
+	registerGobTypes(t)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value{Items: []item{{1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := renumberGobTypes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The value type is defined first with the first number, the slice and its element follow it
+	r := &gobReader{data: stream}
+	var ids []int64
+	for r.pos < len(r.data) {
+		n, err := r.uint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := r.pos + int(n)
+		id, err := r.int()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		r.pos = end
+	}
+
+	if len(ids) == 0 || ids[len(ids)-1] != firstGobTypeID {
+		t.Fatalf("the value has the type numbers %v, want the last to be %d", ids, firstGobTypeID)
+	}
+	for _, id := range ids[:len(ids)-1] {
+		if id >= 0 || -id < firstGobTypeID || -id > firstGobTypeID+2 {
+			t.Errorf("the types are defined with the numbers %v", ids)
+			break
+		}
+	}
+
+	var decoded value
+	if err := gob.NewDecoder(bytes.NewReader(stream)).Decode(&decoded); err != nil {
+		t.Fatalf("the renumbered stream doesn't decode: %s", err)
+	}
+	if len(decoded.Items) != 1 || decoded.Items[0].N != 1 {
+		t.Errorf("the renumbered stream decodes to %+v", decoded)
+	}
+
+	if _, err := renumberGobTypes(stream[:len(stream)-1]); err == nil {
+		t.Error("a truncated stream was renumbered")
+	}
+}
@@ -0,0 +1,326 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sheghun/blockchain/wallet"
+)
+
+// PoaConsensus is the name of the proof of authority engine
+const PoaConsensus = "poa"
+
+// SignerVote is the proposal of an authority to add or remove a signer, the
+// change is made once more than half of the signers voted the same way
+type SignerVote struct {
+	Signer    []byte // Public key hash of the proposed signer
+	Authorize bool   // Adds the signer when true, removes it when false
+}
+
+// PoaEngine seals blocks with the signature of the authority whose turn it is,
+// the signers take turns in the order they were authorized by the block height
+type PoaEngine struct {
+	Vote *SignerVote // Cast in the next block sealed by the engine
+
+	mu        sync.Mutex
+	snapshots map[string]*authorities // Signers after a block, by the block hash
+}
+
+func init() {
+	RegisterConsensus(PoaConsensus, func() Consensus { return &PoaEngine{} })
+}
+
+// authorities are the signers and the pending votes after a block
+type authorities struct {
+	signers [][]byte
+	votes   map[string]map[string]bool // Proposed signer -> voting signer -> authorize
+}
+
+// Name returns the name of the proof of authority engine
+func (e *PoaEngine) Name() string {
+	return PoaConsensus
+}
+
+// NextDifficulty is constant as every block is worth the same
+func (e *PoaEngine) NextDifficulty(chain *BlockChain, prev *Block) int {
+	return 1
+}
+
+// Seal signs the block with the wallet of the signer in turn
+func (e *PoaEngine) Seal(ctx context.Context, chain *BlockChain, b *Block, workers int) (MiningStats, error) {
+	if err := ctx.Err(); err != nil {
+		return MiningStats{}, err
+	}
+
+	// The genesis block appoints the first signers, the first
+	// address paid by the genesis block when none are configured
+	if len(b.PrevHash) == 0 {
+		if len(b.Signers) == 0 && len(b.Transactions) > 0 {
+			b.Signers = [][]byte{b.Transactions[0].Outputs[0].PubKeyHash}
+		}
+		if len(b.Signers) == 0 {
+			return MiningStats{}, errors.New("proof of authority needs at least one signer")
+		}
+
+		b.Hash = e.headerHash(b)
+		return MiningStats{}, nil
+	}
+
+	auth, height, err := e.authoritiesBefore(chain, b)
+	if err != nil {
+		return MiningStats{}, err
+	}
+
+	inTurn := auth.inTurn(height)
+
+	wallets := wallet.CreateWallets()
+	w, _ := wallets.GetWalletByPubKeyHash(inTurn)
+	if w == nil {
+		return MiningStats{}, fmt.Errorf("it's the turn of %s to seal block %d, its wallet is not in %s", wallet.PubKeyHashToAddress(inTurn), height, wallet.DataDir)
+	}
+
+	if e.Vote != nil {
+		if err := auth.checkVote(e.Vote); err != nil {
+			return MiningStats{}, err
+		}
+		b.Vote = e.Vote
+	}
+
+	b.Signer = w.PublicKey
+	b.SignerCurve = w.Curve
+	b.Hash = e.headerHash(b)
+	b.Signature = wallet.Sign(w.Curve, w.PrivateKey, b.Hash)
+
+	return MiningStats{}, nil
+}
+
+// VerifySeal checks the block was signed by the signer in turn
+func (e *PoaEngine) VerifySeal(chain *BlockChain, b *Block) error {
+	if err := checkDifficulty(e, chain, b); err != nil {
+		return err
+	}
+
+	if len(b.PrevHash) == 0 {
+		if len(b.Signers) == 0 {
+			return errors.New("genesis block has no signers")
+		}
+		if bytes.Equal(e.headerHash(b), b.Hash) == false {
+			return errors.New("block hash does not match its data")
+		}
+		return nil
+	}
+
+	auth, height, err := e.authoritiesBefore(chain, b)
+	if err != nil {
+		return err
+	}
+
+	if len(b.Signer) == 0 {
+		return errors.New("block is not signed")
+	}
+
+	signer := wallet.PublicKeyHash(b.Signer)
+	if auth.index(signer) < 0 {
+		return fmt.Errorf("block signed by %s who is not an authority", wallet.PubKeyHashToAddress(signer))
+	}
+	if inTurn := auth.inTurn(height); bytes.Equal(signer, inTurn) == false {
+		return fmt.Errorf("block %d signed out of turn by %s, it's the turn of %s", height, wallet.PubKeyHashToAddress(signer), wallet.PubKeyHashToAddress(inTurn))
+	}
+
+	if bytes.Equal(e.headerHash(b), b.Hash) == false {
+		return errors.New("block hash does not match its data")
+	}
+	curve := b.SignerCurve
+	if curve == "" {
+		// Signed before the curve was recorded
+		var err error
+		if curve, err = wallet.PublicKeyCurve(b.Signer); err != nil {
+			return err
+		}
+	}
+	if wallet.Verify(curve, b.Signer, b.Hash, b.Signature) == false {
+		return errors.New("block signature is invalid")
+	}
+
+	if b.Vote != nil {
+		return auth.checkVote(b.Vote)
+	}
+
+	return nil
+}
+
+// Signers returns the public key hashes of the authorities allowed to seal the block following prev
+func (e *PoaEngine) Signers(chain *BlockChain, prev []byte) ([][]byte, error) {
+	auth, err := e.authoritiesAfter(chain, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.signers, nil
+}
+
+// headerHash hashes the data the authority signs
+func (e *PoaEngine) headerHash(b *Block) []byte {
+	data := [][]byte{
+		b.PrevHash,
+		b.HashTransactions(),
+		ToHex(b.Timestamp),
+		ToHex(int64(b.Difficulty)),
+		b.chainParams(),
+		bytes.Join(b.Signers, []byte{}),
+		b.Signer,
+		[]byte(b.SignerCurve),
+	}
+
+	if b.Vote != nil {
+		data = append(data, b.Vote.Signer, []byte(fmt.Sprint(b.Vote.Authorize)))
+	}
+
+	hash := sha256.Sum256(bytes.Join(data, []byte{}))
+
+	return hash[:]
+}
+
+// authoritiesBefore returns the signers allowed to seal the block and its height
+func (e *PoaEngine) authoritiesBefore(chain *BlockChain, b *Block) (*authorities, int, error) {
+	height, err := chain.GetBlockHeight(b.PrevHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	auth, err := e.authoritiesAfter(chain, b.PrevHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return auth, height + 1, nil
+}
+
+// authoritiesAfter returns the signers and votes once the block with the hash is connected,
+// the votes of the blocks since the last known snapshot or the genesis block are replayed
+func (e *PoaEngine) authoritiesAfter(chain *BlockChain, hash []byte) (*authorities, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.snapshots == nil {
+		e.snapshots = make(map[string]*authorities)
+	}
+
+	var blocks []*Block
+	var auth *authorities
+
+	for {
+		if snap, ok := e.snapshots[hex.EncodeToString(hash)]; ok {
+			auth = snap
+			break
+		}
+
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(block.PrevHash) == 0 {
+			auth = &authorities{block.Signers, make(map[string]map[string]bool)}
+			e.snapshots[hex.EncodeToString(block.Hash)] = auth
+			break
+		}
+
+		blocks = append(blocks, block)
+		hash = block.PrevHash
+	}
+
+	// Replay the votes from the oldest block
+	for i := len(blocks) - 1; i >= 0; i-- {
+		auth = auth.apply(blocks[i])
+		e.snapshots[hex.EncodeToString(blocks[i].Hash)] = auth
+	}
+
+	return auth, nil
+}
+
+// index returns the position of the signer or -1 when it's not an authority
+func (a *authorities) index(signer []byte) int {
+	for i, s := range a.signers {
+		if bytes.Equal(s, signer) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// inTurn returns the signer that seals the block at the height
+func (a *authorities) inTurn(height int) []byte {
+	return a.signers[height%len(a.signers)]
+}
+
+// checkVote makes sure the vote proposes a change to the signers
+func (a *authorities) checkVote(v *SignerVote) error {
+	address := wallet.PubKeyHashToAddress(v.Signer)
+	isSigner := a.index(v.Signer) >= 0
+
+	switch {
+	case v.Authorize && isSigner:
+		return fmt.Errorf("%s is already a signer", address)
+	case v.Authorize == false && isSigner == false:
+		return fmt.Errorf("%s is not a signer", address)
+	case v.Authorize == false && len(a.signers) == 1:
+		return errors.New("the last signer can't be removed")
+	}
+
+	return nil
+}
+
+// apply returns the authorities after the vote of the block is counted
+func (a *authorities) apply(b *Block) *authorities {
+	if b.Vote == nil {
+		return a
+	}
+
+	next := &authorities{a.signers, make(map[string]map[string]bool)}
+	for target, votes := range a.votes {
+		next.votes[target] = make(map[string]bool)
+		for voter, authorize := range votes {
+			next.votes[target][voter] = authorize
+		}
+	}
+
+	target := hex.EncodeToString(b.Vote.Signer)
+	if next.votes[target] == nil {
+		next.votes[target] = make(map[string]bool)
+	}
+	next.votes[target][hex.EncodeToString(wallet.PublicKeyHash(b.Signer))] = b.Vote.Authorize
+
+	count := 0
+	for _, authorize := range next.votes[target] {
+		if authorize == b.Vote.Authorize {
+			count++
+		}
+	}
+
+	// Not enough signers agree yet
+	if count <= len(a.signers)/2 {
+		return next
+	}
+
+	var signers [][]byte
+	if b.Vote.Authorize {
+		signers = append(signers, a.signers...)
+		signers = append(signers, b.Vote.Signer)
+	} else {
+		for _, s := range a.signers {
+			if bytes.Equal(s, b.Vote.Signer) == false {
+				signers = append(signers, s)
+			}
+		}
+	}
+
+	// Votes are counted against the signers they were cast with, start over
+	return &authorities{signers, make(map[string]map[string]bool)}
+}
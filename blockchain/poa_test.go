@@ -0,0 +1,215 @@
+package blockchain
+
+import (
+	"bytes"
+	"github.com/sheghun/blockchain/wallet"
+	"os"
+	"testing"
+	"time"
+)
+
+// newPoaChain creates a proof of authority chain in the directory with the signers
+// and pays the genesis reward to the first one
+func newPoaChain(t *testing.T, dir string, signers ...string) *BlockChain {
+	t.Helper()
+
+	DataDir = dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := DefaultGenesis(signers[0], PoaConsensus)
+	genesis.Signers = signers
+
+	return InitBlockChainGenesis(genesis)
+}
+
+// signerKeys returns the public keys of new wallets which are never saved
+func signerKeys(n int) [][]byte {
+	var keys [][]byte
+	for i := 0; i < n; i++ {
+		keys = append(keys, wallet.MakeWallet(wallet.Secp256k1).PublicKey)
+	}
+
+	return keys
+}
+
+func TestPoaSignersTakeTurns(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "a", "b", "c")
+	signers := []string{addresses["a"], addresses["b"], addresses["c"]}
+
+	chain := newPoaChain(t, dir, signers...)
+	defer chain.Database.Close()
+
+	for height := 1; height <= 6; height++ {
+		tx := NewTransaction(addresses["a"], addresses["c"], 1, chain)
+		chain.AddBlock([]*Transaction{tx})
+
+		block, err := chain.GetBlock(chain.LastHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := signers[height%len(signers)]
+		if got := wallet.PubKeyHashToAddress(wallet.PublicKeyHash(block.Signer)); got != want {
+			t.Errorf("block %d was sealed by %s instead of %s", height, got, want)
+		}
+	}
+}
+
+func TestPoaRefusesBlockOutOfTurn(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "a", "b")
+	chain := newPoaChain(t, dir, addresses["a"], addresses["b"])
+	defer chain.Database.Close()
+
+	// The first block is the turn of b, a signs it
+	wallets := wallet.CreateWallets()
+	w := wallets.GetWallet(addresses["a"])
+
+	b := &Block{
+		Transactions: []*Transaction{CoinbaseTx(addresses["a"], "")},
+		PrevHash:     chain.LastHash,
+		Timestamp:    time.Now().Unix(),
+		Difficulty:   1,
+		Signer:       w.PublicKey,
+		SignerCurve:  w.Curve,
+	}
+	engine := chain.Engine.(*PoaEngine)
+	b.Hash = engine.headerHash(b)
+	b.Signature = wallet.Sign(w.Curve, w.PrivateKey, b.Hash)
+
+	if err := chain.VerifyBlock(b); err == nil {
+		t.Fatal("a block signed out of turn was accepted")
+	}
+
+	// The same block signed by b is valid
+	w = wallets.GetWallet(addresses["b"])
+	b.Signer = w.PublicKey
+	b.SignerCurve = w.Curve
+	b.Hash = engine.headerHash(b)
+	b.Signature = wallet.Sign(w.Curve, w.PrivateKey, b.Hash)
+
+	if err := chain.VerifyBlock(b); err != nil {
+		t.Fatalf("the block signed in turn was refused: %s", err)
+	}
+
+	// Every block has the same difficulty
+	b.Difficulty = 2
+	b.Hash = engine.headerHash(b)
+	b.Signature = wallet.Sign(w.Curve, w.PrivateKey, b.Hash)
+
+	if err := chain.VerifyBlock(b); err == nil {
+		t.Error("a block with another difficulty was accepted")
+	}
+}
+
+func TestPoaVoteTally(t *testing.T) {
+	keys := signerKeys(4)
+	var signers [][]byte
+	for _, key := range keys[:3] {
+		signers = append(signers, wallet.PublicKeyHash(key))
+	}
+	candidate := wallet.PublicKeyHash(keys[3])
+
+	auth := &authorities{signers, make(map[string]map[string]bool)}
+	vote := func(a *authorities, signer []byte, v *SignerVote) *authorities {
+		return a.apply(&Block{Signer: signer, Vote: v})
+	}
+	add := &SignerVote{candidate, true}
+
+	// A signer voting twice is counted once
+	auth = vote(auth, keys[0], add)
+	auth = vote(auth, keys[0], add)
+	if len(auth.signers) != 3 {
+		t.Fatalf("a single signer added a signer, there are %d", len(auth.signers))
+	}
+
+	// Blocks without a vote keep the tally
+	auth = auth.apply(&Block{Signer: keys[2]})
+
+	// Two of three signers are a majority
+	auth = vote(auth, keys[1], add)
+	if len(auth.signers) != 4 || auth.index(candidate) != 3 {
+		t.Fatalf("the candidate wasn't added as the last signer by a majority, the signers are %x", auth.signers)
+	}
+	if len(auth.votes) != 0 {
+		t.Error("the votes weren't cleared once the signers changed")
+	}
+	if err := auth.checkVote(add); err == nil {
+		t.Error("a vote to add an existing signer was allowed")
+	}
+
+	// Two of four signers aren't a majority, the vote against doesn't count for the removal
+	remove := &SignerVote{signers[0], false}
+	auth = vote(auth, keys[1], remove)
+	auth = vote(auth, keys[2], remove)
+	auth = vote(auth, keys[3], &SignerVote{signers[0], true})
+	if len(auth.signers) != 4 {
+		t.Fatal("the signer was removed by two of four signers")
+	}
+
+	auth = vote(auth, keys[3], remove)
+	if len(auth.signers) != 3 || auth.index(signers[0]) >= 0 {
+		t.Fatalf("the signer wasn't removed by a majority, the signers are %x", auth.signers)
+	}
+	if !bytes.Equal(auth.inTurn(0), signers[1]) {
+		t.Errorf("the turns didn't move up after the removal, %x is in turn", auth.inTurn(0))
+	}
+}
+
+func TestPoaVoteAddsSigner(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "a", "b", "c")
+	chain := newPoaChain(t, dir, addresses["a"], addresses["b"])
+	defer chain.Database.Close()
+
+	candidate, err := wallet.DecodeAddress(addresses["c"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := chain.Engine.(*PoaEngine)
+	engine.Vote = &SignerVote{candidate, true}
+
+	// b then a vote for c, the second vote is a majority of two signers
+	for height := 1; height <= 2; height++ {
+		tx := NewTransaction(addresses["a"], addresses["b"], 1, chain)
+		chain.AddBlock([]*Transaction{tx})
+
+		signers, err := engine.Signers(chain, chain.LastHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := 2
+		if height == 2 {
+			want = 3
+		}
+		if len(signers) != want {
+			t.Fatalf("there are %d signers after block %d instead of %d", len(signers), height, want)
+		}
+	}
+	engine.Vote = nil
+
+	// The new signer takes the last turn, at height 5 of three signers
+	for height := 3; height <= 5; height++ {
+		tx := NewTransaction(addresses["a"], addresses["b"], 1, chain)
+		chain.AddBlock([]*Transaction{tx})
+	}
+
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(block.Signer), candidate) {
+		t.Errorf("block 5 wasn't sealed by the new signer")
+	}
+}
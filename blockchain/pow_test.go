@@ -0,0 +1,164 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// randomBlock returns a block with random data to mine
+func randomBlock(t testing.TB) *Block {
+	prevHash := make([]byte, 32)
+	if _, err := rand.Read(prevHash); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Block{Transactions: []*Transaction{{ID: prevHash}}, PrevHash: prevHash}
+}
+
+// workerCounts returns the worker counts to benchmark, with the number of CPUs
+func workerCounts() []int {
+	counts := []int{1, 2, 4}
+	if runtime.NumCPU() > 4 {
+		counts = append(counts, runtime.NumCPU())
+	}
+
+	return counts
+}
+
+func TestMineFindsValidNonce(t *testing.T) {
+	for _, name := range PowHashes() {
+		hash, err := GetPowHash(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{1, 3} {
+			b := randomBlock(t)
+			p := NewProofWithDifficulty(b, 4)
+			p.Hash = hash
+
+			nonce, h, stats, err := p.Mine(context.Background(), workers)
+			if err != nil {
+				t.Fatalf("%s with %d workers: %s", name, workers, err)
+			}
+			if stats.Attempts == 0 || stats.Workers != workers {
+				t.Errorf("%s with %d workers: the statistics are %+v", name, workers, stats)
+			}
+
+			b.Nonce = nonce
+			sum := hash.Sum(p.InitData(nonce))
+			if p.Validate() == false || bytes.Equal(sum[:], h) == false {
+				t.Errorf("%s with %d workers: the nonce %d doesn't meet the target", name, workers, nonce)
+			}
+		}
+	}
+}
+
+func TestMineStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Hardly any hash meets the target of the highest difficulty
+	p := NewProofWithDifficulty(randomBlock(t), MaxDifficulty)
+
+	if _, _, _, err := p.Mine(ctx, 2); err != context.Canceled {
+		t.Fatalf("mining a cancelled block returned %v", err)
+	}
+}
+
+func TestMineExhaustsNonces(t *testing.T) {
+	defer func(max int) { MaxNonce = max }(MaxNonce)
+	MaxNonce = 100
+
+	p := NewProofWithDifficulty(randomBlock(t), MaxDifficulty)
+
+	_, _, stats, err := p.Mine(context.Background(), 3)
+	if err != ErrNonceSpaceExhausted {
+		t.Fatalf("mining past the last nonce returned %v", err)
+	}
+	if stats.Attempts != uint64(MaxNonce+1) {
+		t.Errorf("%d nonces were tried instead of %d", stats.Attempts, MaxNonce+1)
+	}
+}
+
+func TestExtraNonceEncoding(t *testing.T) {
+	coinbase := &Transaction{nil, []TxInput{{[]byte{}, -1, nil, []byte("coinbase"), nil, SigHashLegacy, ""}}, []TxOutput{{50, make([]byte, 20), nil}}, 1}
+	coinbase.SetID()
+
+	decoded, err := DeserializeTransaction(coinbase.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ExtraNonce != 1 || !bytes.Equal(decoded.Hash(), coinbase.ID) {
+		t.Errorf("the extra nonce was decoded as %d", decoded.ExtraNonce)
+	}
+
+	// Transactions without an extra nonce keep the first encoding version
+	coinbase.ExtraNonce = 0
+	if version := coinbase.Serialize()[0]; version != firstEncodingVersion {
+		t.Errorf("a transaction without an extra nonce was encoded with version %d", version)
+	}
+}
+
+func TestRollHeader(t *testing.T) {
+	b := randomBlock(t)
+	id := b.Transactions[0].ID
+
+	// A timestamp behind the clock is moved to it
+	b.Timestamp = 1
+	b.rollHeader()
+	if now := time.Now().Unix(); b.Timestamp < now-1 || b.Timestamp > now {
+		t.Errorf("the timestamp was moved to %d instead of the clock at %d", b.Timestamp, now)
+	}
+
+	// A timestamp ahead of the clock can only be moved forward by the roll
+	start := time.Now().Unix() + 60
+	b.Timestamp = start
+	b.rollHeader()
+	if b.Timestamp != start+1 {
+		t.Errorf("the timestamp was moved from %d to %d", start, b.Timestamp)
+	}
+
+	if b.Transactions[0].ExtraNonce != 0 || !bytes.Equal(b.Transactions[0].ID, id) {
+		t.Error("the transactions were changed by the roll")
+	}
+}
+
+// BenchmarkMine mines blocks with random data with every hash function and worker
+// count at the default difficulty of the hash, the hash rate is reported as H/s
+func BenchmarkMine(b *testing.B) {
+	for _, name := range PowHashes() {
+		hash, err := GetPowHash(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, workers := range workerCounts() {
+			b.Run(fmt.Sprintf("%s/workers=%d", name, workers), func(b *testing.B) {
+				var total MiningStats
+
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					p := NewProofWithDifficulty(randomBlock(b), hash.Difficulty)
+					p.Hash = hash
+					b.StartTimer()
+
+					_, _, stats, err := p.Mine(context.Background(), workers)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					total.Attempts += stats.Attempts
+					total.Duration += stats.Duration
+				}
+
+				b.ReportMetric(total.HashRate(), "H/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PowHash is a hash function blocks can be mined with
+type PowHash struct {
+	Name       string                     // Appended to the engine name, e.g. pow-scrypt
+	Sum        func(data []byte) [32]byte // Hashes the proof of work data
+	Difficulty int                        // Difficulty of new chains, lower for slower hashes
+}
+
+// DefaultPowHash is the single sha256 the chain has always been mined with
+var DefaultPowHash = &PowHash{"sha256", sha256.Sum256, Difficulty}
+
+var powHashes = map[string]*PowHash{
+	"sha256":  DefaultPowHash,
+	"sha256d": {"sha256d", doubleSha256, Difficulty},
+	"scrypt":  {"scrypt", scryptHash, 8},
+	"argon2":  {"argon2", argon2Hash, 6},
+}
+
+func init() {
+	for name, h := range powHashes {
+		if h == DefaultPowHash {
+			continue // Already registered as the default engine
+		}
+
+		h := h
+		RegisterConsensus(DefaultConsensus+"-"+name, func() Consensus { return &PowEngine{Hash: h} })
+	}
+}
+
+// GetPowHash returns the hash function with the name
+func GetPowHash(name string) (*PowHash, error) {
+	h, ok := powHashes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown proof of work hash %q", name)
+	}
+
+	return h, nil
+}
+
+// PowHashes returns the names of the hash functions blocks can be mined with
+func PowHashes() []string {
+	var names []string
+
+	for name := range powHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// doubleSha256 hashes the data twice like bitcoin block headers
+func doubleSha256(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+
+	return sha256.Sum256(first[:])
+}
+
+// scryptHash uses the scrypt parameters of litecoin, the data is its own salt
+func scryptHash(data []byte) [32]byte {
+	var hash [32]byte
+
+	key, err := scrypt.Key(data, data, 1024, 1, 1, 32)
+	Handle(err)
+	copy(hash[:], key)
+
+	return hash
+}
+
+// argon2Hash is memory-hard, every hash needs 4 MiB of memory
+// so mining hardware can't trade memory for parallelism cheaply
+func argon2Hash(data []byte) [32]byte {
+	var hash [32]byte
+
+	copy(hash[:], argon2.IDKey(data, data, 1, 4*1024, 1, 32))
+
+	return hash
+}
@@ -2,11 +2,17 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,16 +21,33 @@ const Difficulty = 12
 
 // ProofOfWork struct
 type ProofOfWork struct {
-	Block  *Block   // Block to verify
-	Target *big.Int // Target to proof
+	Block      *Block   // Block to verify
+	Target     *big.Int // Target to proof
+	Difficulty int      // Leading zero bits the hash needs
+	Hash       *PowHash // Hash function the block is mined with
 }
 
 // NewProofOfWork cares a new ProofOfWork instance
+// at the difficulty recorded in the block
 func NewProof(b *Block) *ProofOfWork {
+	if b.Difficulty != 0 {
+		return NewProofWithDifficulty(b, b.Difficulty)
+	}
+
+	return NewProofWithDifficulty(b, Difficulty)
+}
+
+// NewProofWithDifficulty creates a ProofOfWork needing the supplied leading zero bits
+// it panics when the difficulty is not between 0 and MaxDifficulty
+func NewProofWithDifficulty(b *Block, difficulty int) *ProofOfWork {
+	if difficulty < 0 || difficulty > MaxDifficulty {
+		Handle(fmt.Errorf("difficulty %d is not between 0 and %d", difficulty, MaxDifficulty))
+	}
+
 	t := big.NewInt(1)
-	t.Lsh(t, uint(256-Difficulty))
+	t.Lsh(t, uint(256-difficulty))
 
-	p := &ProofOfWork{b, t}
+	p := &ProofOfWork{b, t, difficulty, DefaultPowHash}
 	return p
 }
 
@@ -32,48 +55,180 @@ func NewProof(b *Block) *ProofOfWork {
 // Takes the nonce as an argument
 // Combines the bytes of the block(prevHash + blockData), the nonce and returns the byte
 func (p *ProofOfWork) InitData(n int) []byte {
-	b := bytes.Join(
-		[][]byte{
-			p.Block.PrevHash,
-			p.Block.HashTransactions(),
-			ToHex(int64(n)),
-			ToHex(int64(Difficulty))},
-		[]byte{},
-	)
-
-	return b
+	data := [][]byte{
+		p.Block.PrevHash,
+		p.Block.HashTransactions(),
+		ToHex(int64(n)),
+		ToHex(int64(p.Difficulty)),
+	}
+
+	// Blocks mined before timestamps existed were hashed without one
+	if p.Block.Timestamp != 0 {
+		data = append(data, ToHex(p.Block.Timestamp))
+	}
+
+	// The genesis block commits to the consensus engine and parameters of the chain
+	if params := p.Block.chainParams(); len(params) > 0 {
+		data = append(data, params)
+	}
+
+	return bytes.Join(data, []byte{})
+}
+
+// MinerWorkers is the number of goroutines mining a block
+var MinerWorkers = runtime.NumCPU()
+
+// MaxNonce is the last nonce tried before the block header is rolled
+var MaxNonce = math.MaxInt64
+
+// ErrNonceSpaceExhausted is returned when every nonce was tried without meeting the target
+var ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+
+// MiningStats describes the work done to mine a block
+type MiningStats struct {
+	Attempts uint64        // Hashes computed by all the workers
+	Duration time.Duration // Time spent mining
+	Workers  int           // Goroutines that mined
+}
+
+// HashRate returns the hashes computed per second
+func (s MiningStats) HashRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Attempts) / s.Duration.Seconds()
+}
+
+func (s MiningStats) String() string {
+	return fmt.Sprintf("%d hashes in %s by %d worker(s), %.0f H/s", s.Attempts, s.Duration.Round(time.Millisecond), s.Workers, s.HashRate())
 }
 
 // Run does the ProofOfWork calculation
 // starts the nonce from zero(bytes) appends the nonce to the block bytes
 // hashes the bytes check if it's lower than the target
 func (p *ProofOfWork) Run() (int, []byte) {
-	nonce := 0 // nonce to increment
+	n, h, _, err := p.Mine(context.Background(), MinerWorkers)
+	Handle(err)
+
+	return n, h
+}
+
+// Mine searches for a nonce with a fixed pool of workers, worker i tries the nonces
+// i, i+workers, i+2*workers... so they never overlap
+// it stops early with the context error when the context is cancelled,
+// e.g. when a competing block arrives
+func (p *ProofOfWork) Mine(ctx context.Context, workers int) (int, []byte, MiningStats, error) {
+	if workers < 1 {
+		workers = 1
+	}
 
-	c := make(chan struct {
+	mining, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
 		nonce int
 		hash  []byte
-	})
-
-	cl := make(chan bool)
-
-	for nonce < math.MaxInt64 {
-		select {
-		// Check if nonce has been found and sent to channel
-		case d := <-c:
-			// Close the other channels
-			close(cl)
-			return d.nonce, d.hash[:]
-		default:
-			go calNonce(c, nonce, nonce+3000, p, cl)
-			nonce += 3000
+	}
+
+	found := make(chan result, workers)
+	var attempts uint64
+	var wg sync.WaitGroup
+
+	// The nonce is the only part of the data changing between attempts
+	data := p.InitData(0)
+	nonceAt := len(p.Block.PrevHash) + len(p.Block.HashTransactions())
+
+	start := time.Now()
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(nonce int) {
+			defer wg.Done()
+
+			var bigH big.Int
+			var tried uint64
+			d := append([]byte{}, data...)
+
+			defer func() { atomic.AddUint64(&attempts, tried) }()
+
+			for {
+				// Check for cancellation every few thousand hashes
+				if tried%4096 == 0 {
+					select {
+					case <-mining.Done():
+						return
+					default:
+					}
+				}
+
+				binary.BigEndian.PutUint64(d[nonceAt:], uint64(nonce))
+				h := p.Hash.Sum(d)
+				tried++
+
+				bigH.SetBytes(h[:])
+				if bigH.Cmp(p.Target) == -1 {
+					found <- result{nonce, h[:]}
+					cancel()
+					return
+				}
+
+				if nonce > MaxNonce-workers {
+					return
+				}
+				nonce += workers
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	stats := MiningStats{atomic.LoadUint64(&attempts), time.Since(start), workers}
+
+	select {
+	case r := <-found:
+		return r.nonce, r.hash, stats, nil
+	default:
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, stats, err
+	}
+
+	return 0, nil, stats, ErrNonceSpaceExhausted
+}
+
+// BenchmarkMining mines the number of rounds of blocks with random data with the hash
+// at the difficulty and returns the combined statistics, no database is needed
+func BenchmarkMining(hash *PowHash, difficulty, workers, rounds int) (MiningStats, error) {
+	total := MiningStats{Workers: workers}
+
+	if difficulty < 0 || difficulty > MaxDifficulty {
+		return total, fmt.Errorf("difficulty %d is not between 0 and %d", difficulty, MaxDifficulty)
+	}
+
+	for i := 0; i < rounds; i++ {
+		prevHash := make([]byte, 32)
+		if _, err := rand.Read(prevHash); err != nil {
+			return total, err
 		}
-		time.Sleep(100 * time.Millisecond)
+
+		b := &Block{Transactions: []*Transaction{{ID: prevHash}}, PrevHash: prevHash}
+
+		p := NewProofWithDifficulty(b, difficulty)
+		p.Hash = hash
+
+		_, _, stats, err := p.Mine(context.Background(), workers)
+		if err != nil {
+			return total, err
+		}
+
+		total.Attempts += stats.Attempts
+		total.Duration += stats.Duration
 	}
-	// Wait for the nonce to be supplied
-	d := <-c
-	close(cl)
-	return d.nonce, d.hash[:]
+
+	return total, nil
 }
 
 // Validate verifies the block by running the proof of work algorithm
@@ -83,7 +238,7 @@ func (p *ProofOfWork) Validate() bool {
 	var bigH big.Int
 
 	d := p.InitData(p.Block.Nonce)
-	h := sha256.Sum256(d)
+	h := p.Hash.Sum(d)
 
 	bigH.SetBytes(h[:])
 
@@ -102,45 +257,3 @@ func ToHex(num int64) []byte {
 
 	return b.Bytes()
 }
-
-// Calculates the supplied nonce input and check if it satisfy the target
-func calNonce(c chan struct {
-	nonce int    // This is the nonce
-	hash  []byte // The hash byte
-}, n int, e int, p *ProofOfWork, closeC <-chan bool) {
-	var bigH big.Int
-
-	for n < e {
-		select {
-		// Check if channel is closed
-		case _, cl := <-closeC:
-			if !cl {
-				// Close the goroutine
-				break
-			}
-		// Run the default case
-		default:
-			d := p.InitData(n)    // proof of work byt es
-			h := sha256.Sum256(d) // hash
-
-			bigH.SetBytes(h[:]) // Read the bytes into the int
-
-			if bigH.Cmp(p.Target) == -1 {
-				// Send in the struct
-				c <- struct {
-					nonce int
-					hash  []byte
-				}{
-					nonce: n,
-					hash:  h[:],
-				}
-
-				break
-			}
-
-			n++ // Increment the nonce
-		}
-
-	}
-
-}
@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/wallet"
+	"time"
+)
+
+// NewRawTransaction creates an unsigned transaction spending the outputs the
+// inputs point to, the inputs are signed by their owners with SignRawTransaction
+func NewRawTransaction(inputs []TxInput, outputs []TxOutput) (*Transaction, error) {
+	if len(inputs) == 0 || len(outputs) == 0 {
+		return nil, errors.New("a transaction needs at least an input and an output")
+	}
+
+	tx := &Transaction{nil, inputs, outputs, 0}
+	if err := tx.checkOutpoints(); err != nil {
+		return nil, err
+	}
+	tx.SetID()
+
+	return tx, nil
+}
+
+// SignRawTransaction signs the inputs spending outputs locked to the wallets
+// with the hash type and returns how many were signed, the inputs of other
+// owners are left as they are so they can sign them with their own wallets
+func (chain *BlockChain) SignRawTransaction(tx *Transaction, hashType SigHashType) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, errors.New("a coinbase transaction can't be signed")
+	}
+
+	prevTxs, err := chain.findPrevTransactions(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	hashes, err := newSigHashes(tx, prevTxs)
+	if err != nil {
+		return 0, err
+	}
+
+	wallets := wallet.CreateWallets()
+	signed := 0
+
+	for inId := range tx.Inputs {
+		in := &tx.Inputs[inId]
+		prevOut := hashes.prevOuts[inId]
+
+		// Contracts are claimed and refunded with their own commands
+		if prevOut.HTLC != nil {
+			continue
+		}
+
+		w, _ := wallets.GetWalletByPubKeyHash(prevOut.PubKeyHash)
+		if w == nil {
+			continue
+		}
+
+		in.PubKey = w.PublicKey
+		if err := tx.signInput(hashes, inId, *w, hashType); err != nil {
+			return signed, err
+		}
+		signed++
+	}
+
+	tx.SetID()
+
+	return signed, nil
+}
+
+// CheckRawTransaction checks a transaction built outside the wallet can be added to
+// the chain, its ID must match its contents, every input has to spend an output that
+// is still unspent with a valid signature and the outputs can't be worth more
+func (chain *BlockChain) CheckRawTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return errors.New("coinbase transactions are only created by blocks")
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return errors.New("a transaction needs at least an input and an output")
+	}
+	if err := tx.checkID(false); err != nil {
+		return err
+	}
+	if err := tx.checkOutpoints(); err != nil {
+		return err
+	}
+
+	for i, out := range tx.Outputs {
+		if out.Value <= 0 {
+			return fmt.Errorf("output %d has the value %d", i, out.Value)
+		}
+	}
+
+	prevTxs, err := chain.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	fee, err := tx.Fee(prevTxs)
+	if err != nil {
+		return err
+	}
+	if fee < 0 {
+		return fmt.Errorf("the outputs are worth %d more than the inputs", -fee)
+	}
+
+	if err := chain.checkUnspent(tx, prevTxs); err != nil {
+		return err
+	}
+
+	if tx.Verify(prevTxs, time.Now().Unix()) == false {
+		return errors.New("the signatures of the transaction are invalid")
+	}
+
+	return nil
+}
+
+// checkOutpoints checks no output is spent twice by the transaction
+func (t *Transaction) checkOutpoints() error {
+	outpoints := make(map[string]bool)
+
+	for _, in := range t.Inputs {
+		outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+		if outpoints[outpoint] {
+			return fmt.Errorf("output %s is spent twice", outpoint)
+		}
+		outpoints[outpoint] = true
+	}
+
+	return nil
+}
+
+// checkUnspent checks no transaction in the chain already spent the outputs of the inputs,
+// a transaction spending an output touches the addresses it's locked to so only their
+// address index entries are read
+func (chain *BlockChain) checkUnspent(tx *Transaction, prevTxs map[string]Transaction) error {
+	blocks := make(map[string]*Block)
+
+	return chain.Database.View(func(txn *badger.Txn) error {
+		for _, in := range tx.Inputs {
+			prevOut := prevTxs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+			owners := [][]byte{prevOut.PubKeyHash}
+			if prevOut.HTLC != nil {
+				owners = [][]byte{prevOut.HTLC.ReceiverHash, prevOut.HTLC.RefundHash}
+			}
+
+			for _, owner := range owners {
+				entries, err := readAddressIndex(txn, owner)
+				if err != nil {
+					return err
+				}
+
+				for _, entry := range entries {
+					t, err := indexedTransaction(txn, entry, blocks)
+					if err != nil {
+						return err
+					}
+					if t.IsCoinbase() {
+						continue
+					}
+
+					for _, spent := range t.Inputs {
+						if bytes.Equal(spent.ID, in.ID) && spent.Out == in.Out {
+							return fmt.Errorf("output %x:%d was already spent in transaction %x", in.ID, in.Out, t.ID)
+						}
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// indexedTransaction reads the transaction of the address index entry, the blocks read are kept in blocks
+func indexedTransaction(txn *badger.Txn, entry AddressTx, blocks map[string]*Block) (*Transaction, error) {
+	block, ok := blocks[string(entry.BlockHash)]
+	if ok == false {
+		item, err := txn.Get(entry.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		if block, err = DeserializeBlock(val); err != nil {
+			return nil, err
+		}
+		blocks[string(entry.BlockHash)] = block
+	}
+
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, entry.TxID) {
+			return tx, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction %x is not in block %x", entry.TxID, entry.BlockHash)
+}
@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawOutput returns an output paying the amount to the address
+func rawOutput(t *testing.T, address string, amount int) TxOutput {
+	t.Helper()
+
+	out, err := NewTxOutput(amount, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *out
+}
+
+// roundTrip serializes and decodes the transaction like the raw transaction commands pass it on
+func roundTrip(t *testing.T, tx *Transaction) *Transaction {
+	t.Helper()
+
+	decoded, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestRawTransaction(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	inputs := []TxInput{{coinbase.ID, 0, nil, nil, nil, SigHashLegacy, ""}}
+
+	// Created, passed on and signed by the owner of the input
+	tx, err := NewRawTransaction(inputs, []TxOutput{rawOutput(t, bob, 30), rawOutput(t, alice, 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = roundTrip(t, tx)
+
+	if err := chain.CheckRawTransaction(tx); err == nil {
+		t.Fatal("the unsigned transaction can be sent")
+	}
+
+	signed, err := chain.SignRawTransaction(tx, SigHashAll)
+	if err != nil || signed != 1 {
+		t.Fatalf("%d inputs were signed, %v", signed, err)
+	}
+	tx = roundTrip(t, tx)
+
+	if err := chain.CheckRawTransaction(tx); err != nil {
+		t.Fatalf("the signed transaction can't be sent: %s", err)
+	}
+
+	// A changed transaction no longer matches its ID or its signature
+	changed := roundTrip(t, tx)
+	changed.Outputs[0].Value = 31
+	changed.Outputs[1].Value = 19
+	if err := chain.CheckRawTransaction(changed); err == nil {
+		t.Error("a transaction with an ID not matching its contents can be sent")
+	}
+	changed.SetID()
+	if err := chain.CheckRawTransaction(changed); err == nil {
+		t.Error("a transaction with outputs changed after signing can be sent")
+	}
+
+	// Sent
+	chain.AddBlock([]*Transaction{tx})
+	if got := balance(t, chain, bob); got != 30 {
+		t.Errorf("bob has %d instead of 30", got)
+	}
+	if got := balance(t, chain, alice); got != 20 {
+		t.Errorf("alice has %d instead of 20", got)
+	}
+
+	// The output of the genesis block can't be spent again, even once more blocks were added
+	chain.AddBlock([]*Transaction{NewTransaction(bob, alice, 5, chain)})
+
+	again, err := NewRawTransaction(inputs, []TxOutput{rawOutput(t, bob, 50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.SignRawTransaction(again, SigHashAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckRawTransaction(again); err == nil || strings.Contains(err.Error(), "already spent") == false {
+		t.Errorf("the output spent twice gave the error %v", err)
+	}
+
+	// Outputs worth more than the inputs
+	more, err := NewRawTransaction([]TxInput{{tx.ID, 0, nil, nil, nil, SigHashLegacy, ""}}, []TxOutput{rawOutput(t, alice, 31)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.SignRawTransaction(more, SigHashAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckRawTransaction(more); err == nil {
+		t.Error("a transaction creating coins can be sent")
+	}
+
+	if _, err := NewRawTransaction(append(inputs, inputs...), []TxOutput{rawOutput(t, bob, 50)}); err == nil {
+		t.Error("a transaction spending an output twice was created")
+	}
+}
+
+// A redeemed contract output is spent by one of the addresses of the contract
+func TestRawTransactionSpentContract(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	secret := []byte("the secret of the contract")
+	secretHash := sha256.Sum256(secret)
+
+	lock := NewHTLCTransaction(alice, bob, 40, secretHash[:], time.Now().Unix()+3600, chain)
+	chain.AddBlock([]*Transaction{lock})
+	chain.AddBlock([]*Transaction{NewHTLCRedeem(lock.ID, 0, secret, "", chain)})
+
+	tx, err := NewRawTransaction([]TxInput{{lock.ID, 0, nil, nil, nil, SigHashLegacy, ""}}, []TxOutput{rawOutput(t, alice, 40)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckRawTransaction(tx); err == nil || strings.Contains(err.Error(), "already spent") == false {
+		t.Errorf("the redeemed contract output gave the error %v", err)
+	}
+}
+
+// The transactions built by the wallet have the IDs of their signed contents and
+// blocks holding transactions with other IDs are neither mined nor imported
+func TestWalletTransactionIDs(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	chain := newTestChain(t, dir, alice)
+	defer chain.Database.Close()
+
+	send := NewTransaction(alice, bob, 20, chain)
+	if err := chain.CheckRawTransaction(send); err != nil {
+		t.Fatalf("the transaction of the wallet can't be sent: %s", err)
+	}
+	chain.AddBlock([]*Transaction{send})
+
+	secret := []byte("the secret")
+	secretHash := sha256.Sum256(secret)
+	lock := NewHTLCTransaction(bob, alice, 5, secretHash[:], time.Now().Unix()+60*60, chain)
+	if err := chain.CheckRawTransaction(lock); err != nil {
+		t.Fatalf("the contract of the wallet can't be sent: %s", err)
+	}
+	chain.AddBlock([]*Transaction{lock})
+
+	claim := NewHTLCRedeem(lock.ID, 0, secret, "", chain)
+	if err := chain.CheckRawTransaction(claim); err != nil {
+		t.Fatalf("the claim of the wallet can't be sent: %s", err)
+	}
+
+	// Signed with another ID
+	claim.ID = send.ID
+	if _, _, err := chain.AddBlockContext(context.Background(), []*Transaction{claim}); err == nil {
+		t.Error("a block with a transaction not matching its ID was mined")
+	}
+
+	prev, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{Transactions: []*Transaction{claim}, Hash: []byte{}, PrevHash: prev.Hash, Timestamp: prev.Timestamp + 1, Difficulty: chain.Engine.NextDifficulty(chain, prev)}
+	if _, err := chain.Engine.Seal(context.Background(), chain, block, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.connectBlock(block, 3); err == nil || strings.Contains(err.Error(), "doesn't match") == false {
+		t.Errorf("the block with a transaction not matching its ID gave the error %v", err)
+	}
+	if chain.GetBestHeight() != 2 {
+		t.Error("a block with a transaction not matching its ID was stored")
+	}
+}
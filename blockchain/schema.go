@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger"
+)
+
+// The layout of the database, blocks are stored under their hash, the hash of the
+// last block under "lh" and the magic of the network under "magic", index entries
+// have the prefixes of the index keys.
+// The schema version records the layout, it's upgraded by the migrations one
+// version at a time when the chain is opened and a database with a version
+// newer than the one of the binary is refused before anything is read from it
+var schemaKey = []byte("schema")
+
+// migration upgrades the database from the schema version before it, it's run
+// again when interrupted so it has to skip the work already done
+type migration struct {
+	description string
+	migrate     func(chain *BlockChain) error
+}
+
+// migrations are the upgrades to every schema version, the first one upgrades
+// the databases created before the schema version was recorded
+var migrations = []migration{
+	{"convert the blocks from gob to the binary encoding", (*BlockChain).migrateBinaryEncoding},
+	{"build the height, transaction and address indexes", (*BlockChain).migrateIndexes},
+	{"record the network of the chain", (*BlockChain).migrateNetwork},
+	{"store an address index entry per transaction and output", (*BlockChain).migrateAddressIndex},
+}
+
+// schemaVersion is the version of the layout written by this binary
+var schemaVersion = len(migrations)
+
+// storedSchemaVersion returns the schema version of the database, 0 when it was never recorded
+func (chain *BlockChain) storedSchemaVersion() (int, error) {
+	var version int
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(schemaKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			if len(val) != 1 {
+				return fmt.Errorf("the schema version holds %d bytes instead of 1", len(val))
+			}
+			version = int(val[0])
+			return nil
+		})
+	})
+
+	return version, err
+}
+
+// setSchemaVersion records the schema version in the database
+func setSchemaVersion(txn *badger.Txn, version int) error {
+	return txn.Set(schemaKey, []byte{byte(version)})
+}
+
+// migrate upgrades the database to the schema version of the binary,
+// a database written by a newer binary is refused as it can't be read
+func (chain *BlockChain) migrate() error {
+	version, err := chain.storedSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > schemaVersion {
+		return fmt.Errorf("the blockchain in %s has the schema version %d but this binary only reads up to %d, upgrade the binary to open it", DataDir, version, schemaVersion)
+	}
+
+	for ; version < schemaVersion; version++ {
+		m := migrations[version]
+		fmt.Printf("Upgrading the database to schema version %d: %s\n", version+1, m.description)
+
+		if err := m.migrate(chain); err != nil {
+			return fmt.Errorf("upgrading the database to schema version %d: %s", version+1, err)
+		}
+
+		next := version + 1
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return setSchemaVersion(txn, next)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package blockchain
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger"
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/wallet"
+	"strings"
+	"testing"
+)
+
+// setStoredSchema records the schema version and the version of the indexes
+// like a database written by an older binary, 0 removes them
+func setStoredSchema(t *testing.T, chain *BlockChain, version int, indexed byte) {
+	t.Helper()
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := setSchemaVersion(txn, version); err != nil {
+			return err
+		}
+		if indexed == 0 {
+			return txn.Delete(indexedKey)
+		}
+		return txn.Set(indexedKey, []byte{indexed})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateIndexes(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	bobHash, err := wallet.DecodeAddress(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newTestChain(t, dir, alice)
+	tx := NewTransaction(alice, bob, 10, chain)
+	chain.AddBlock([]*Transaction{tx})
+
+	// Schema version 1 without indexes, they are built by the upgrade
+	for _, prefix := range [][]byte{txIndexPrefix, addrIndexPrefix, addrOutputPrefix, heightIndexPrefix, blockHeightPrefix} {
+		if err := chain.dropIndex(prefix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setStoredSchema(t, chain, 1, 0)
+	chain.Database.Close()
+
+	chain = ContinueBlockChain()
+
+	if version, err := chain.storedSchemaVersion(); err != nil || version != schemaVersion {
+		t.Errorf("the schema version is %d instead of %d, %v", version, schemaVersion, err)
+	}
+	if found, _, err := chain.GetTransaction(tx.ID); err != nil || found == nil {
+		t.Errorf("the transaction wasn't indexed: %v", err)
+	}
+	if block, err := chain.GetBlockByHeight(1); err != nil || len(block.Transactions) != 1 {
+		t.Errorf("the heights weren't indexed: %v", err)
+	}
+	if entries, err := chain.GetAddressTransactions(bobHash); err != nil || len(entries) != 1 {
+		t.Errorf("bob has %d transactions in the address index, %v", len(entries), err)
+	}
+	if chain.hasKey(indexedKey) {
+		t.Error("the version of the indexes was kept next to the schema version")
+	}
+
+	// Schema version 1 with indexes of the last version, they are kept as they are
+	if err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(txIndexKey(tx.ID))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	setStoredSchema(t, chain, 1, lastIndexVersion)
+	chain.Database.Close()
+
+	chain = ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if version, err := chain.storedSchemaVersion(); err != nil || version != schemaVersion {
+		t.Errorf("the schema version is %d instead of %d, %v", version, schemaVersion, err)
+	}
+	if chain.hasKey(txIndexKey(tx.ID)) {
+		t.Error("the indexes of the last version were built again")
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice")
+	chain := newTestChain(t, dir, addresses["alice"])
+	defer chain.Database.Close()
+
+	setStoredSchema(t, chain, schemaVersion+1, 0)
+
+	err := chain.migrate()
+	if err == nil || strings.Contains(err.Error(), "upgrade the binary") == false {
+		t.Fatalf("the newer schema gave the error %v", err)
+	}
+	if version, _ := chain.storedSchemaVersion(); version != schemaVersion+1 {
+		t.Errorf("the schema version was changed to %d", version)
+	}
+}
+
+func TestMigrateNetwork(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice")
+	chain := newTestChain(t, dir, addresses["alice"])
+	defer chain.Database.Close()
+
+	// Schema version 2 didn't record the network
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := setSchemaVersion(txn, 2); err != nil {
+			return err
+		}
+		return txn.Delete(networkKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.storedNetwork(); err == nil {
+		t.Fatal("the network is still recorded")
+	}
+
+	if err := chain.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if params, err := chain.storedNetwork(); err != nil || params != &network.Regtest {
+		t.Errorf("the chain was recorded as a %v chain, %v", params, err)
+	}
+}
+
+func TestMigrateAddressIndex(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice", "bob")
+	alice, bob := addresses["alice"], addresses["bob"]
+
+	bobHash, err := wallet.DecodeAddress(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newTestChain(t, dir, alice)
+	tx := NewTransaction(alice, bob, 10, chain)
+	chain.AddBlock([]*Transaction{tx})
+
+	// Schema version 3 kept the list of the transactions of an address under one key
+	oldKey := append(append([]byte{}, addrIndexPrefix...), bobHash...)
+	for _, prefix := range [][]byte{addrIndexPrefix, addrOutputPrefix} {
+		if err := chain.dropIndex(prefix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := setSchemaVersion(txn, 3); err != nil {
+			return err
+		}
+		return txn.Set(oldKey, encodeIndex([]AddressTx{{tx.ID, chain.LastHash, Incoming, 10}}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Database.Close()
+
+	chain = ContinueBlockChain()
+	defer chain.Database.Close()
+
+	if chain.hasKey(oldKey) {
+		t.Error("the list of the address was kept")
+	}
+	if entries, err := chain.GetAddressTransactions(bobHash); err != nil || len(entries) != 1 || entries[0].Amount != 10 {
+		t.Errorf("bob has the entries %v in the address index, %v", entries, err)
+	}
+	if got := balance(t, chain, bob); got != 10 {
+		t.Errorf("bob has %d instead of 10", got)
+	}
+}
+
+func TestMigrateResumes(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "alice")
+	chain := newTestChain(t, dir, addresses["alice"])
+	defer chain.Database.Close()
+
+	defer func(m []migration, version int) {
+		migrations, schemaVersion = m, version
+	}(migrations, schemaVersion)
+
+	runs := make([]int, 3)
+	failed := false
+	step := func(i int) func(*BlockChain) error {
+		return func(*BlockChain) error {
+			runs[i]++
+			if i == 1 && failed == false {
+				failed = true
+				return errors.New("interrupted")
+			}
+			return nil
+		}
+	}
+	migrations = []migration{{"first", step(0)}, {"second", step(1)}, {"third", step(2)}}
+	schemaVersion = len(migrations)
+	setStoredSchema(t, chain, 0, 0)
+
+	if err := chain.migrate(); err == nil {
+		t.Fatal("the interrupted upgrade didn't fail")
+	}
+	if version, _ := chain.storedSchemaVersion(); version != 1 {
+		t.Errorf("the upgrade stopped at schema version %d instead of 1", version)
+	}
+
+	if err := chain.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := chain.storedSchemaVersion(); version != 3 {
+		t.Errorf("the upgrade ended at schema version %d instead of 3", version)
+	}
+	if runs[0] != 1 || runs[1] != 2 || runs[2] != 1 {
+		t.Errorf("the migrations ran %v times", runs)
+	}
+}
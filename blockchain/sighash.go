@@ -0,0 +1,236 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SigHashType selects the parts of a transaction an input signature commits to
+type SigHashType uint8
+
+const (
+	// SigHashLegacy marks the signatures made before hash types, they sign a
+	// gob encoded copy of the transaction which is hashed again for every input
+	SigHashLegacy SigHashType = 0x00
+
+	// SigHashAll signs all the inputs and outputs
+	SigHashAll SigHashType = 0x01
+
+	// SigHashNone signs the inputs but none of the outputs, anyone can change where the coins go
+	SigHashNone SigHashType = 0x02
+
+	// SigHashSingle signs the inputs and only the output with the same index as the input
+	SigHashSingle SigHashType = 0x03
+
+	// SigHashAnyoneCanPay is combined with the other types to sign only the own input,
+	// anyone can add more inputs to the transaction
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashOutputMask = 0x1f
+)
+
+var sigHashNames = map[SigHashType]string{
+	SigHashAll:    "ALL",
+	SigHashNone:   "NONE",
+	SigHashSingle: "SINGLE",
+}
+
+// ParseSigHashType parses a hash type like ALL, SINGLE or NONE|ANYONECANPAY
+func ParseSigHashType(name string) (SigHashType, error) {
+	var hashType SigHashType
+
+	parts := strings.Split(strings.ToUpper(name), "|")
+	if len(parts) == 2 && parts[1] == "ANYONECANPAY" {
+		hashType = SigHashAnyoneCanPay
+	} else if len(parts) != 1 {
+		return 0, fmt.Errorf("unknown signature hash type %q", name)
+	}
+
+	for t, n := range sigHashNames {
+		if n == parts[0] {
+			return hashType | t, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown signature hash type %q, use ALL, NONE or SINGLE with an optional |ANYONECANPAY", name)
+}
+
+// String returns the name of the hash type
+func (h SigHashType) String() string {
+	if h == SigHashLegacy {
+		return "LEGACY"
+	}
+
+	name, ok := sigHashNames[h&sigHashOutputMask]
+	if ok == false || h&^(sigHashOutputMask|SigHashAnyoneCanPay) != 0 {
+		return fmt.Sprintf("UNKNOWN(%#02x)", uint8(h))
+	}
+	if h&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// valid checks the hash type is one signatures can be made with
+func (h SigHashType) valid() bool {
+	_, ok := sigHashNames[h&sigHashOutputMask]
+
+	return ok && h&^(sigHashOutputMask|SigHashAnyoneCanPay) == 0
+}
+
+// sigHashes computes the hashes signed by the inputs of a transaction, the hashes
+// of the parts shared by all the inputs are computed once and cached so signing
+// a transaction takes a time linear in its size instead of quadratic
+type sigHashes struct {
+	tx       *Transaction
+	prevOuts []TxOutput // Outputs spent by the inputs, by input index
+
+	prevouts []byte // Hash of the outpoints of all the inputs
+	amounts  []byte // Hash of the values of all the outputs spent
+	outputs  []byte // Hash of all the outputs
+}
+
+// newSigHashes collects the outputs spent by the inputs of the transaction
+func newSigHashes(tx *Transaction, prevTxs map[string]Transaction) (*sigHashes, error) {
+	s := &sigHashes{tx: tx}
+
+	for _, in := range tx.Inputs {
+		prevTx, ok := prevTxs[hex.EncodeToString(in.ID)]
+		if ok == false {
+			return nil, fmt.Errorf("transaction %x spent by the input was not found", in.ID)
+		}
+		if in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return nil, fmt.Errorf("transaction %x has no output %d", in.ID, in.Out)
+		}
+
+		s.prevOuts = append(s.prevOuts, prevTx.Outputs[in.Out])
+	}
+
+	return s, nil
+}
+
+// SigHash returns the hash the input signs with the hash type, the double sha256 of the
+// prevouts hash, the amounts hash, the outpoint and output spent by the input, the outputs
+// hash and the hash type. The hashes of the prevouts and amounts are zero with ANYONECANPAY,
+// the outputs hash covers all the outputs with ALL, the output at the index of the input
+// with SINGLE and is zero with NONE
+func (s *sigHashes) SigHash(inIndex int, hashType SigHashType) ([]byte, error) {
+	if hashType.valid() == false {
+		return nil, fmt.Errorf("unknown signature hash type %#02x", uint8(hashType))
+	}
+
+	zero := make([]byte, sha256.Size)
+	prevouts, amounts, outputs := zero, zero, zero
+
+	if hashType&SigHashAnyoneCanPay == 0 {
+		prevouts = s.prevoutsHash()
+		amounts = s.amountsHash()
+	}
+
+	switch hashType & sigHashOutputMask {
+	case SigHashAll:
+		outputs = s.outputsHash()
+	case SigHashSingle:
+		if inIndex >= len(s.tx.Outputs) {
+			return nil, fmt.Errorf("input %d is signed with SINGLE but the transaction has %d outputs", inIndex, len(s.tx.Outputs))
+		}
+
+		var buf bytes.Buffer
+		writeSigHashOutput(&buf, &s.tx.Outputs[inIndex])
+		hash := doubleSha256(buf.Bytes())
+		outputs = hash[:]
+	}
+
+	in := &s.tx.Inputs[inIndex]
+
+	var buf bytes.Buffer
+	buf.Write(prevouts)
+	buf.Write(amounts)
+	writeOutpoint(&buf, in)
+	writeSigHashOutput(&buf, &s.prevOuts[inIndex])
+	buf.Write(outputs)
+	buf.WriteByte(byte(hashType))
+
+	hash := doubleSha256(buf.Bytes())
+
+	return hash[:], nil
+}
+
+func (s *sigHashes) prevoutsHash() []byte {
+	if s.prevouts == nil {
+		var buf bytes.Buffer
+		for i := range s.tx.Inputs {
+			writeOutpoint(&buf, &s.tx.Inputs[i])
+		}
+		hash := doubleSha256(buf.Bytes())
+		s.prevouts = hash[:]
+	}
+
+	return s.prevouts
+}
+
+func (s *sigHashes) amountsHash() []byte {
+	if s.amounts == nil {
+		var buf bytes.Buffer
+		for _, out := range s.prevOuts {
+			writeUint64(&buf, uint64(out.Value))
+		}
+		hash := doubleSha256(buf.Bytes())
+		s.amounts = hash[:]
+	}
+
+	return s.amounts
+}
+
+func (s *sigHashes) outputsHash() []byte {
+	if s.outputs == nil {
+		var buf bytes.Buffer
+		for i := range s.tx.Outputs {
+			writeSigHashOutput(&buf, &s.tx.Outputs[i])
+		}
+		hash := doubleSha256(buf.Bytes())
+		s.outputs = hash[:]
+	}
+
+	return s.outputs
+}
+
+// writeOutpoint writes the transaction ID and output index spent by the input
+func writeOutpoint(buf *bytes.Buffer, in *TxInput) {
+	writeSigHashBytes(buf, in.ID)
+	writeUint64(buf, uint64(in.Out))
+}
+
+// writeSigHashOutput writes the value of the output and what it's locked with
+func writeSigHashOutput(buf *bytes.Buffer, out *TxOutput) {
+	writeUint64(buf, uint64(out.Value))
+	writeSigHashBytes(buf, out.PubKeyHash)
+
+	if out.HTLC == nil {
+		buf.WriteByte(0)
+		return
+	}
+
+	buf.WriteByte(1)
+	writeSigHashBytes(buf, out.HTLC.SecretHash)
+	writeSigHashBytes(buf, out.HTLC.ReceiverHash)
+	writeSigHashBytes(buf, out.HTLC.RefundHash)
+	writeUint64(buf, uint64(out.HTLC.LockTime))
+}
+
+// writeSigHashBytes writes the bytes prefixed with their length
+func writeSigHashBytes(buf *bytes.Buffer, b []byte) {
+	writeUint64(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func writeUint64(buf *bytes.Buffer, n uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	buf.Write(b[:])
+}
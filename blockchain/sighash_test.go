@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/sheghun/blockchain/wallet"
+	"testing"
+	"time"
+)
+
+// sigHashTransaction returns a transaction with three inputs and two outputs,
+// the second locked with a hash time lock, and the transactions it spends
+func sigHashTransaction() (*Transaction, map[string]Transaction) {
+	prevTxs := make(map[string]Transaction)
+
+	var inputs []TxInput
+	for i := byte(1); i <= 3; i++ {
+		id := bytes.Repeat([]byte{i}, 32)
+		prevTxs[hex.EncodeToString(id)] = Transaction{
+			ID:      id,
+			Outputs: []TxOutput{{int(i) * 100, bytes.Repeat([]byte{0xa0 + i}, 20), nil}, {int(i) * 1000, bytes.Repeat([]byte{0xb0 + i}, 20), nil}},
+		}
+		inputs = append(inputs, TxInput{id, int(i) % 2, nil, nil, nil, SigHashLegacy, ""})
+	}
+
+	htlc := &HTLC{bytes.Repeat([]byte{0xc1}, 32), bytes.Repeat([]byte{0xc2}, 20), bytes.Repeat([]byte{0xc3}, 20), 1600000000}
+	outputs := []TxOutput{{250, bytes.Repeat([]byte{0xd1}, 20), nil}, {3000, nil, htlc}}
+
+	return &Transaction{nil, inputs, outputs, 0}, prevTxs
+}
+
+var sigHashVectors = []struct {
+	hashType SigHashType
+	input    int
+	hash     string
+}{
+	{SigHashAll, 0, "c6fb385d432d2d99d5f362923f9714546b2ea794aa9b16ef38124dfe17b18826"},
+	{SigHashAll, 2, "be0b7940af178525a18ff10a5c8ee71b9ba36b6c6b233de444729e01f1cf39ba"},
+	{SigHashNone, 0, "5a474b0348c8d0c304feecb9cba67d41200fb3ef67b95d8983c3bf0ecdf6f49d"},
+	{SigHashNone, 2, "b49af09ebad2c625c7fbc711d9d0e8460c7f566610dc694766b002a0476d41c6"},
+	{SigHashSingle, 0, "fce162cf6e6d884008db8349900eddf83586581bbb1f36d1f7defaff187abadd"},
+	{SigHashSingle, 1, "877febce64976e430b79132eec0c313ebe10dd7e9582ed66eabfe101eabd92c9"},
+	{SigHashAll | SigHashAnyoneCanPay, 0, "e2818634536af1e3d43a410a09314544e3c17f05e5ac84a4bcd2dd106fee4d2f"},
+	{SigHashAll | SigHashAnyoneCanPay, 2, "f46a9944d382c3f7437cc62544482f93b9d3b84506a82a6dc6808baab9b79513"},
+	{SigHashNone | SigHashAnyoneCanPay, 0, "8f63663a4eb6964c5825ec12765f72ab919d056cf65a8f5ca0c2084d97b0b9aa"},
+	{SigHashNone | SigHashAnyoneCanPay, 2, "b3f2e5b00399d9c5023d990d5d1d546f3f20983c86815c46cd54f4fcd125b35d"},
+	{SigHashSingle | SigHashAnyoneCanPay, 0, "f55aeeda322828743f047b6755364b6aef42e6801a279b8073d7c30d6aefad33"},
+	{SigHashSingle | SigHashAnyoneCanPay, 1, "9feb070f566f37a083aeb205fbbb4307f435e224c4b44ebf43ae4e37baa2fe3b"},
+}
+
+func TestSigHashVectors(t *testing.T) {
+	tx, prevTxs := sigHashTransaction()
+
+	hashes, err := newSigHashes(tx, prevTxs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range sigHashVectors {
+		hash, err := hashes.SigHash(v.input, v.hashType)
+		if err != nil {
+			t.Fatalf("%s of input %d: %s", v.hashType, v.input, err)
+		}
+		if hex.EncodeToString(hash) != v.hash {
+			t.Errorf("%s of input %d is %x instead of %s", v.hashType, v.input, hash, v.hash)
+		}
+	}
+
+	// The third input has no output at its index to sign
+	for _, hashType := range []SigHashType{SigHashSingle, SigHashSingle | SigHashAnyoneCanPay} {
+		if hash, err := hashes.SigHash(2, hashType); err == nil {
+			t.Errorf("%s of the input past the last output is %x", hashType, hash)
+		}
+	}
+
+	for _, hashType := range []SigHashType{SigHashLegacy, 0x04, 0x41} {
+		if _, err := hashes.SigHash(0, hashType); err == nil {
+			t.Errorf("the input was hashed with the type %s", hashType)
+		}
+	}
+}
+
+// sigHashOf computes the hash of the input with a new cache, so changes to the transaction are seen
+func sigHashOf(t *testing.T, tx *Transaction, prevTxs map[string]Transaction, input int, hashType SigHashType) string {
+	t.Helper()
+
+	hashes, err := newSigHashes(tx, prevTxs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hashes.SigHash(input, hashType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(hash)
+}
+
+func TestSigHashCommitments(t *testing.T) {
+	for _, v := range sigHashVectors {
+		tx, prevTxs := sigHashTransaction()
+		hash := sigHashOf(t, tx, prevTxs, v.input, v.hashType)
+
+		// The output at the index of the input is signed by ALL and SINGLE
+		tx.Outputs[v.input%len(tx.Outputs)].Value++
+		changed := sigHashOf(t, tx, prevTxs, v.input, v.hashType) != hash
+		if want := v.hashType&sigHashOutputMask != SigHashNone; changed != want {
+			t.Errorf("%s of input %d changed %v with its output", v.hashType, v.input, changed)
+		}
+
+		// The other outputs are only signed by ALL
+		tx, prevTxs = sigHashTransaction()
+		tx.Outputs[(v.input+1)%len(tx.Outputs)].HTLC = nil
+		changed = sigHashOf(t, tx, prevTxs, v.input, v.hashType) != hash
+		if want := v.hashType&sigHashOutputMask == SigHashAll; changed != want {
+			t.Errorf("%s of input %d changed %v with another output", v.hashType, v.input, changed)
+		}
+
+		// The other inputs are signed unless ANYONECANPAY
+		tx, prevTxs = sigHashTransaction()
+		other := &tx.Inputs[(v.input+1)%len(tx.Inputs)]
+		other.Out = 1 - other.Out
+		changed = sigHashOf(t, tx, prevTxs, v.input, v.hashType) != hash
+		if want := v.hashType&SigHashAnyoneCanPay == 0; changed != want {
+			t.Errorf("%s of input %d changed %v with another input", v.hashType, v.input, changed)
+		}
+
+		// The own input is always signed
+		tx, prevTxs = sigHashTransaction()
+		tx.Inputs[v.input].Out = 1 - tx.Inputs[v.input].Out
+		if sigHashOf(t, tx, prevTxs, v.input, v.hashType) == hash {
+			t.Errorf("%s of input %d didn't change with the output it spends", v.hashType, v.input)
+		}
+	}
+}
+
+func TestSignSetsHashType(t *testing.T) {
+	dir, cleanup := testDirs(t)
+	defer cleanup()
+
+	addresses := newTestWallets(t, "sender", "receiver")
+	chain := newTestChain(t, dir, addresses["sender"])
+	defer chain.Database.Close()
+
+	// The inputs are built with the legacy type and signed with ALL
+	tx := NewTransaction(addresses["sender"], addresses["receiver"], 10, chain)
+	for i, in := range tx.Inputs {
+		if in.SigHash != SigHashAll {
+			t.Errorf("input %d was signed with %s", i, in.SigHash)
+		}
+	}
+	if chain.VerifyTransaction(tx, time.Now().Unix()) == false {
+		t.Fatal("the transaction doesn't verify")
+	}
+
+	// The same input signed the way it was before hash types
+	w := wallet.CreateWallets().GetWallet(addresses["sender"])
+	prevTxs := chain.prevTransactions(tx)
+	prevOut := prevTxs[hex.EncodeToString(tx.Inputs[0].ID)].Outputs[tx.Inputs[0].Out]
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[0].PubKey = prevOut.PubKeyHash
+	legacyHash, err := txCopy.legacyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Inputs[0].SigHash = SigHashLegacy
+	tx.Inputs[0].Signature = wallet.Sign(w.Curve, w.PrivateKey, legacyHash)
+
+	batch := &wallet.BatchVerifier{}
+	if tx.verifyInputs(prevTxs, time.Now().Unix(), true, batch) == false || batch.Verify() == false {
+		t.Fatal("the legacy signature doesn't verify for a transaction in the chain")
+	}
+
+	// It's refused in new blocks and only accepted in legacy ones
+	if tx.Verify(prevTxs, time.Now().Unix()) || chain.VerifyTransaction(tx, time.Now().Unix()) {
+		t.Error("a new transaction signed without a hash type was accepted")
+	}
+	if err := chain.VerifyTransactions([]*Transaction{tx}, time.Now().Unix(), false); err == nil {
+		t.Error("a block with a new transaction signed without a hash type was accepted")
+	}
+	if err := chain.VerifyTransactions([]*Transaction{tx}, 0, true); err != nil {
+		t.Errorf("a legacy block with a transaction signed without a hash type was refused: %s", err)
+	}
+}
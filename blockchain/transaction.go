@@ -2,51 +2,42 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/sheghun/blockchain/network"
 	"github.com/sheghun/blockchain/wallet"
-	"log"
-	"math/big"
 	"strings"
+	"time"
 )
 
 // Transaction struct
 type Transaction struct {
-	ID      []byte // ID of the transaction
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID         []byte // ID of the transaction
+	Inputs     []TxInput
+	Outputs    []TxOutput
+	ExtraNonce uint64 // Extra data of a coinbase, the miner rolls the block timestamp instead
 }
 
 // SetID derives axnd sets the transaction hash
 func (t *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(t)
-	Handle(err)
-
-	hash = sha256.Sum256(encoded.Bytes())
-	t.ID = hash[:]
-
+	t.ID = t.Hash()
 }
 
-// CoinbaseTx initiates the first transaction in the genesis block
+// CoinbaseTx initiates the first transaction in the genesis block, paying the reward
+// of the active network. Blocks mined later hold no coinbase and pay no reward
 func CoinbaseTx(to, data string) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("Coins to %s", to)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTxOutput(100, to)
+	txin := TxInput{[]byte{}, -1, nil, []byte(data), nil, SigHashLegacy, ""}
+	txout, err := NewTxOutput(network.Active.Reward, to)
+	Handle(err)
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0}
 	tx.SetID()
 
 	return &tx
@@ -54,13 +45,39 @@ func CoinbaseTx(to, data string) *Transaction {
 
 // NewTransaction initiates a new transaction
 func NewTransaction(from, to string, amount int, chain *BlockChain) *Transaction {
-	var inputs []TxInput
 	var outputs []TxOutput
 	var txn *Transaction
 
 	wallets := wallet.CreateWallets()
 
 	w := wallets.GetWallet(from)
+
+	out, err := NewTxOutput(amount, to)
+	Handle(err)
+
+	acc, inputs := fundingInputs(w, amount, chain)
+
+	outputs = append(outputs, *out)
+
+	if acc > amount {
+		change, err := NewTxOutput(acc-amount, from)
+		Handle(err)
+
+		outputs = append(outputs, *change)
+	}
+
+	txn = &Transaction{nil, inputs, outputs, 0}
+	chain.SignTransaction(txn, w)
+	txn.SetID()
+
+	return txn
+}
+
+// fundingInputs collects inputs of the wallet worth at least the amount
+// and returns them with the total value they hold
+func fundingInputs(w wallet.Wallet, amount int, chain *BlockChain) (int, []TxInput) {
+	var inputs []TxInput
+
 	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
 
 	acc, validOutputs := chain.FindSpendableOutputs(pubKeyHash, amount)
@@ -74,30 +91,20 @@ func NewTransaction(from, to string, amount int, chain *BlockChain) *Transaction
 		Handle(err)
 
 		for _, out := range outs {
-			input := TxInput{txid, out, nil, w.PublicKey}
+			input := TxInput{txid, out, nil, w.PublicKey, nil, SigHashLegacy, w.Curve}
 			inputs = append(inputs, input)
 		}
 	}
-	outputs = append(outputs, *NewTxOutput(amount, to))
 
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
-	}
-
-	txn = &Transaction{nil, inputs, outputs}
-	txn.ID = txn.Hash()
-	chain.SignTransaction(txn, w.PrivateKey)
-
-	return txn
+	return acc, inputs
 }
 
+// Hash returns the sha256 hash of the binary encoding of the transaction without its ID
 func (t *Transaction) Hash() []byte {
-	var hash [32]byte
-
-	txCopy := *t
-	txCopy.ID = []byte{}
+	var e encoder
+	t.encode(&e, false)
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(e.buf.Bytes())
 
 	return hash[:]
 }
@@ -107,47 +114,133 @@ func (t *Transaction) IsCoinbase() bool {
 	return len(t.Inputs) == 1 && len(t.Inputs[0].ID) == 0 && t.Inputs[0].Out == -1
 }
 
-func (t *Transaction) Sign(privKey ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+// Sign signs all the inputs with the wallet key committing to the whole transaction
+func (t *Transaction) Sign(w wallet.Wallet, prevTxs map[string]Transaction) {
+	t.SignHashType(w, prevTxs, SigHashAll)
+}
+
+// SignHashType signs all the inputs with the wallet key, the signatures
+// commit to the parts of the transaction selected by the hash type
+func (t *Transaction) SignHashType(w wallet.Wallet, prevTxs map[string]Transaction, hashType SigHashType) {
 	if t.IsCoinbase() {
 		return
 	}
 
-	for _, in := range t.Inputs {
-		if prevTxs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic(errors.New("previous transaction does not exists"))
-		}
-	}
-
-	tCopy := t.TrimmedCopy()
-
-	// Loop through and sign all inputs
-	for inId, in := range tCopy.Inputs {
-		prevTx := prevTxs[hex.EncodeToString(in.ID)]
-		tCopy.Inputs[inId].Signature = nil
-		tCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		tCopy.ID = tCopy.Hash()
-		tCopy.Inputs[inId].PubKey = nil
+	hashes, err := newSigHashes(t, prevTxs)
+	Handle(err)
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, tCopy.ID)
+	for inId := range t.Inputs {
+		err := t.signInput(hashes, inId, w, hashType)
 		Handle(err)
+	}
+}
 
-		signature := append(r.Bytes(), s.Bytes()...)
+// signInput signs the input with the wallet of its public key and records the curve of the key
+func (t *Transaction) signInput(hashes *sigHashes, inId int, w wallet.Wallet, hashType SigHashType) error {
+	hash, err := hashes.SigHash(inId, hashType)
+	if err != nil {
+		return err
+	}
 
-		t.Inputs[inId].Signature = signature
+	t.Inputs[inId].SigHash = hashType
+	t.Inputs[inId].Curve = w.Curve
+	t.Inputs[inId].Signature = wallet.Sign(w.Curve, w.PrivateKey, hash)
 
-	}
+	return nil
 }
 
 // Serialize encodes and returns the byte representation of the transaction
 func (t Transaction) Serialize() []byte {
+	var e encoder
+	t.encode(&e, true)
+
+	return e.buf.Bytes()
+}
+
+// legacyHash hashes the transaction like Hash did before inputs had a hash type,
+// gob writes the fields of the types with the values so the transaction is encoded
+// with types named and laid out like they were then. All the types are local so
+// gob numbers them together the first time, see renumberGobTypes. Transactions
+// with values those types didn't have weren't signed then
+func (t *Transaction) legacyHash() ([]byte, error) {
+	type TxOutput struct {
+		Value      int
+		PubKeyHash []byte
+	}
+	type TxInput struct {
+		ID        []byte
+		Out       int
+		Signature []byte
+		PubKey    []byte
+	}
+	type Transaction struct {
+		ID      []byte
+		Inputs  []TxInput
+		Outputs []TxOutput
+	}
+
+	if t.ExtraNonce != 0 {
+		return nil, errors.New("the transaction has an extra nonce")
+	}
+
+	txCopy := Transaction{}
+	for _, in := range t.Inputs {
+		if in.Preimage != nil {
+			return nil, errors.New("the transaction has an input with a preimage")
+		}
+		txCopy.Inputs = append(txCopy.Inputs, TxInput{in.ID, in.Out, in.Signature, in.PubKey})
+	}
+	for _, out := range t.Outputs {
+		if out.HTLC != nil {
+			return nil, errors.New("the transaction has an output locked with a hash time lock")
+		}
+		txCopy.Outputs = append(txCopy.Outputs, TxOutput{out.Value, out.PubKeyHash})
+	}
+
 	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(txCopy); err != nil {
+		return nil, err
+	}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(t)
-	Handle(err)
+	stream, err := renumberGobTypes(encoded.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(stream)
+
+	return hash[:], nil
+}
+
+// legacyID returns the ID the binary from before hash types gave the transaction,
+// it was set before the inputs were signed
+func (t *Transaction) legacyID() ([]byte, error) {
+	txCopy := *t
+	txCopy.Inputs = make([]TxInput, len(t.Inputs))
+	for i, in := range t.Inputs {
+		in.Signature = nil
+		txCopy.Inputs[i] = in
+	}
+
+	return txCopy.legacyHash()
+}
+
+// checkID checks the ID of the transaction matches its contents, the transactions
+// of legacy blocks have the ID the binary from before hash types gave them
+func (t *Transaction) checkID(legacy bool) error {
+	id := t.Hash()
+	if legacy {
+		var err error
+		if id, err = t.legacyID(); err != nil {
+			return err
+		}
+	}
 
-	return encoded.Bytes()
+	if bytes.Equal(t.ID, id) == false {
+		return fmt.Errorf("the ID %x doesn't match the contents of the transaction", t.ID)
+	}
 
+	return nil
 }
 
 func (t *Transaction) TrimmedCopy() Transaction {
@@ -155,23 +248,37 @@ func (t *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 
 	for _, in := range t.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.PubKey})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.PubKey, nil, SigHashLegacy, in.Curve})
 	}
 
 	for _, out := range t.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash, out.HTLC})
 	}
 
-	txCopy := Transaction{t.ID, inputs, outputs}
+	txCopy := Transaction{t.ID, inputs, outputs, t.ExtraNonce}
 
 	return txCopy
 }
 
-// Verify checks if the transaction input signatures are valid
-func (t *Transaction) Verify(prevTxs map[string]Transaction) bool {
+// Verify checks if the input signatures of a new transaction are valid, the lock
+// times of the outputs spent are checked at the time of the block holding it
+func (t *Transaction) Verify(prevTxs map[string]Transaction, blockTime int64) bool {
+	batch := &wallet.BatchVerifier{}
+
+	return t.verifyInputs(prevTxs, blockTime, false, batch) && batch.Verify()
+}
+
+// verifyInputs checks the inputs satisfy the outputs they spend and adds their
+// signatures to the batch to be verified with it, signatures made before hash
+// types are only accepted with legacy for transactions already in the chain
+func (t *Transaction) verifyInputs(prevTxs map[string]Transaction, blockTime int64, legacy bool, batch *wallet.BatchVerifier) bool {
 	if t.IsCoinbase() {
 		return true
 	}
+	// Only a coinbase can have an extra nonce
+	if t.ExtraNonce != 0 {
+		return false
+	}
 
 	for _, in := range t.Inputs {
 		if prevTxs[hex.EncodeToString(in.ID)].ID == nil {
@@ -180,34 +287,55 @@ func (t *Transaction) Verify(prevTxs map[string]Transaction) bool {
 	}
 
 	tCopy := t.TrimmedCopy()
-	curve := elliptic.P256()
+	var hashes *sigHashes
 
-	// Loop through and sign all inputs
-	for inId, in := range tCopy.Inputs {
+	// Loop through and verify all inputs
+	for inId, in := range t.Inputs {
 		prevTx := prevTxs[hex.EncodeToString(in.ID)]
-		tCopy.Inputs[inId].Signature = nil
-		tCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		tCopy.ID = tCopy.Hash()
-		tCopy.Inputs[inId].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
+		if in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return false
+		}
 
-		// Create a new public key
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+		// The input has to satisfy the conditions the output was locked with
+		prevOut := prevTx.Outputs[in.Out]
+		if prevOut.CanBeUnlocked(&in, blockTime) == false {
+			return false
+		}
 
-		// return verification result either true/false
-		return ecdsa.Verify(&rawPubKey, tCopy.ID, &r, &s)
+		var hash []byte
+		if in.SigHash == SigHashLegacy {
+			if legacy == false {
+				return false
+			}
+
+			// Signed before hash types, the previous output is swapped into a copy of the transaction
+			tCopy.Inputs[inId].Signature = nil
+			tCopy.Inputs[inId].PubKey = prevOut.PubKeyHash
+			legacyHash, err := tCopy.legacyHash()
+			if err != nil {
+				return false
+			}
+			tCopy.Inputs[inId].PubKey = nil
+
+			hash = legacyHash
+		} else {
+			var err error
+			if hashes == nil {
+				if hashes, err = newSigHashes(t, prevTxs); err != nil {
+					return false
+				}
+			}
+
+			if hash, err = hashes.SigHash(inId, in.SigHash); err != nil {
+				return false
+			}
+		}
 
+		curve, err := in.curve()
+		if err != nil {
+			return false
+		}
+		batch.Add(curve, in.PubKey, hash, in.Signature)
 	}
 
 	return true
@@ -218,7 +346,9 @@ func (t *Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("------ Transaction %x:", t.ID))
-
+	if t.ExtraNonce != 0 {
+		lines = append(lines, fmt.Sprintf("		ExtraNonce:	%d", t.ExtraNonce))
+	}
 
 	// Write out inputs strings
 	for i, input := range t.Inputs {
@@ -227,6 +357,15 @@ func (t *Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("			Out:		%d", input.Out))
 		lines = append(lines, fmt.Sprintf("			Signature:	%x", input.Signature))
 		lines = append(lines, fmt.Sprintf("			PubKey:		%x", input.PubKey))
+		if input.Preimage != nil {
+			lines = append(lines, fmt.Sprintf("			Preimage:	%x", input.Preimage))
+		}
+		if input.SigHash != SigHashLegacy {
+			lines = append(lines, fmt.Sprintf("			SigHash:	%s", input.SigHash))
+		}
+		if input.Curve != "" {
+			lines = append(lines, fmt.Sprintf("			Curve:		%s", input.Curve))
+		}
 
 	}
 
@@ -234,6 +373,12 @@ func (t *Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("		Output %d:", i))
 		lines = append(lines, fmt.Sprintf("			Value:		%d", output.Value))
 		lines = append(lines, fmt.Sprintf("			Script: 	%x", output.PubKeyHash))
+		if output.HTLC != nil {
+			lines = append(lines, fmt.Sprintf("			SecretHash:	%x", output.HTLC.SecretHash))
+			lines = append(lines, fmt.Sprintf("			Receiver:	%x", output.HTLC.ReceiverHash))
+			lines = append(lines, fmt.Sprintf("			Refund:		%x", output.HTLC.RefundHash))
+			lines = append(lines, fmt.Sprintf("			LockTime:	%s", time.Unix(output.HTLC.LockTime, 0)))
+		}
 	}
 
 	return strings.Join(lines, "\n")
@@ -9,6 +9,7 @@ import (
 type TxOutput struct {
 	Value      int
 	PubKeyHash []byte
+	HTLC       *HTLC // Hash time-locked contract terms, nil for plain outputs
 }
 
 // Transaction input struct
@@ -17,14 +18,30 @@ type TxInput struct {
 	Out       int
 	Signature []byte
 	PubKey    []byte
+	Preimage  []byte       // Secret revealed when redeeming a hash time-locked output
+	SigHash   SigHashType  // Parts of the transaction the signature commits to
+	Curve     wallet.Curve // Signature scheme of the public key, empty on inputs signed before it was recorded
 }
 
 // NewTxOutput creates and returns a new utxo locked to the supplied address
-func NewTxOutput(value int, addr string) *TxOutput {
-	txo := &TxOutput{value, nil}
-	txo.Lock([]byte(addr))
+// it fails when the address is not valid rather than burning the value
+func NewTxOutput(value int, addr string) (*TxOutput, error) {
+	txo := &TxOutput{value, nil, nil}
+	if err := txo.Lock([]byte(addr)); err != nil {
+		return nil, err
+	}
 
-	return txo
+	return txo, nil
+}
+
+// curve returns the curve of the public key of the input, the one of the inputs
+// signed before curves were recorded is told by the length of the key
+func (in *TxInput) curve() (wallet.Curve, error) {
+	if in.Curve != "" {
+		return in.Curve, nil
+	}
+
+	return wallet.PublicKeyCurve(in.PubKey)
 }
 
 // UsesKey check if the transaction input uses this key
@@ -34,12 +51,25 @@ func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
 
-// Lock locks the transactions output
+// Lock locks the transactions output, the output is left
+// unchanged when the address is not valid
 func (out *TxOutput) Lock(address []byte) error {
-	pubKeyHash, _, err := wallet.Base58Decode(address)
+	pubKeyHash, err := wallet.DecodeAddress(string(address))
+	if err != nil {
+		return err
+	}
 	out.PubKeyHash = pubKeyHash
 
-	return err
+	return nil
+}
+
+// CanBeUnlocked checks if the input satisfies the conditions the output is locked with
+func (out *TxOutput) CanBeUnlocked(in *TxInput, now int64) bool {
+	if out.HTLC != nil {
+		return out.HTLC.Unlocks(in, now)
+	}
+
+	return in.UsesKey(out.PubKeyHash)
 }
 
 // IsLockedWithKey checks if the utxo is locked with key
@@ -1,29 +1,74 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/sheghun/blockchain/blockchain"
+	"github.com/sheghun/blockchain/explorer"
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/rpc"
 	"github.com/sheghun/blockchain/wallet"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // Cmd struct for handling command line related tasks
 type Cmd struct {
 	blockchain *blockchain.BlockChain
+	txIndex    *bool // -txindex when it's given, the chain keeps its setting otherwise
 }
 
 // validateAddress validates the supplied address and exit
 // the runtime
 func (cli Cmd) validateAddress(address string) {
-	if wallet.ValidateAddress(address) == false {
-		log.Printf("\n\n\n\n ---------- Address is not valid -------------- \n\n\n\n")
+	cli.decodeAddress(address)
+}
+
+// decodeAddress returns the public key hash of the address
+// and exits the runtime when the address is not valid
+func (cli Cmd) decodeAddress(address string) []byte {
+	pubKeyHash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		log.Printf("\n\n\n\n ---------- %s -------------- \n\n\n\n", err)
+		runtime.Goexit()
+	}
+
+	return pubKeyHash
+}
+
+// continueBlockChain opens the chain and applies -txindex when it was given
+func (cli *Cmd) continueBlockChain() *blockchain.BlockChain {
+	chain := blockchain.ContinueBlockChain()
+	cli.applyTxIndex(chain)
+
+	return chain
+}
+
+// applyTxIndex turns the transaction index of the chain on or off when -txindex was given
+func (cli *Cmd) applyTxIndex(chain *blockchain.BlockChain) {
+	if cli.txIndex == nil {
 		return
 	}
+
+	err := chain.SetTxIndex(*cli.txIndex)
+	blockchain.Handle(err)
 }
 
 // validate checks the cmd supplied arguments
@@ -35,67 +80,248 @@ func (cli *Cmd) validate() error {
 	return nil
 }
 
+// showBlock prints the block and its transactions
+func (cli *Cmd) showBlock(chain *blockchain.BlockChain, block *blockchain.Block) {
+	fmt.Printf("Previous Hash: %x\n", block.PrevHash)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	if block.Timestamp != 0 {
+		fmt.Printf("Time: %s\n", time.Unix(block.Timestamp, 0))
+	}
+
+	if block.Consensus != "" {
+		fmt.Printf("Consensus: %s\n", block.Consensus)
+	}
+	if block.Network != "" {
+		fmt.Printf("Network: %s\n", block.Network)
+	}
+
+	err := chain.VerifyBlock(block)
+	fmt.Printf("Seal (%s): %s\n", chain.Engine.Name(), strconv.FormatBool(err == nil))
+	if err != nil {
+		fmt.Printf("Seal error: %s\n", err)
+	}
+
+	err = chain.VerifyTransactions(block.Transactions, block.Timestamp, block.Legacy())
+	fmt.Printf("Transactions: %s\n", strconv.FormatBool(err == nil))
+	if err != nil {
+		fmt.Printf("Transactions error: %s\n", err)
+	}
+	fmt.Println()
+
+	for _, tx := range block.Transactions {
+		fmt.Println(tx.String())
+	}
+	fmt.Println()
+}
+
+// printJSON prints the value as indented JSON
+func (cli *Cmd) printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	blockchain.Handle(err)
+
+	fmt.Println(string(data))
+}
+
 // printChain iterates and prints all the blocks in the database
-func (cli *Cmd) printChain() {
-	chain := blockchain.ContinueBlockChain()
+// from the last block, or from the height from up to the height to when set
+// the blocks are printed as a JSON array when asJSON is set
+func (cli *Cmd) printChain(from, to int, asJSON bool) {
+	chain := cli.continueBlockChain()
 	defer chain.Database.Close()
 
-	iter := chain.Iterator()
+	blocks := []blockchain.JSONBlock{}
+	show := func(height int, block *blockchain.Block) {
+		if asJSON {
+			b, err := chain.BlockJSON(block)
+			blockchain.Handle(err)
+			blocks = append(blocks, b)
+			return
+		}
 
-	for {
-		block := iter.Next()
+		if height >= 0 {
+			fmt.Printf("Height: %d\n", height)
+		}
+		cli.showBlock(chain, block)
+	}
 
-		fmt.Printf("Previous Hash: %x\n", block.PrevHash)
-		fmt.Printf("Hash: %x\n", block.Hash)
+	if from >= 0 || to >= 0 {
+		if from < 0 {
+			from = 0
+		}
+		if to < 0 {
+			to = chain.GetBestHeight()
+		}
 
-		p := blockchain.NewProof(block)
-		fmt.Printf("Proof of work: %s\n\n", strconv.FormatBool(p.Validate()))
+		iter := chain.ForwardIterator(from)
+		for height := from; height <= to; height++ {
+			block := iter.Next()
+			if block == nil {
+				break // Past the last block
+			}
 
-		for _, tx := range block.Transactions {
-			fmt.Println(tx.String())
+			show(height, block)
 		}
-		fmt.Println()
+
+		if asJSON {
+			cli.printJSON(blocks)
+		}
+		return
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		show(-1, block)
 
 		// Check if at last block
 		if len(block.PrevHash) == 0 {
 			break // Exit functions
 		}
 	}
+
+	if asJSON {
+		cli.printJSON(blocks)
+	}
+}
+
+// printBlock prints the block at the height
+func (cli *Cmd) printBlock(height int) {
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	block, err := chain.GetBlockByHeight(height)
+	blockchain.Handle(err)
+
+	fmt.Printf("Height: %d\n", height)
+	cli.showBlock(chain, block)
 }
 
 // createBlockchain creates a new blockchain for an address
-func (cli *Cmd) createBlockchain(address string) {
+// sealed by the named consensus engine, signers are the comma
+// separated addresses of the first proof of authority signers
+func (cli *Cmd) createBlockchain(address, consensus, signers string) {
 	cli.validateAddress(address)
 
-	chain := blockchain.InitBlockChain(address)
+	genesis := blockchain.DefaultGenesis(address, consensus)
+	if signers != "" {
+		for _, signer := range strings.Split(signers, ",") {
+			genesis.Signers = append(genesis.Signers, strings.TrimSpace(signer))
+		}
+	}
+
+	cli.createBlockchainGenesis(genesis)
+}
+
+// createBlockchainFile creates a new blockchain from a genesis file,
+// the address gets the block reward when the file allocates no coins
+func (cli *Cmd) createBlockchainFile(file, address string) {
+	genesis, err := blockchain.LoadGenesis(file)
+	blockchain.Handle(err)
+
+	if len(genesis.Alloc) == 0 && address != "" {
+		cli.validateAddress(address)
+		genesis.Alloc = []blockchain.GenesisAlloc{{Address: address, Amount: genesis.Reward}}
+	}
+
+	cli.createBlockchainGenesis(genesis)
+}
+
+// createBlockchainGenesis creates a new blockchain with the genesis block
+func (cli *Cmd) createBlockchainGenesis(genesis *blockchain.GenesisConfig) {
+	chain := blockchain.InitBlockChainGenesis(genesis)
 	defer chain.Database.Close()
+	cli.applyTxIndex(chain)
 
-	fmt.Printf("\n\n\n\n ----- Blockchain was created and 100 coins was transfered to %s in coinbase transaction \n\n\n\n", address)
+	fmt.Printf("\n\n\n\n ----- Blockchain was created with genesis block %x ----- \n", chain.LastHash)
+	for _, alloc := range genesis.Alloc {
+		fmt.Printf(" %d coins were transfered to %s in the coinbase transaction\n", alloc.Amount, alloc.Address)
+	}
+	fmt.Printf("\n\n\n\n")
 }
 
-func (cli *Cmd) getBalance(address string) {
-	cli.validateAddress(address)
+// poaEngine returns the proof of authority engine of the chain
+// or exits when the chain uses another consensus engine
+func (cli *Cmd) poaEngine(chain *blockchain.BlockChain) *blockchain.PoaEngine {
+	engine, ok := chain.Engine.(*blockchain.PoaEngine)
+	if !ok {
+		chain.Database.Close()
+		log.Printf("\n\n\n\n ---------- The chain uses %s, not proof of authority -------------- \n\n\n\n", chain.Engine.Name())
+		runtime.Goexit()
+	}
 
-	chain := blockchain.ContinueBlockChain()
+	return engine
+}
+
+// listSigners prints the authorities allowed to seal the next blocks in turn
+func (cli *Cmd) listSigners() {
+	chain := cli.continueBlockChain()
 	defer chain.Database.Close()
 
-	balance := 0
-	pubKeyHash, _, _ := wallet.Base58Decode([]byte(address))
+	signers, err := cli.poaEngine(chain).Signers(chain, chain.LastHash)
+	blockchain.Handle(err)
 
-	UTXOs := chain.FindUTXO(pubKeyHash)
+	next := chain.GetBestHeight() + 1
+	for i := range signers {
+		// Signers take turns by the block height
+		height := next + i
+		fmt.Printf("Block %d: %s\n", height, wallet.PubKeyHashToAddress(signers[height%len(signers)]))
+	}
+}
+
+// voteSigner seals a block voting to add or remove the signer
+func (cli *Cmd) voteSigner(address string, authorize bool) {
+	pubKeyHash := cli.decodeAddress(address)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	cli.poaEngine(chain).Vote = &blockchain.SignerVote{Signer: pubKeyHash, Authorize: authorize}
+
+	cli.mine(chain)
+
+	action := "add"
+	if authorize == false {
+		action = "remove"
+	}
+	fmt.Printf("\n\n\n\n -------- Voted to %s %s --------- \n\n\n\n", action, address)
+}
 
-	for _, out := range UTXOs {
-		balance += out.Value
+func (cli *Cmd) getBalance(address string, asJSON bool) {
+	pubKeyHash := cli.decodeAddress(address)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	balance := chain.GetBalance(pubKeyHash)
+
+	if asJSON {
+		cli.printJSON(struct {
+			Address string `json:"address"`
+			Balance int    `json:"balance"`
+		}{address, balance})
+		return
 	}
 
 	fmt.Printf("\n\n\n\n ------------ Balance of %s: %d ----------------- \n\n\n\n", address, balance)
 }
 
 // listAddresses print out all the list to the cmd
-func (cli *Cmd) listAddresses() {
+// or a JSON array of the addresses when asJSON is set
+func (cli *Cmd) listAddresses(asJSON bool) {
 	wallets := wallet.CreateWallets()
 	addresses := wallets.GetAllAddresses()
 
+	if asJSON {
+		if addresses == nil {
+			addresses = []string{}
+		}
+		sort.Strings(addresses)
+		cli.printJSON(addresses)
+		return
+	}
+
 	if len(addresses) == 0 {
 		fmt.Println()
 		fmt.Println()
@@ -111,9 +337,17 @@ func (cli *Cmd) listAddresses() {
 	}
 }
 
-func (cli *Cmd) createWallet() {
+// createWallet creates a wallet with a key on the named curve
+// and an address in the named format
+func (cli *Cmd) createWallet(curveName, formatName string) {
+	curve, err := wallet.ParseCurve(curveName)
+	blockchain.Handle(err)
+
+	format, err := wallet.ParseAddressFormat(formatName)
+	blockchain.Handle(err)
+
 	wallets := wallet.CreateWallets()
-	address := wallets.AddWallet()
+	address := wallets.AddWallet(curve, format)
 
 	wallets.SaveFile()
 
@@ -125,23 +359,583 @@ func (cli *Cmd) send(from, to string, amount int) {
 	cli.validateAddress(to)
 	cli.validateAddress(from)
 
-	chain := blockchain.ContinueBlockChain()
+	chain := cli.continueBlockChain()
 	defer chain.Database.Close()
 
 	tx := blockchain.NewTransaction(from, to, amount, chain)
-	chain.AddBlock([]*blockchain.Transaction{tx})
+	cli.mine(chain, tx)
 	fmt.Printf("\n\n\n\n -------- Transactions successful --------- \n\n\n\n")
 }
 
+// getTransaction prints the transaction and the block it was mined in
+func (cli *Cmd) getTransaction(id string) {
+	txID, err := hex.DecodeString(id)
+	blockchain.Handle(err)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	tx, blockHash, err := chain.GetTransaction(txID)
+	blockchain.Handle(err)
+
+	fmt.Printf("Block: %x\n", blockHash)
+	fmt.Println(tx.String())
+	fmt.Println()
+}
+
+// decodeTx prints the hex serialized transaction as JSON, the values spent by
+// its inputs and its fee are added when the chain holds the outputs spent
+func (cli *Cmd) decodeTx(raw string) {
+	tx := cli.parseRawTx(raw)
+
+	if blockchain.DBExits() == false {
+		cli.printJSON(tx)
+		return
+	}
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	cli.printJSON(chain.TransactionJSON(tx))
+}
+
+// parseRawTx decodes the hex serialized transaction
+func (cli *Cmd) parseRawTx(raw string) *blockchain.Transaction {
+	data, err := hex.DecodeString(strings.TrimSpace(raw))
+	blockchain.Handle(err)
+
+	tx, err := blockchain.DeserializeTransaction(data)
+	blockchain.Handle(err)
+
+	return tx
+}
+
+// createRawTx prints an unsigned transaction spending the comma separated txid:vout
+// outpoints to the comma separated address:amount outputs, it's signed with signRawTx
+func (cli *Cmd) createRawTx(inputList, outputList string) {
+	inputs, err := parseRawInputs(inputList)
+	blockchain.Handle(err)
+
+	outputs, err := parseRawOutputs(outputList)
+	blockchain.Handle(err)
+
+	tx, err := blockchain.NewRawTransaction(inputs, outputs)
+	blockchain.Handle(err)
+
+	fmt.Printf("%x\n", tx.Serialize())
+}
+
+// signRawTx signs the inputs of the transaction spending outputs of our wallets
+// and prints the transaction, the other inputs are left for their owners to sign
+func (cli *Cmd) signRawTx(raw, hashTypeName string) {
+	tx := cli.parseRawTx(raw)
+
+	hashType, err := blockchain.ParseSigHashType(hashTypeName)
+	blockchain.Handle(err)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	signed, err := chain.SignRawTransaction(tx, hashType)
+	blockchain.Handle(err)
+
+	if signed == 0 {
+		log.Printf("\n\n\n\n ---------- None of the inputs spend outputs of our wallets -------------- \n\n\n\n")
+		runtime.Goexit()
+	}
+	if signed < len(tx.Inputs) {
+		log.Printf("Signed %d of the %d inputs, the others have to be signed by their owners", signed, len(tx.Inputs))
+	}
+
+	fmt.Printf("%x\n", tx.Serialize())
+}
+
+// decodeRawTx prints the fields of the hex serialized transaction, its fee and
+// whether it can be sent are added when the chain holds the outputs it spends
+func (cli *Cmd) decodeRawTx(raw string) {
+	tx := cli.parseRawTx(raw)
+
+	fmt.Println(tx.String())
+
+	if blockchain.DBExits() == false {
+		return
+	}
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	err := chain.CheckRawTransaction(tx)
+	fmt.Printf("Valid: %s\n", strconv.FormatBool(err == nil))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+	}
+}
+
+// sendRawTx checks the signed hex serialized transaction and mines it in a block
+func (cli *Cmd) sendRawTx(raw string) {
+	tx := cli.parseRawTx(raw)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	err := chain.CheckRawTransaction(tx)
+	blockchain.Handle(err)
+
+	cli.mine(chain, tx)
+	fmt.Printf("\n\n\n\n -------- Transaction %x sent --------- \n\n\n\n", tx.ID)
+}
+
+// history prints a page of the transactions touching the address, newest first
+func (cli *Cmd) history(address string, page, pageSize int) {
+	pubKeyHash := cli.decodeAddress(address)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	entries, total, err := chain.GetAddressHistory(pubKeyHash, (page-1)*pageSize, pageSize)
+	blockchain.Handle(err)
+
+	pages := (total + pageSize - 1) / pageSize
+	fmt.Printf("\n History of %s, page %d of %d (%d transactions)\n\n", address, page, pages, total)
+
+	for _, entry := range entries {
+		fmt.Printf(" %x  %-8s  %d\n", entry.TxID, entry.Direction, entry.Amount)
+	}
+	fmt.Println()
+}
+
+// reindex rebuilds the transaction and address indexes from the blocks
+func (cli *Cmd) reindex() {
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	err := chain.Reindex()
+	blockchain.Handle(err)
+
+	fmt.Printf("\n\n\n\n -------- Indexes rebuilt --------- \n\n\n\n")
+}
+
+// progressInterval is the number of blocks between the progress lines of exports and imports
+const progressInterval = 100
+
+// exportChain writes the blocks of the chain to a bootstrap file
+func (cli *Cmd) exportChain(file string) {
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	f, err := os.Create(file)
+	blockchain.Handle(err)
+
+	err = chain.ExportChain(f, func(height, count int) {
+		if (height+1)%progressInterval == 0 || height+1 == count {
+			fmt.Printf("Exported %d of %d blocks\n", height+1, count)
+		}
+	})
+	blockchain.Handle(err)
+
+	err = f.Close()
+	blockchain.Handle(err)
+
+	fmt.Printf("\n\n\n\n -------- Chain exported to %s --------- \n\n\n\n", file)
+}
+
+// importChain checks and connects the blocks of a bootstrap file to the chain,
+// the chain is created when there's none and the blocks it has are skipped
+func (cli *Cmd) importChain(file string) {
+	f, err := os.Open(file)
+	blockchain.Handle(err)
+	defer f.Close()
+
+	imported, err := blockchain.ImportChain(f, func(height, count int, connected bool) {
+		if (height+1)%progressInterval == 0 || height+1 == count {
+			fmt.Printf("Checked %d of %d blocks\n", height+1, count)
+		}
+	})
+	if err != nil {
+		log.Printf("\n\n\n\n ---------- %d blocks imported before: %s -------------- \n\n\n\n", imported, err)
+		runtime.Goexit()
+	}
+
+	fmt.Printf("\n\n\n\n -------- %d blocks imported --------- \n\n\n\n", imported)
+
+	// The blocks were indexed with the setting of the chain
+	if cli.txIndex != nil {
+		cli.continueBlockChain().Database.Close()
+	}
+}
+
+// backup writes the database and the wallets file when includeWallet is set to a
+// backup file, a running node holds the database so it's backed up with its backup method
+func (cli *Cmd) backup(file string, includeWallet bool) {
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	var wallets []byte
+	if includeWallet {
+		var err error
+		wallets, err = wallet.ReadFile()
+		blockchain.Handle(err)
+		if wallets == nil {
+			log.Printf("No wallets file in %s, the backup holds the blockchain only", wallet.DataDir)
+		}
+	}
+
+	// Only the owner can read it as it can hold the private keys
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	blockchain.Handle(err)
+
+	backup, err := chain.WriteBackup(f, wallets)
+	blockchain.Handle(err)
+
+	err = f.Close()
+	blockchain.Handle(err)
+
+	fmt.Printf("\n\n\n\n -------- Backed up %d blocks up to %x to %s --------- \n\n\n\n", backup.Height+1, backup.LastHash, file)
+}
+
+// restore checks a backup file and restores its database and wallets file to the data directory
+func (cli *Cmd) restore(file string) {
+	f, err := os.Open(file)
+	blockchain.Handle(err)
+	defer f.Close()
+
+	backup, err := blockchain.RestoreBackup(f)
+	if err != nil {
+		log.Printf("\n\n\n\n ---------- Backup not restored: %s -------------- \n\n\n\n", err)
+		runtime.Goexit()
+	}
+
+	if backup.Wallets != nil {
+		if err := wallet.RestoreFile(backup.Wallets); err != nil {
+			log.Printf("\n\n\n\n ---------- Blockchain restored without the wallets: %s -------------- \n\n\n\n", err)
+			runtime.Goexit()
+		}
+	}
+
+	// The restored chain keeps the setting of the backup unless the flag is given
+	if cli.txIndex != nil {
+		cli.continueBlockChain().Database.Close()
+	}
+
+	fmt.Printf("\n\n\n\n -------- Restored %d blocks up to %x --------- \n\n\n\n", backup.Height+1, backup.LastHash)
+}
+
+// createHTLC locks the amount in a hash time-locked contract to the receiver
+// a new secret is generated when no secret hash is supplied
+func (cli *Cmd) createHTLC(from, to string, amount int, secretHash string, lockTime int64) {
+	cli.validateAddress(to)
+	cli.validateAddress(from)
+
+	var secret []byte
+	var hash []byte
+
+	if secretHash == "" {
+		secret = make([]byte, 32)
+		_, err := rand.Read(secret)
+		blockchain.Handle(err)
+
+		h := sha256.Sum256(secret)
+		hash = h[:]
+	} else {
+		h, err := hex.DecodeString(secretHash)
+		blockchain.Handle(err)
+		hash = h
+	}
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	expiry := time.Now().Unix() + lockTime
+
+	tx := blockchain.NewHTLCTransaction(from, to, amount, hash, expiry, chain)
+	cli.mine(chain, tx)
+
+	fmt.Printf("\n\n\n\n -------- Contract created --------- \n\n")
+	fmt.Printf(" Contract: %x:0\n", tx.ID)
+	fmt.Printf(" Secret hash: %x\n", hash)
+	if secret != nil {
+		fmt.Printf(" Secret: %x (keep it private until you claim)\n", secret)
+	}
+	fmt.Printf(" Refundable after: %s\n\n\n\n", time.Unix(expiry, 0))
+}
+
+// redeemHTLC claims the contract output with the preimage
+// or refunds it to the sender when no preimage is supplied
+func (cli *Cmd) redeemHTLC(txID string, out int, preimage string, to string) {
+	if to != "" {
+		cli.validateAddress(to)
+	}
+
+	id, err := hex.DecodeString(txID)
+	blockchain.Handle(err)
+
+	secret, err := hex.DecodeString(preimage)
+	blockchain.Handle(err)
+
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	tx := blockchain.NewHTLCRedeem(id, out, secret, to, chain)
+	cli.mine(chain, tx)
+
+	if len(secret) > 0 {
+		fmt.Printf("\n\n\n\n -------- Contract claimed in transaction %x --------- \n\n\n\n", tx.ID)
+		return
+	}
+	fmt.Printf("\n\n\n\n -------- Contract refunded in transaction %x --------- \n\n\n\n", tx.ID)
+}
+
+// startNode serves the blockchain and wallets over JSON-RPC until interrupted
+// the REST explorer is served alongside when restAddr is not empty
+func (cli *Cmd) startNode(addr, tokenFile, restAddr string) {
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	server, err := rpc.NewServer(chain, addr, tokenFile)
+	blockchain.Handle(err)
+
+	if restAddr != "" {
+		go func() {
+			fmt.Printf("\n\n ------ REST explorer listening on %s ------- \n\n", restAddr)
+			err := http.ListenAndServe(restAddr, explorer.New(chain, server.Locker()))
+			blockchain.Handle(err)
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	if tokenFile == "" {
+		tokenFile = rpc.CookieFile(blockchain.DataDir)
+	}
+	fmt.Printf("\n\n ------ JSON-RPC listening on %s, token in %s ------- \n\n", addr, tokenFile)
+
+	err = server.ListenAndServe()
+	blockchain.Handle(err)
+}
+
+// startExplorer serves the read-only REST explorer until interrupted
+func (cli *Cmd) startExplorer(addr string) {
+	chain := cli.continueBlockChain()
+	defer chain.Database.Close()
+
+	server := &http.Server{Addr: addr, Handler: explorer.New(chain, &sync.Mutex{})}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	fmt.Printf("\n\n ------ REST explorer listening on %s ------- \n\n", addr)
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		blockchain.Handle(err)
+	}
+}
+
+// rpcCall calls a method on a running node and prints the result
+// numeric arguments are sent as numbers, true and false as booleans and everything else as strings
+func (cli *Cmd) rpcCall(addr, tokenFile, method string, args []string) {
+	if tokenFile == "" {
+		tokenFile = rpc.CookieFile(blockchain.DataDir)
+	}
+
+	token, err := rpc.ReadToken(tokenFile)
+	blockchain.Handle(err)
+
+	var params []interface{}
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			params = append(params, n)
+			continue
+		}
+		if arg == "true" || arg == "false" {
+			params = append(params, arg == "true")
+			continue
+		}
+		params = append(params, arg)
+	}
+
+	result, err := rpc.NewClient(addr, token).Call(method, params...)
+	if err != nil {
+		log.Printf("%s failed: %s", method, err)
+		return
+	}
+
+	var out bytes.Buffer
+	blockchain.Handle(json.Indent(&out, result, "", "  "))
+	fmt.Println(out.String())
+}
+
+// mine adds a block with the transactions to the chain and prints the mining statistics
+func (cli *Cmd) mine(chain *blockchain.BlockChain, txs ...*blockchain.Transaction) {
+	block, stats, err := chain.AddBlockContext(context.Background(), txs)
+	blockchain.Handle(err)
+
+	if stats.Attempts == 0 {
+		fmt.Printf("Block sealed: %x\n", block.Hash)
+		return
+	}
+	fmt.Printf("Block mined: %s\n", stats)
+}
+
+// benchmarkMining mines blocks with random data with the hash function for
+// every difficulty and worker count and prints the hash rates
+func (cli *Cmd) benchmarkMining(hashName string, difficulties, workerCounts []int, rounds int) {
+	hash, err := blockchain.GetPowHash(hashName)
+	blockchain.Handle(err)
+
+	fmt.Printf("\n Hash: %s\n", hash.Name)
+	fmt.Printf("\n %-10s  %-7s  %-14s  %-12s  %s\n", "Difficulty", "Workers", "Hashes", "Avg time", "Hash rate")
+
+	for _, difficulty := range difficulties {
+		for _, workers := range workerCounts {
+			stats, err := blockchain.BenchmarkMining(hash, difficulty, workers, rounds)
+			blockchain.Handle(err)
+
+			avg := stats.Duration / time.Duration(rounds)
+			fmt.Printf(" %-10d  %-7d  %-14d  %-12s  %.0f H/s\n", difficulty, workers, stats.Attempts, avg.Round(time.Microsecond), stats.HashRate())
+		}
+	}
+	fmt.Println()
+}
+
+// benchmarkSignatures verifies signatures made with every curve one at a time
+// and in a batch and prints the average time per signature
+func (cli *Cmd) benchmarkSignatures(counts []int) {
+	fmt.Printf("\n %-10s  %-10s  %-14s  %-14s  %s\n", "Curve", "Signatures", "One at a time", "Batch", "Speedup")
+
+	for _, curve := range []wallet.Curve{wallet.P256, wallet.Secp256k1, wallet.Schnorr} {
+		for _, count := range counts {
+			single, batch, err := wallet.BenchmarkVerify(curve, count)
+			blockchain.Handle(err)
+
+			perSingle := single / time.Duration(count)
+			perBatch := batch / time.Duration(count)
+			fmt.Printf(" %-10s  %-10d  %-14s  %-14s  %.2fx\n", curve, count, perSingle.Round(time.Microsecond/10), perBatch.Round(time.Microsecond/10), float64(single)/float64(batch))
+		}
+	}
+	fmt.Println()
+}
+
+// parseInts parses a comma separated list of positive numbers
+func parseInts(list string) ([]int, error) {
+	var nums []int
+
+	for _, field := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q is not a positive number", field)
+		}
+		nums = append(nums, n)
+	}
+
+	return nums, nil
+}
+
+// parseRawInputs parses comma separated txid:vout outpoints into unsigned inputs
+func parseRawInputs(list string) ([]blockchain.TxInput, error) {
+	var inputs []blockchain.TxInput
+
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("input %q is not txid:vout", item)
+		}
+
+		txID, err := hex.DecodeString(parts[0])
+		if err != nil || len(txID) == 0 {
+			return nil, fmt.Errorf("input %q has an invalid transaction ID", item)
+		}
+
+		out, err := strconv.Atoi(parts[1])
+		if err != nil || out < 0 {
+			return nil, fmt.Errorf("input %q has an invalid output index", item)
+		}
+
+		inputs = append(inputs, blockchain.TxInput{ID: txID, Out: out})
+	}
+
+	return inputs, nil
+}
+
+// parseRawOutputs parses comma separated address:amount outputs
+func parseRawOutputs(list string) ([]blockchain.TxOutput, error) {
+	var outputs []blockchain.TxOutput
+
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("output %q is not address:amount", item)
+		}
+
+		amount, err := strconv.Atoi(parts[1])
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("output %q has an invalid amount", item)
+		}
+
+		out, err := blockchain.NewTxOutput(amount, parts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, *out)
+	}
+
+	return outputs, nil
+}
+
 // printUsage prints the command line possible commands
 func (cli *Cmd) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println(" getBalance -address ADDRESS - get the balance of the address")
-	fmt.Println(" createBlockchain -address ADDRESS creates a blockchain")
-	fmt.Println(" printChain - Prints the blocks in the chain")
+	fmt.Println(" getBalance -address ADDRESS [-json] - get the balance of the address")
+	fmt.Printf(" createBlockchain -address ADDRESS [-consensus ENGINE] [-signers ADDRESS,...] creates a blockchain, engines: %s\n", strings.Join(blockchain.ConsensusEngines(), ", "))
+	fmt.Println(" createBlockchain -genesis FILE [-address ADDRESS] creates a blockchain configured by a genesis file")
+	fmt.Println(" printChain [-from HEIGHT] [-to HEIGHT] [-json] - Prints the blocks in the chain, or the blocks between the heights")
+	fmt.Println(" printBlock -height HEIGHT - Prints the block at the height, the genesis block is at 0")
 	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send the amount ")
-	fmt.Println(" listAddresses - Lists the addresses in our wallet file")
-	fmt.Println(" createWallet - creates a new wallet")
+	fmt.Println(" listAddresses [-json] - Lists the addresses in our wallet file")
+	fmt.Println(" createWallet [-curve secp256k1|p256|schnorr] [-format base58|bech32|bech32m] - creates a new wallet")
+	fmt.Println(" getTransaction -id TXID - Prints the transaction and the block containing it")
+	fmt.Println(" decodeTx -tx HEX - Prints a serialized transaction as JSON")
+	fmt.Println(" createRawTx -inputs TXID:VOUT,... -outputs ADDRESS:AMOUNT,... - Prints an unsigned transaction")
+	fmt.Println(" signRawTx -tx HEX [-sighash TYPE] - Signs the inputs of the transaction our wallets can sign")
+	fmt.Println(" decodeRawTx -tx HEX - Prints the fields of a serialized transaction and checks it")
+	fmt.Println(" sendRawTx -tx HEX - Checks a signed transaction and mines it in a block")
+	fmt.Println(" history -address ADDRESS [-page N] [-pagesize SIZE] - Lists the transactions of the address, newest first")
+	fmt.Println(" reindex - Rebuilds the transaction and address indexes from scratch")
+	fmt.Println(" exportChain -file FILE - Writes the blocks to a bootstrap file")
+	fmt.Println(" importChain -file FILE - Checks and connects the blocks of a bootstrap file, resuming an interrupted import")
+	fmt.Println(" backup -out FILE [-wallet] - Writes a snapshot of the database and optionally the wallets file, use the backup method of a running node")
+	fmt.Println(" restore -in FILE - Checks a backup file and restores it to a data directory without a blockchain")
+	fmt.Println(" benchmarkMining [-hash HASH] [-difficulties 8,12,16] [-workercounts 1,2,4] [-rounds N] - Measures the mining hash rate")
+	fmt.Println(" benchmarkSignatures [-counts 1,16,256] - Compares verifying signatures one at a time and in a batch for every curve")
+	fmt.Println(" listSigners - Lists the proof of authority signers sealing the next blocks")
+	fmt.Println(" voteSigner -address ADDRESS [-remove] - Seals a block voting to add or remove a proof of authority signer")
+	fmt.Println(" createHTLC -from FROM -to TO -amount AMOUNT [-secrethash HASH] [-locktime SECONDS] - Lock the amount in a hash time-locked contract")
+	fmt.Println(" claimHTLC -txid TXID -vout OUT -preimage SECRET [-address ADDRESS] - Claim a contract with its secret")
+	fmt.Println(" refundHTLC -txid TXID -vout OUT [-address ADDRESS] - Refund an expired contract to the sender")
+	fmt.Println(" startNode [-rpcaddr ADDR] [-tokenfile FILE] [-restaddr ADDR] - Serve the node and wallet over JSON-RPC")
+	fmt.Println(" startExplorer [-addr ADDR] - Serve the read-only REST block explorer")
+	fmt.Println(" rpc [-rpcaddr ADDR] [-tokenfile FILE] METHOD [PARAMS...] - Call a method on a running node")
+	fmt.Println("   methods: getbalance, send, getblock, gettransaction, listaddresses, createwallet, getblockcount, backup")
+	fmt.Println()
+	fmt.Println(" Every command accepts -datadir DIR to use a blockchain other than ./tmp/blocks")
+	fmt.Println(" and -txindex=false to find transactions by walking the chain instead of the index, kept until -txindex=true")
+	fmt.Println(" and -workers N to set the goroutines mining blocks, the number of CPUs by default")
+	fmt.Printf(" and -network NAME to use another network than mainnet: %s\n", strings.Join(network.Names(), ", "))
 }
 
 // Run takes in the command line inputs
@@ -156,14 +950,108 @@ func (cli *Cmd) Run() {
 	createBlockchainCmd := flag.NewFlagSet("createBlockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printChain", flag.ExitOnError)
+	printBlockCmd := flag.NewFlagSet("printBlock", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listAddresses", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createWallet", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("getTransaction", flag.ExitOnError)
+	decodeTxCmd := flag.NewFlagSet("decodeTx", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createRawTx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signRawTx", flag.ExitOnError)
+	decodeRawTxCmd := flag.NewFlagSet("decodeRawTx", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendRawTx", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportChain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importChain", flag.ExitOnError)
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	benchmarkMiningCmd := flag.NewFlagSet("benchmarkMining", flag.ExitOnError)
+	benchmarkSignaturesCmd := flag.NewFlagSet("benchmarkSignatures", flag.ExitOnError)
+	createHTLCCmd := flag.NewFlagSet("createHTLC", flag.ExitOnError)
+	claimHTLCCmd := flag.NewFlagSet("claimHTLC", flag.ExitOnError)
+	refundHTLCCmd := flag.NewFlagSet("refundHTLC", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startNode", flag.ExitOnError)
+	rpcCmd := flag.NewFlagSet("rpc", flag.ExitOnError)
+	startExplorerCmd := flag.NewFlagSet("startExplorer", flag.ExitOnError)
+	listSignersCmd := flag.NewFlagSet("listSigners", flag.ExitOnError)
+	voteSignerCmd := flag.NewFlagSet("voteSigner", flag.ExitOnError)
+
+	var dataDir string
+	var txIndex bool
+	var workers int
+	var networkName string
+	flagSets := []*flag.FlagSet{
+		getBalanceCmd, createBlockchainCmd, sendCmd, printChainCmd, listAddressesCmd,
+		createWalletCmd, createHTLCCmd, claimHTLCCmd, refundHTLCCmd, startNodeCmd, rpcCmd,
+		startExplorerCmd, getTransactionCmd, decodeTxCmd, historyCmd, reindexCmd, printBlockCmd,
+		benchmarkMiningCmd, benchmarkSignaturesCmd, listSignersCmd, voteSignerCmd,
+		createRawTxCmd, signRawTxCmd, decodeRawTxCmd, sendRawTxCmd, exportChainCmd, importChainCmd,
+		backupCmd, restoreCmd,
+	}
+	for _, fs := range flagSets {
+		fs.StringVar(&dataDir, "datadir", blockchain.DataDir, "Directory holding the blockchain and wallets")
+		fs.BoolVar(&txIndex, "txindex", true, "Keep an index of transaction IDs")
+		fs.IntVar(&workers, "workers", blockchain.MinerWorkers, "Goroutines used to mine blocks")
+		fs.StringVar(&networkName, "network", network.Mainnet.Name, "Network to use: "+strings.Join(network.Names(), ", "))
+	}
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address balance to retrieve")
+	getBalanceJSON := getBalanceCmd.Bool("json", false, "Print the balance as JSON")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "Address to create blockchain for")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", blockchain.DefaultConsensus, "Consensus engine sealing the blocks of the chain")
+	createBlockchainSigners := createBlockchainCmd.String("signers", "", "Comma separated addresses of the proof of authority signers, defaults to the address")
+	createBlockchainGenesis := createBlockchainCmd.String("genesis", "", "JSON file configuring the genesis block, replaces -consensus and -signers")
+	printChainFrom := printChainCmd.Int("from", -1, "Height of the first block to print")
+	printChainTo := printChainCmd.Int("to", -1, "Height of the last block to print")
+	printChainJSON := printChainCmd.Bool("json", false, "Print the blocks as a JSON array")
+	printBlockHeight := printBlockCmd.Int("height", -1, "Height of the block to print")
+	listAddressesJSON := listAddressesCmd.Bool("json", false, "Print the addresses as a JSON array")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	createWalletFormat := createWalletCmd.String("format", wallet.Base58.String(), "Format of the address: base58, bech32 or bech32m")
+	createWalletCurve := createWalletCmd.String("curve", string(wallet.DefaultCurve), "Curve of the wallet key: secp256k1, p256 or schnorr")
+	getTransactionID := getTransactionCmd.String("id", "", "Transaction ID")
+	decodeTxRaw := decodeTxCmd.String("tx", "", "Hex serialized transaction")
+	createRawTxInputs := createRawTxCmd.String("inputs", "", "Comma separated txid:vout outputs to spend")
+	createRawTxOutputs := createRawTxCmd.String("outputs", "", "Comma separated address:amount outputs to create")
+	signRawTxRaw := signRawTxCmd.String("tx", "", "Hex serialized transaction")
+	signRawTxSigHash := signRawTxCmd.String("sighash", blockchain.SigHashAll.String(), "Signature hash type: ALL, NONE or SINGLE with an optional |ANYONECANPAY")
+	decodeRawTxRaw := decodeRawTxCmd.String("tx", "", "Hex serialized transaction")
+	sendRawTxRaw := sendRawTxCmd.String("tx", "", "Hex serialized signed transaction")
+	historyAddress := historyCmd.String("address", "", "The address to list the transactions of")
+	historyPage := historyCmd.Int("page", 1, "Page to print, the first page has the newest transactions")
+	historyPageSize := historyCmd.Int("pagesize", 10, "Transactions per page")
+	exportChainFile := exportChainCmd.String("file", "", "Bootstrap file to write")
+	importChainFile := importChainCmd.String("file", "", "Bootstrap file to read")
+	backupOut := backupCmd.String("out", "", "Backup file to write")
+	backupWallet := backupCmd.Bool("wallet", false, "Include the wallets file")
+	restoreIn := restoreCmd.String("in", "", "Backup file to restore")
+	benchmarkDifficulties := benchmarkMiningCmd.String("difficulties", "8,12,16", "Comma separated difficulties to mine at")
+	benchmarkWorkerCounts := benchmarkMiningCmd.String("workercounts", fmt.Sprintf("1,2,4,%d", runtime.NumCPU()), "Comma separated worker counts to mine with")
+	benchmarkRounds := benchmarkMiningCmd.Int("rounds", 5, "Blocks mined for each difficulty and worker count")
+	benchmarkHash := benchmarkMiningCmd.String("hash", blockchain.DefaultPowHash.Name, "Hash function to mine with: "+strings.Join(blockchain.PowHashes(), ", "))
+	benchmarkCounts := benchmarkSignaturesCmd.String("counts", "1,16,256", "Comma separated numbers of signatures to verify")
+	voteSignerAddress := voteSignerCmd.String("address", "", "Address of the signer voted on")
+	voteSignerRemove := voteSignerCmd.Bool("remove", false, "Vote to remove the signer instead of adding it")
+	createHTLCFrom := createHTLCCmd.String("from", "", "Sender wallet address, refunded after the lock time")
+	createHTLCTo := createHTLCCmd.String("to", "", "Receiver wallet address, can claim with the secret")
+	createHTLCAmount := createHTLCCmd.Int("amount", 0, "Amount to lock")
+	createHTLCSecretHash := createHTLCCmd.String("secrethash", "", "Hex sha256 hash of the secret, a new secret is generated if empty")
+	createHTLCLockTime := createHTLCCmd.Int64("locktime", 24*60*60, "Seconds until the sender can refund the contract")
+	claimHTLCTxID := claimHTLCCmd.String("txid", "", "Contract transaction ID")
+	claimHTLCOut := claimHTLCCmd.Int("vout", 0, "Contract output index")
+	claimHTLCPreimage := claimHTLCCmd.String("preimage", "", "Hex secret of the contract")
+	claimHTLCAddress := claimHTLCCmd.String("address", "", "Address to send the coins to, defaults to the receiver")
+	refundHTLCTxID := refundHTLCCmd.String("txid", "", "Contract transaction ID")
+	refundHTLCOut := refundHTLCCmd.Int("vout", 0, "Contract output index")
+	refundHTLCAddress := refundHTLCCmd.String("address", "", "Address to send the coins to, defaults to the sender")
+	startNodeAddr := startNodeCmd.String("rpcaddr", rpc.DefaultAddr, "Address to serve JSON-RPC on")
+	startNodeTokenFile := startNodeCmd.String("tokenfile", "", "File holding the access token, a cookie file is created in the data directory if empty")
+	startNodeRestAddr := startNodeCmd.String("restaddr", "", "Address to also serve the REST explorer on")
+	startExplorerAddr := startExplorerCmd.String("addr", explorer.DefaultAddr, "Address to serve the REST explorer on")
+	rpcAddr := rpcCmd.String("rpcaddr", rpc.DefaultAddr, "Address of the running node")
+	rpcTokenFile := rpcCmd.String("tokenfile", "", "File holding the access token, defaults to the node cookie file")
 
 	// Listen for the command flags
 	switch os.Args[1] {
@@ -179,6 +1067,10 @@ func (cli *Cmd) Run() {
 		err := printChainCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
 
+	case "printBlock":
+		err := printBlockCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
 	case "listAddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
@@ -191,27 +1083,140 @@ func (cli *Cmd) Run() {
 		err := sendCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
 
+	case "getTransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "decodeTx":
+		err := decodeTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "createRawTx":
+		err := createRawTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "signRawTx":
+		err := signRawTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "decodeRawTx":
+		err := decodeRawTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "sendRawTx":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "reindex":
+		err := reindexCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "exportChain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "importChain":
+		err := importChainCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "backup":
+		err := backupCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "restore":
+		err := restoreCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "benchmarkMining":
+		err := benchmarkMiningCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "benchmarkSignatures":
+		err := benchmarkSignaturesCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "listSigners":
+		err := listSignersCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "voteSigner":
+		err := voteSignerCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "createHTLC":
+		err := createHTLCCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "claimHTLC":
+		err := claimHTLCCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "refundHTLC":
+		err := refundHTLCCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "startNode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "rpc":
+		err := rpcCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
+	case "startExplorer":
+		err := startExplorerCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+
 	default:
 		cli.printUsage()
 		return // Exit the functions
 	}
 
+	params, err := network.Get(networkName)
+	blockchain.Handle(err)
+	network.Active = params
+
+	// Networks other than mainnet keep their chain and wallets apart
+	dataDir = filepath.Join(dataDir, params.DataSubdir)
+
+	blockchain.DataDir = dataDir
+	blockchain.MinerWorkers = workers
+	wallet.DataDir = dataDir
+
+	// The chain keeps its transaction index setting, the flag only changes it when given
+	for _, fs := range flagSets {
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "txindex" {
+				cli.txIndex = &txIndex
+			}
+		})
+	}
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
 			runtime.Goexit()
 			return
 		}
-		cli.getBalance(*getBalanceAddress)
+		cli.getBalance(*getBalanceAddress, *getBalanceJSON)
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" && *createBlockchainGenesis == "" {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 			return
 		}
-		cli.createBlockchain(*createBlockchainAddress)
+
+		if *createBlockchainGenesis != "" {
+			cli.createBlockchainFile(*createBlockchainGenesis, *createBlockchainAddress)
+		} else {
+			cli.createBlockchain(*createBlockchainAddress, *createBlockchainConsensus, *createBlockchainSigners)
+		}
 	}
 
 	if sendCmd.Parsed() {
@@ -224,21 +1229,219 @@ func (cli *Cmd) Run() {
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain()
+		cli.printChain(*printChainFrom, *printChainTo, *printChainJSON)
 		runtime.Goexit()
 		return
 	}
 
+	if printBlockCmd.Parsed() {
+		if *printBlockHeight < 0 {
+			printBlockCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.printBlock(*printBlockHeight)
+	}
+
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses()
+		cli.listAddresses(*listAddressesJSON)
 		runtime.Goexit()
 		return
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet()
+		cli.createWallet(*createWalletCurve, *createWalletFormat)
+		runtime.Goexit()
+		return
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionID == "" {
+			getTransactionCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.getTransaction(*getTransactionID)
+	}
+
+	if decodeTxCmd.Parsed() {
+		if *decodeTxRaw == "" {
+			decodeTxCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.decodeTx(*decodeTxRaw)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxInputs == "" || *createRawTxOutputs == "" {
+			createRawTxCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.createRawTx(*createRawTxInputs, *createRawTxOutputs)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxRaw == "" {
+			signRawTxCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.signRawTx(*signRawTxRaw, *signRawTxSigHash)
+	}
+
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxRaw == "" {
+			decodeRawTxCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.decodeRawTx(*decodeRawTxRaw)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxRaw == "" {
+			sendRawTxCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.sendRawTx(*sendRawTxRaw)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historyPage < 1 || *historyPageSize < 1 {
+			historyCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.history(*historyAddress, *historyPage, *historyPageSize)
+	}
+
+	if reindexCmd.Parsed() {
+		cli.reindex()
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainFile == "" {
+			exportChainCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.exportChain(*exportChainFile)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainFile == "" {
+			importChainCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.importChain(*importChainFile)
+	}
+
+	if backupCmd.Parsed() {
+		if *backupOut == "" {
+			backupCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.backup(*backupOut, *backupWallet)
+	}
+
+	if restoreCmd.Parsed() {
+		if *restoreIn == "" {
+			restoreCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.restore(*restoreIn)
+	}
+
+	if benchmarkMiningCmd.Parsed() {
+		difficulties, err := parseInts(*benchmarkDifficulties)
+		if err == nil {
+			var workerCounts []int
+			workerCounts, err = parseInts(*benchmarkWorkerCounts)
+			if err == nil && *benchmarkRounds > 0 {
+				cli.benchmarkMining(*benchmarkHash, difficulties, workerCounts, *benchmarkRounds)
+				return
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+		benchmarkMiningCmd.Usage()
 		runtime.Goexit()
 		return
 	}
 
+	if benchmarkSignaturesCmd.Parsed() {
+		counts, err := parseInts(*benchmarkCounts)
+		if err != nil {
+			fmt.Println(err)
+			benchmarkSignaturesCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.benchmarkSignatures(counts)
+	}
+
+	if listSignersCmd.Parsed() {
+		cli.listSigners()
+	}
+
+	if voteSignerCmd.Parsed() {
+		if *voteSignerAddress == "" {
+			voteSignerCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.voteSigner(*voteSignerAddress, !*voteSignerRemove)
+	}
+
+	if createHTLCCmd.Parsed() {
+		if *createHTLCFrom == "" || *createHTLCTo == "" || *createHTLCAmount == 0 {
+			createHTLCCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.createHTLC(*createHTLCFrom, *createHTLCTo, *createHTLCAmount, *createHTLCSecretHash, *createHTLCLockTime)
+	}
+
+	if claimHTLCCmd.Parsed() {
+		if *claimHTLCTxID == "" || *claimHTLCPreimage == "" {
+			claimHTLCCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.redeemHTLC(*claimHTLCTxID, *claimHTLCOut, *claimHTLCPreimage, *claimHTLCAddress)
+	}
+
+	if refundHTLCCmd.Parsed() {
+		if *refundHTLCTxID == "" {
+			refundHTLCCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.redeemHTLC(*refundHTLCTxID, *refundHTLCOut, "", *refundHTLCAddress)
+	}
+
+	if startNodeCmd.Parsed() {
+		cli.startNode(*startNodeAddr, *startNodeTokenFile, *startNodeRestAddr)
+	}
+
+	if startExplorerCmd.Parsed() {
+		cli.startExplorer(*startExplorerAddr)
+	}
+
+	if rpcCmd.Parsed() {
+		if rpcCmd.NArg() == 0 {
+			rpcCmd.Usage()
+			runtime.Goexit()
+			return
+		}
+		cli.rpcCall(*rpcAddr, *rpcTokenFile, rpcCmd.Arg(0), rpcCmd.Args()[1:])
+	}
+
 }
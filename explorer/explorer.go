@@ -0,0 +1,259 @@
+/*
+Package explorer serves read-only REST endpoints over the blockchain
+for dashboards, lookups go through the transaction and address indexes
+*/
+package explorer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sheghun/blockchain/blockchain"
+	"github.com/sheghun/blockchain/wallet"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultAddr is the address the explorer listens on
+const DefaultAddr = "127.0.0.1:8080"
+
+// Explorer routes the REST endpoints to the blockchain
+type Explorer struct {
+	chain *blockchain.BlockChain
+	mu    sync.Locker // Held while a request reads the chain
+}
+
+// TxResponse is a transaction with the block it was mined in
+type TxResponse struct {
+	blockchain.JSONTransaction
+	BlockHash string `json:"blockHash"`
+}
+
+// UTXOResponse is an unspent output of an address
+type UTXOResponse struct {
+	TxID  string `json:"txid"`
+	Out   int    `json:"vout"`
+	Value int    `json:"value"`
+}
+
+// HistoryResponse is a page of the transactions touching an address
+type HistoryResponse struct {
+	Total        int            `json:"total"`
+	Transactions []HistoryEntry `json:"transactions"`
+}
+
+// HistoryEntry is a transaction touching an address
+type HistoryEntry struct {
+	TxID      string `json:"txid"`
+	BlockHash string `json:"blockHash"`
+	Direction string `json:"direction"`
+	Amount    int    `json:"amount"`
+}
+
+// TipResponse is the last block of the chain
+type TipResponse struct {
+	Hash   string `json:"hash"`
+	Height int    `json:"height"`
+}
+
+// New creates an explorer for the chain, mu is the lock of the code adding
+// blocks to the chain in the same process, e.g. the JSON-RPC server
+func New(chain *blockchain.BlockChain, mu sync.Locker) *Explorer {
+	return &Explorer{chain, mu}
+}
+
+// ServeHTTP routes
+//
+//	GET /blocks/{hash}
+//	GET /blocks/height/{n}
+//	GET /tx/{id}
+//	GET /address/{addr}/utxos
+//	GET /address/{addr}/history?offset=0&limit=50
+//	GET /tip
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch {
+	case len(parts) == 3 && parts[0] == "blocks" && parts[1] == "height":
+		e.blockByHeight(w, parts[2])
+	case len(parts) == 2 && parts[0] == "blocks":
+		e.block(w, parts[1])
+	case len(parts) == 2 && parts[0] == "tx":
+		e.tx(w, parts[1])
+	case len(parts) == 3 && parts[0] == "address" && parts[2] == "utxos":
+		e.utxos(w, parts[1])
+	case len(parts) == 3 && parts[0] == "address" && parts[2] == "history":
+		e.history(w, r, parts[1])
+	case len(parts) == 1 && parts[0] == "tip":
+		e.tip(w)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (e *Explorer) block(w http.ResponseWriter, hash string) {
+	h, err := hex.DecodeString(hash)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "block hash is not hex encoded")
+		return
+	}
+
+	block, err := e.chain.GetBlock(h)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	e.writeBlock(w, block)
+}
+
+func (e *Explorer) blockByHeight(w http.ResponseWriter, height string) {
+	n, err := strconv.Atoi(height)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "height is not a number")
+		return
+	}
+
+	block, err := e.chain.GetBlockByHeight(n)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	e.writeBlock(w, block)
+}
+
+// writeBlock writes the block with the values spent by its inputs and its fees
+func (e *Explorer) writeBlock(w http.ResponseWriter, block *blockchain.Block) {
+	res, err := e.chain.BlockJSON(block)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func (e *Explorer) tx(w http.ResponseWriter, id string) {
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "transaction ID is not hex encoded")
+		return
+	}
+
+	tx, blockHash, err := e.chain.GetTransaction(txID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, TxResponse{e.chain.TransactionJSON(tx), hex.EncodeToString(blockHash)})
+}
+
+func (e *Explorer) utxos(w http.ResponseWriter, address string) {
+	pubKeyHash, ok := decodeAddress(w, address)
+	if !ok {
+		return
+	}
+
+	utxos, err := e.chain.GetAddressUTXOs(pubKeyHash)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := []UTXOResponse{}
+	for _, utxo := range utxos {
+		res = append(res, UTXOResponse{hex.EncodeToString(utxo.TxID), utxo.Out, utxo.Output.Value})
+	}
+
+	writeJSON(w, res)
+}
+
+func (e *Explorer) history(w http.ResponseWriter, r *http.Request, address string) {
+	pubKeyHash, ok := decodeAddress(w, address)
+	if !ok {
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, err := queryInt(r, "limit", 50)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := e.chain.GetAddressHistory(pubKeyHash, offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := HistoryResponse{total, []HistoryEntry{}}
+	for _, entry := range entries {
+		res.Transactions = append(res.Transactions, HistoryEntry{
+			TxID:      hex.EncodeToString(entry.TxID),
+			BlockHash: hex.EncodeToString(entry.BlockHash),
+			Direction: entry.Direction.String(),
+			Amount:    entry.Amount,
+		})
+	}
+
+	writeJSON(w, res)
+}
+
+func (e *Explorer) tip(w http.ResponseWriter) {
+	writeJSON(w, TipResponse{hex.EncodeToString(e.chain.LastHash), e.chain.GetBestHeight()})
+}
+
+// decodeAddress returns the public key hash of the address or writes a bad request
+func decodeAddress(w http.ResponseWriter, address string) ([]byte, bool) {
+	pubKeyHash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return pubKeyHash, true
+}
+
+// queryInt reads a non negative number from the query string
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non negative number", name)
+	}
+
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
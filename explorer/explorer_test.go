@@ -0,0 +1,165 @@
+package explorer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/sheghun/blockchain/blockchain"
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/wallet"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newTestChain creates a regtest chain in a temporary directory with two wallets, the
+// genesis reward is paid to the first one. The returned func restores the directories
+func newTestChain(t *testing.T) (*blockchain.BlockChain, string, string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "explorer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir, walletDir, active := blockchain.DataDir, wallet.DataDir, network.Active
+	cleanup := func() {
+		blockchain.DataDir, wallet.DataDir, network.Active = dataDir, walletDir, active
+		os.RemoveAll(dir)
+	}
+	blockchain.DataDir = filepath.Join(dir, "chain")
+	wallet.DataDir = filepath.Join(dir, "wallets")
+	network.Active = &network.Regtest
+
+	wallets := wallet.CreateWallets()
+	from := wallets.AddWallet(wallet.Secp256k1, wallet.Base58)
+	to := wallets.AddWallet(wallet.Secp256k1, wallet.Base58)
+	wallets.SaveFile()
+
+	if err := os.MkdirAll(blockchain.DataDir, 0755); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return blockchain.InitBlockChain(from, blockchain.DefaultConsensus), from, to, cleanup
+}
+
+// The explorer reads the chain while blocks are added under the same lock, run with
+// -race -gcflags=all=-d=checkptr=0 as badger trips the pointer checks of the race detector
+func TestTipWhileAddingBlocks(t *testing.T) {
+	chain, from, to, cleanup := newTestChain(t)
+	defer cleanup()
+	defer chain.Database.Close()
+
+	var mu sync.Mutex
+	e := New(chain, &mu)
+
+	const blocks = 5
+	done := make(chan struct{})
+	var reads sync.WaitGroup
+	reads.Add(1)
+
+	go func() {
+		defer reads.Done()
+
+		last := 0
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest("GET", "/tip", nil))
+
+			var tip TipResponse
+			if err := json.NewDecoder(rec.Body).Decode(&tip); err != nil {
+				t.Error(err)
+				return
+			}
+			if tip.Height < last {
+				t.Errorf("the tip went back from %d to %d", last, tip.Height)
+			}
+			last = tip.Height
+		}
+	}()
+
+	for i := 0; i < blocks; i++ {
+		mu.Lock()
+		tx := blockchain.NewTransaction(from, to, 1, chain)
+		chain.AddBlock([]*blockchain.Transaction{tx})
+		mu.Unlock()
+	}
+	close(done)
+	reads.Wait()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/tip", nil))
+
+	var tip TipResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tip); err != nil {
+		t.Fatal(err)
+	}
+	if tip.Height != blocks {
+		t.Errorf("the tip is at height %d instead of %d", tip.Height, blocks)
+	}
+}
+
+// Blocks and transactions are written in the schema of printChain -json
+func TestBlockAndTransactionJSON(t *testing.T) {
+	chain, from, to, cleanup := newTestChain(t)
+	defer cleanup()
+	defer chain.Database.Close()
+
+	tx := blockchain.NewTransaction(from, to, 10, chain)
+	chain.AddBlock([]*blockchain.Transaction{tx})
+
+	e := New(chain, &sync.Mutex{})
+	get := func(path string, v interface{}) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 200 {
+			t.Fatalf("%s: %d %s", path, rec.Code, rec.Body)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var block blockchain.JSONBlock
+	get("/blocks/height/1", &block)
+
+	mined, err := chain.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := chain.BlockJSON(mined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(block, want) == false {
+		t.Errorf("the block is %+v instead of %+v", block, want)
+	}
+	if block.Fees == nil || len(block.Transactions) != 1 || block.Transactions[0].Inputs[0].SigHash != "ALL" {
+		t.Errorf("the block misses the fields of the schema: %+v", block)
+	}
+
+	var res TxResponse
+	get("/tx/"+hex.EncodeToString(tx.ID), &res)
+
+	if reflect.DeepEqual(res.JSONTransaction, chain.TransactionJSON(tx)) == false {
+		t.Errorf("the transaction is %+v", res.JSONTransaction)
+	}
+	if res.BlockHash != block.Hash {
+		t.Errorf("the transaction was mined in %s instead of %s", res.BlockHash, block.Hash)
+	}
+	if res.Fee == nil || *res.Fee != 0 || res.Inputs[0].Address != from || res.Outputs[0].Address != to {
+		t.Errorf("the transaction misses the fields of the schema: %+v", res)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Package network holds the parameters that keep the chains of
+separate networks apart, addresses and databases of one network
+are refused by the others
+*/
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Params are the parameters of a network
+type Params struct {
+	Name           string  // Recorded in the genesis block of the chains of the network
+	AddressVersion byte    // Version byte of the addresses, addresses of other networks are refused
+	Bech32HRP      string  // Human readable prefix of the bech32 addresses
+	Magic          [4]byte // Identifies the network in bootstrap files and the databases of its chains
+	Difficulty     int     // Proof of work difficulty of new chains, the default of the engine when 0
+	Reward         int     // Coins paid by the genesis block of new chains, later blocks pay none
+	GenesisData    string  // Data of the genesis coinbase input
+	DataSubdir     string  // Subdirectory of the data directory holding the chain and wallets
+}
+
+// Mainnet is the network of the chains created before networks existed
+var Mainnet = Params{
+	Name:           "mainnet",
+	AddressVersion: 0x00,
+	Bech32HRP:      "blk",
+	Magic:          [4]byte{0xc3, 0x9e, 0x4b, 0xd1},
+	Reward:         100,
+	GenesisData:    "First Transaction from Genesis",
+}
+
+// Testnet is a public network for testing, its coins have no value
+var Testnet = Params{
+	Name:           "testnet",
+	AddressVersion: 0x6f,
+	Bech32HRP:      "tblk",
+	Magic:          [4]byte{0xa6, 0xe1, 0x9c, 0xf4},
+	Reward:         100,
+	GenesisData:    "First Transaction from Testnet Genesis",
+	DataSubdir:     "testnet",
+}
+
+// Regtest is a local network mining blocks instantly at a trivial difficulty
+var Regtest = Params{
+	Name:           "regtest",
+	AddressVersion: 0x3c,
+	Bech32HRP:      "rblk",
+	Magic:          [4]byte{0xb5, 0xd2, 0x8f, 0xe7},
+	Difficulty:     1,
+	Reward:         50,
+	GenesisData:    "First Transaction from Regtest Genesis",
+	DataSubdir:     "regtest",
+}
+
+// Active is the network the node runs on, selected with the -network flag
+var Active = &Mainnet
+
+var networks = map[string]*Params{
+	Mainnet.Name: &Mainnet,
+	Testnet.Name: &Testnet,
+	Regtest.Name: &Regtest,
+}
+
+// Get returns the parameters of the named network
+func Get(name string) (*Params, error) {
+	params, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", name)
+	}
+
+	return params, nil
+}
+
+// Names returns the names of the networks
+func Names() []string {
+	var names []string
+
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ForBech32HRP returns the network of the bech32 prefix, nil if there is none
+func ForBech32HRP(hrp string) *Params {
+	for _, params := range networks {
+		if params.Bech32HRP == hrp {
+			return params
+		}
+	}
+
+	return nil
+}
+
+// ForAddressVersion returns the network of the address version byte, nil if there is none
+func ForAddressVersion(version byte) *Params {
+	for _, params := range networks {
+		if params.AddressVersion == version {
+			return params
+		}
+	}
+
+	return nil
+}
+
+// ForMagic returns the network of the magic, nil if there is none
+func ForMagic(magic []byte) *Params {
+	for _, params := range networks {
+		if bytes.Equal(params.Magic[:], magic) {
+			return params
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range Names() {
+		params, err := Get(name)
+		if err != nil || params.Name != name {
+			t.Errorf("the network %s is %v, %v", name, params, err)
+		}
+	}
+
+	if _, err := Get("unknown"); err == nil {
+		t.Error("an unknown network was found")
+	}
+}
+
+func TestForBech32HRP(t *testing.T) {
+	tests := []struct {
+		hrp  string
+		want *Params
+	}{
+		{"blk", &Mainnet},
+		{"tblk", &Testnet},
+		{"rblk", &Regtest},
+		{"bc", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		if got := ForBech32HRP(test.hrp); got != test.want {
+			t.Errorf("the prefix %q belongs to %v instead of %v", test.hrp, got, test.want)
+		}
+	}
+}
+
+func TestForAddressVersion(t *testing.T) {
+	tests := []struct {
+		version byte
+		want    *Params
+	}{
+		{0x00, &Mainnet},
+		{0x6f, &Testnet},
+		{0x3c, &Regtest},
+		{0x05, nil},
+	}
+
+	for _, test := range tests {
+		if got := ForAddressVersion(test.version); got != test.want {
+			t.Errorf("the version %#x belongs to %v instead of %v", test.version, got, test.want)
+		}
+	}
+}
+
+func TestForMagic(t *testing.T) {
+	for _, params := range []*Params{&Mainnet, &Testnet, &Regtest} {
+		if got := ForMagic(params.Magic[:]); got != params {
+			t.Errorf("the magic of %s belongs to %v", params.Name, got)
+		}
+	}
+
+	if got := ForMagic([]byte{0xf9, 0xbe, 0xb4, 0xd9}); got != nil {
+		t.Errorf("the bitcoin magic belongs to %s", got.Name)
+	}
+	if got := ForMagic(Mainnet.Magic[:3]); got != nil {
+		t.Errorf("a truncated magic belongs to %s", got.Name)
+	}
+}
+
+// The values telling the networks apart are never shared
+func TestParamsAreDistinct(t *testing.T) {
+	names := Names()
+
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			pa, _ := Get(a)
+			pb, _ := Get(b)
+
+			if pa.AddressVersion == pb.AddressVersion || pa.Bech32HRP == pb.Bech32HRP || pa.Magic == pb.Magic || pa.DataSubdir == pb.DataSubdir {
+				t.Errorf("%s and %s share parameters", a, b)
+			}
+		}
+	}
+}
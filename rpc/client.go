@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Client calls the methods of a running server
+type Client struct {
+	Addr  string
+	Token string
+}
+
+// NewClient creates a client for the server at addr using the token
+func NewClient(addr, token string) *Client {
+	return &Client{addr, token}
+}
+
+// Call runs the method on the server and returns its raw result
+func (c *Client) Call(method string, params ...interface{}) (json.RawMessage, error) {
+	rawParams := []json.RawMessage{}
+
+	for _, p := range params {
+		raw, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		rawParams = append(rawParams, raw)
+	}
+
+	body, err := json.Marshal(Request{"2.0", method, rawParams, json.RawMessage("1")})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+c.Addr, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with %s", resp.Status)
+	}
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return res.Result, nil
+}
@@ -0,0 +1,417 @@
+/*
+Package rpc serves the node and wallet operations over JSON-RPC 2.0
+and provides the client used by the command line to talk to it
+*/
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sheghun/blockchain/blockchain"
+	"github.com/sheghun/blockchain/wallet"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultAddr is the address the server listens on and the client connects to
+const DefaultAddr = "127.0.0.1:9332"
+
+// handler runs a method with its positional params, the context
+// is cancelled when the client goes away or the server shuts down
+type handler func(ctx context.Context, params []json.RawMessage) (interface{}, error)
+
+// Server handles JSON-RPC requests against an open blockchain
+type Server struct {
+	chain    *blockchain.BlockChain
+	token    string
+	methods  map[string]handler
+	mu       sync.Mutex // Calls share the database and wallets file, run one at a time
+	http     *http.Server
+	tokenDir string
+	stop     context.CancelFunc // Cancels the calls in progress on shutdown
+}
+
+// CookieFile returns the path of the token file written in the data directory
+func CookieFile(dataDir string) string {
+	return filepath.Join(dataDir, "rpc.cookie")
+}
+
+// BackupDir returns the directory in the data directory the backup method writes to
+func BackupDir(dataDir string) string {
+	return filepath.Join(dataDir, "backups")
+}
+
+// NewServer creates a server for the chain, the token is read from the token file
+// when one is supplied, otherwise a new token is written to the cookie file
+func NewServer(chain *blockchain.BlockChain, addr, tokenFile string) (*Server, error) {
+	s := &Server{chain: chain}
+
+	if tokenFile != "" {
+		token, err := ReadToken(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		s.token = token
+	} else {
+		token := make([]byte, 32)
+		if _, err := rand.Read(token); err != nil {
+			return nil, err
+		}
+		s.token = hex.EncodeToString(token)
+
+		err := ioutil.WriteFile(CookieFile(blockchain.DataDir), []byte(s.token), 0600)
+		if err != nil {
+			return nil, err
+		}
+		s.tokenDir = blockchain.DataDir
+	}
+
+	s.methods = map[string]handler{
+		"getbalance":     s.getBalance,
+		"send":           s.send,
+		"getblock":       s.getBlock,
+		"gettransaction": s.getTransaction,
+		"listaddresses":  s.listAddresses,
+		"createwallet":   s.createWallet,
+		"getblockcount":  s.getBlockCount,
+		"backup":         s.backup,
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	s.http = &http.Server{Addr: addr, Handler: s, BaseContext: func(net.Listener) context.Context { return ctx }}
+
+	return s, nil
+}
+
+// Locker returns the lock the calls hold, code reading the chain in the
+// same process holds it so it doesn't race with the calls adding blocks
+func (s *Server) Locker() sync.Locker {
+	return &s.mu
+}
+
+// ListenAndServe serves requests until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	err := s.http.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown stops the server and removes the cookie file it created
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.tokenDir != "" {
+		_ = os.Remove(CookieFile(s.tokenDir))
+	}
+
+	// Stop blocks being mined so the calls return before the deadline
+	s.stop()
+
+	return s.http.Shutdown(ctx)
+}
+
+// ServeHTTP authenticates and runs a single JSON-RPC request, a request without
+// an id is a notification, it's run without a response
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req Request
+	res := Response{JSONRPC: "2.0", ID: json.RawMessage("null")}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		res.Error = &Error{codeParseError, err.Error()}
+	} else if req.JSONRPC != "2.0" || req.Method == "" {
+		res.Error = &Error{codeInvalidRequest, "invalid request"}
+	} else {
+		res.Result, res.Error = s.call(r.Context(), req.Method, req.Params)
+
+		if req.ID == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		res.ID = req.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// call runs the method, the chain code panics on failures so those are recovered
+func (s *Server) call(ctx context.Context, method string, params []json.RawMessage) (result interface{}, rpcErr *Error) {
+	h, ok := s.methods[method]
+	if !ok {
+		return nil, &Error{codeMethodNotFound, fmt.Sprintf("method %s not found", method)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, rpcErr = nil, &Error{codeInternalError, fmt.Sprint(r)}
+		}
+	}()
+
+	result, err := h(ctx, params)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			return nil, e
+		}
+		return nil, &Error{codeInternalError, err.Error()}
+	}
+
+	return result, nil
+}
+
+// parseParams decodes the positional params into the supplied values
+func parseParams(params []json.RawMessage, values ...interface{}) error {
+	if len(params) != len(values) {
+		return &Error{codeInvalidParams, fmt.Sprintf("expected %d params, got %d", len(values), len(params))}
+	}
+
+	for i, p := range params {
+		if err := json.Unmarshal(p, values[i]); err != nil {
+			return &Error{codeInvalidParams, fmt.Sprintf("param %d: %s", i, err)}
+		}
+	}
+
+	return nil
+}
+
+// validAddress returns an invalid params error for bad addresses
+func validAddress(address string) error {
+	if err := wallet.ValidateAddress(address); err != nil {
+		return &Error{codeInvalidParams, err.Error()}
+	}
+
+	return nil
+}
+
+// getbalance [address]
+func (s *Server) getBalance(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	var address string
+	if err := parseParams(params, &address); err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		return nil, &Error{codeInvalidParams, err.Error()}
+	}
+
+	return s.chain.GetBalance(pubKeyHash), nil
+}
+
+// send [from, to, amount] returns the transaction ID
+func (s *Server) send(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	var from, to string
+	var amount int
+	if err := parseParams(params, &from, &to, &amount); err != nil {
+		return nil, err
+	}
+	if err := validAddress(from); err != nil {
+		return nil, err
+	}
+	if err := validAddress(to); err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, &Error{codeInvalidParams, "amount must be positive"}
+	}
+
+	tx := blockchain.NewTransaction(from, to, amount, s.chain)
+	if _, _, err := s.chain.AddBlockContext(ctx, []*blockchain.Transaction{tx}); err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// getblock [hash]
+func (s *Server) getBlock(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := parseParams(params, &hash); err != nil {
+		return nil, err
+	}
+
+	h, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, &Error{codeInvalidParams, "hash is not hex encoded"}
+	}
+
+	block, err := s.chain.GetBlock(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.chain.BlockJSON(block)
+}
+
+// gettransaction [txid]
+func (s *Server) getTransaction(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	var id string
+	if err := parseParams(params, &id); err != nil {
+		return nil, err
+	}
+
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, &Error{codeInvalidParams, "txid is not hex encoded"}
+	}
+
+	tx, err := s.chain.FindTransaction(txID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.chain.TransactionJSON(&tx), nil
+}
+
+// listaddresses []
+func (s *Server) listAddresses(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+
+	addresses := wallet.CreateWallets().GetAllAddresses()
+	if addresses == nil {
+		addresses = []string{}
+	}
+
+	return addresses, nil
+}
+
+// createwallet [format, curve] returns the new address, base58 unless format is bech32
+// or bech32m, with a key on the default curve unless curve is set
+func (s *Server) createWallet(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	name := wallet.Base58.String()
+	curveName := string(wallet.DefaultCurve)
+
+	values := []interface{}{&name, &curveName}
+	if len(params) > len(values) {
+		return nil, parseParams(params, values...)
+	}
+	if err := parseParams(params, values[:len(params)]...); err != nil {
+		return nil, err
+	}
+
+	format, err := wallet.ParseAddressFormat(name)
+	if err != nil {
+		return nil, &Error{codeInvalidParams, err.Error()}
+	}
+
+	curve, err := wallet.ParseCurve(curveName)
+	if err != nil {
+		return nil, &Error{codeInvalidParams, err.Error()}
+	}
+
+	wallets := wallet.CreateWallets()
+	address := wallets.AddWallet(curve, format)
+	wallets.SaveFile()
+
+	return address, nil
+}
+
+// getblockcount [] returns the number of blocks in the chain
+func (s *Server) getBlockCount(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+
+	return s.chain.GetBestHeight() + 1, nil
+}
+
+// backup [file, wallet] writes a backup of the database to a new file of that name in the
+// backup directory of the node, with the wallets file when wallet is true. Calls run one at
+// a time so no block is stored meanwhile
+func (s *Server) backup(ctx context.Context, params []json.RawMessage) (interface{}, error) {
+	var file string
+	var includeWallet bool
+
+	values := []interface{}{&file, &includeWallet}
+	if len(params) > len(values) {
+		return nil, parseParams(params, values...)
+	}
+	if len(params) == 0 {
+		return nil, parseParams(params, &file)
+	}
+	if err := parseParams(params, values[:len(params)]...); err != nil {
+		return nil, err
+	}
+
+	// A name only, the caller can't pick where the node writes
+	if file == "." || file == ".." || filepath.Base(file) != file || strings.ContainsAny(file, `/\`) {
+		return nil, &Error{codeInvalidParams, fmt.Sprintf("%q is not a file name in the backup directory", file)}
+	}
+
+	var wallets []byte
+	if includeWallet {
+		var err error
+		if wallets, err = wallet.ReadFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := BackupDir(blockchain.DataDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	// Existing files are never overwritten, the backup can hold the private keys
+	path := filepath.Join(dir, file)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("the backup %s already exists", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := s.chain.WriteBackup(f, wallets)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return BackupResult{path, backup.Height + 1, hex.EncodeToString(backup.LastHash), backup.Wallets != nil}, nil
+}
+
+// ReadToken reads the authentication token from a token or cookie file
+func ReadToken(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.New("token file is empty")
+	}
+
+	return token, nil
+}
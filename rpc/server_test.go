@@ -0,0 +1,234 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/sheghun/blockchain/blockchain"
+	"github.com/sheghun/blockchain/network"
+	"github.com/sheghun/blockchain/wallet"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves a regtest chain created in a temporary directory, the genesis
+// reward is paid to a new wallet. The returned func closes the chain and restores the directories
+func newTestServer(t *testing.T) (*Server, string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir, walletDir, active := blockchain.DataDir, wallet.DataDir, network.Active
+	restore := func() {
+		blockchain.DataDir, wallet.DataDir, network.Active = dataDir, walletDir, active
+		os.RemoveAll(dir)
+	}
+	blockchain.DataDir = filepath.Join(dir, "chain")
+	wallet.DataDir = filepath.Join(dir, "wallets")
+	network.Active = &network.Regtest
+
+	wallets := wallet.CreateWallets()
+	address := wallets.AddWallet(wallet.Secp256k1, wallet.Base58)
+	wallets.SaveFile()
+
+	if err := os.MkdirAll(blockchain.DataDir, 0755); err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	chain := blockchain.InitBlockChain(address, blockchain.DefaultConsensus)
+
+	s, err := NewServer(chain, "127.0.0.1:0", "")
+	if err != nil {
+		chain.Database.Close()
+		restore()
+		t.Fatal(err)
+	}
+
+	return s, address, func() {
+		chain.Database.Close()
+		restore()
+	}
+}
+
+// post sends the body to the server with the token
+func post(s *Server, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// response decodes the JSON-RPC response of the recorder
+func response(t *testing.T, rec *httptest.ResponseRecorder) (json.RawMessage, json.RawMessage, *Error) {
+	t.Helper()
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+		ID     json.RawMessage `json:"id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("the response isn't JSON: %s", err)
+	}
+
+	return res.Result, res.ID, res.Error
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	cookie, err := ReadToken(CookieFile(blockchain.DataDir))
+	if err != nil || cookie != s.token {
+		t.Fatalf("the cookie file holds %q instead of the token, %v", cookie, err)
+	}
+
+	body := `{"jsonrpc":"2.0","method":"getblockcount","params":[],"id":1}`
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		status        int
+	}{
+		{"with the token", http.MethodPost, "Bearer " + s.token, http.StatusOK},
+		{"without a token", http.MethodPost, "", http.StatusUnauthorized},
+		{"with another token", http.MethodPost, "Bearer " + strings.Repeat("0", len(s.token)), http.StatusUnauthorized},
+		{"with a prefix of the token", http.MethodPost, "Bearer " + s.token[:10], http.StatusUnauthorized},
+		{"with GET", http.MethodGet, "Bearer " + s.token, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/", strings.NewReader(body))
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("a request %s got the status %d instead of %d", test.name, rec.Code, test.status)
+		}
+	}
+}
+
+// Requests without an id are run without a response, others are answered with their id
+func TestNotifications(t *testing.T) {
+	s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		body     string
+		response bool
+		id       string
+		code     int
+	}{
+		{"a request", `{"jsonrpc":"2.0","method":"createwallet","params":[],"id":7}`, true, "7", 0},
+		{"a request with a string id", `{"jsonrpc":"2.0","method":"getblockcount","params":[],"id":"a"}`, true, `"a"`, 0},
+		{"a request with a null id", `{"jsonrpc":"2.0","method":"getblockcount","params":[],"id":null}`, true, "null", 0},
+		{"a notification", `{"jsonrpc":"2.0","method":"createwallet","params":[]}`, false, "", 0},
+		{"a failing notification", `{"jsonrpc":"2.0","method":"unknown","params":[]}`, false, "", 0},
+		{"an unknown method", `{"jsonrpc":"2.0","method":"unknown","params":[],"id":2}`, true, "2", codeMethodNotFound},
+		{"invalid JSON", `{"jsonrpc":"2.0",`, true, "null", codeParseError},
+		{"another version", `{"jsonrpc":"1.0","method":"getblockcount","params":[]}`, true, "null", codeInvalidRequest},
+	}
+
+	for _, test := range tests {
+		rec := post(s, test.body)
+
+		if test.response == false {
+			if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+				t.Errorf("%s got the status %d and the body %q", test.name, rec.Code, rec.Body.String())
+			}
+			continue
+		}
+
+		_, id, rpcErr := response(t, rec)
+		if string(id) != test.id {
+			t.Errorf("%s was answered with the id %s instead of %s", test.name, id, test.id)
+		}
+		if (rpcErr == nil && test.code != 0) || (rpcErr != nil && rpcErr.Code != test.code) {
+			t.Errorf("%s got the error %v instead of the code %d", test.name, rpcErr, test.code)
+		}
+	}
+
+	// The notification still created its wallet
+	if got := len(wallet.CreateWallets().GetAllAddresses()); got != 3 {
+		t.Errorf("there are %d wallets instead of 3", got)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		params  string
+		ok      bool
+		wallets bool
+	}{
+		{"a backup", `["chain.bak"]`, true, false},
+		{"a backup with the wallets", `["wallets.bak", true]`, true, true},
+		{"a backup without the wallets", `["nowallets.bak", false]`, true, false},
+		{"an existing file", `["chain.bak"]`, false, false},
+		{"a parent directory", `["../chain.bak"]`, false, false},
+		{"a subdirectory", `["sub/chain.bak"]`, false, false},
+		{"an absolute path", `["/tmp/chain.bak"]`, false, false},
+		{"a windows path", `["sub\\chain.bak"]`, false, false},
+		{"the directory", `["."]`, false, false},
+		{"without a file", `[]`, false, false},
+		{"too many params", `["extra.bak", true, 1]`, false, false},
+	}
+
+	for _, test := range tests {
+		result, _, rpcErr := response(t, post(s, `{"jsonrpc":"2.0","method":"backup","params":`+test.params+`,"id":1}`))
+
+		if test.ok == false {
+			if rpcErr == nil {
+				t.Errorf("%s was written to %s", test.name, result)
+			}
+			continue
+		}
+		if rpcErr != nil {
+			t.Errorf("%s failed: %s", test.name, rpcErr)
+			continue
+		}
+
+		var backup BackupResult
+		if err := json.Unmarshal(result, &backup); err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(backup.File) != BackupDir(blockchain.DataDir) {
+			t.Errorf("%s was written to %s outside the backup directory", test.name, backup.File)
+		}
+		if backup.Blocks != 1 || backup.Wallets != test.wallets {
+			t.Errorf("%s holds %d blocks and the wallets %t", test.name, backup.Blocks, backup.Wallets)
+		}
+
+		// The wallets file holds private keys
+		info, err := os.Stat(backup.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s has the permissions %o", test.name, perm)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(BackupDir(blockchain.DataDir))
+	if err != nil || len(entries) != 3 {
+		t.Errorf("the backup directory holds %d files instead of 3, %v", len(entries), err)
+	}
+}
@@ -0,0 +1,47 @@
+package rpc
+
+import "encoding/json"
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// Request is a JSON-RPC 2.0 request, params are always positional and
+// requests without an ID are notifications which get no response
+type Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is the error object of a failed call
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// BackupResult is the result of backup
+type BackupResult struct {
+	File     string `json:"file"`
+	Blocks   int    `json:"blocks"`
+	LastHash string `json:"lastHash"`
+	Wallets  bool   `json:"wallets"` // Whether the wallets file is part of the backup
+}
@@ -0,0 +1,363 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// batchWindow is the width of the non-adjacent form of the scalars multiplied
+// in a batch, every point gets a table of 2^(batchWindow-2) odd multiples
+const batchWindow = 5
+
+// challengeTag is the tag of the BIP340 hash committing to the nonce, the key and the message
+var challengeTag = sha256.Sum256([]byte("BIP0340/challenge"))
+
+// BatchVerifier verifies the signatures of a block together, Schnorr signatures
+// are checked with a single multi-scalar multiplication which is faster than
+// verifying them one at a time, the others are verified as they are added
+type BatchVerifier struct {
+	sigs    []schnorrSig
+	invalid bool
+}
+
+// schnorrSig is a parsed Schnorr signature waiting to be verified
+type schnorrSig struct {
+	pubKey btcec.JacobianPoint
+	r      btcec.FieldVal
+	s      btcec.ModNScalar
+	e      btcec.ModNScalar // Challenge of the signature
+}
+
+// Add adds the signature of the hash by the public key on the curve to the batch
+func (b *BatchVerifier) Add(curve Curve, pubKey, hash, signature []byte) {
+	if b.invalid {
+		return
+	}
+
+	if curve != Schnorr {
+		b.invalid = Verify(curve, pubKey, hash, signature) == false
+		return
+	}
+
+	sig, ok := parseSchnorrSig(pubKey, hash, signature)
+	if ok == false {
+		b.invalid = true
+		return
+	}
+
+	b.sigs = append(b.sigs, sig)
+}
+
+// Verify returns whether all the signatures added to the batch are valid
+func (b *BatchVerifier) Verify() bool {
+	if b.invalid {
+		return false
+	}
+
+	switch len(b.sigs) {
+	case 0:
+		return true
+	case 1:
+		// Nothing to gain from a batch of one
+		return b.sigs[0].verify()
+	}
+
+	// Every signature satisfies s*G = R + e*P, they are summed with random
+	// coefficients a so a bad signature can't be cancelled out by another one
+	// (a1*s1 + a2*s2 + ...)*G = a1*R1 + a1*e1*P1 + a2*R2 + a2*e2*P2 + ...
+	points := make([]btcec.JacobianPoint, 0, 2*len(b.sigs))
+	scalars := make([]btcec.ModNScalar, 0, 2*len(b.sigs))
+	var sum btcec.ModNScalar
+
+	for i := range b.sigs {
+		sig := &b.sigs[i]
+
+		var r btcec.JacobianPoint
+		if liftX(&sig.r, &r) == false {
+			return false
+		}
+
+		var a btcec.ModNScalar
+		if i == 0 {
+			a.SetInt(1)
+		} else {
+			a = randomScalar()
+		}
+
+		var as, ae btcec.ModNScalar
+		sum.Add(as.Mul2(&a, &sig.s))
+		ae.Mul2(&a, &sig.e)
+
+		points = append(points, r, sig.pubKey)
+		scalars = append(scalars, a, ae)
+	}
+
+	var left, right btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(&sum, &left)
+	multiScalarMult(scalars, points, &right)
+
+	left.ToAffine()
+	right.ToAffine()
+
+	return left.X.Equals(&right.X) && left.Y.Equals(&right.Y)
+}
+
+// BenchmarkVerify signs count random hashes with keys on the curve and returns the
+// time taken to verify the signatures one at a time and together in a batch
+func BenchmarkVerify(curve Curve, count int) (single, batch time.Duration, err error) {
+	pubKeys := make([][]byte, count)
+	hashes := make([][]byte, count)
+	signatures := make([][]byte, count)
+
+	for i := 0; i < count; i++ {
+		privKey, pubKey := NewKeyPair(curve)
+
+		hashes[i] = make([]byte, 32)
+		if _, err := rand.Read(hashes[i]); err != nil {
+			return 0, 0, err
+		}
+
+		pubKeys[i] = pubKey
+		signatures[i] = Sign(curve, privKey, hashes[i])
+	}
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if Verify(curve, pubKeys[i], hashes[i], signatures[i]) == false {
+			return 0, 0, errors.New("a signature failed to verify")
+		}
+	}
+	single = time.Since(start)
+
+	start = time.Now()
+	b := &BatchVerifier{}
+	for i := 0; i < count; i++ {
+		b.Add(curve, pubKeys[i], hashes[i], signatures[i])
+	}
+	if b.Verify() == false {
+		return 0, 0, errors.New("the batch failed to verify")
+	}
+	batch = time.Since(start)
+
+	return single, batch, nil
+}
+
+// parseSchnorrSig parses the signature and computes its challenge
+func parseSchnorrSig(pubKey, hash, signature []byte) (schnorrSig, bool) {
+	var sig schnorrSig
+
+	if len(hash) != 32 || len(signature) != schnorr.SignatureSize {
+		return sig, false
+	}
+
+	key, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return sig, false
+	}
+	key.AsJacobian(&sig.pubKey)
+
+	if overflow := sig.r.SetByteSlice(signature[:32]); overflow {
+		return sig, false
+	}
+	if overflow := sig.s.SetByteSlice(signature[32:]); overflow {
+		return sig, false
+	}
+
+	// e = tagged_hash("BIP0340/challenge", r || P || m)
+	h := sha256.New()
+	h.Write(challengeTag[:])
+	h.Write(challengeTag[:])
+	h.Write(signature[:32])
+	h.Write(pubKey)
+	h.Write(hash)
+
+	if overflow := sig.e.SetByteSlice(h.Sum(nil)); overflow {
+		return sig, false
+	}
+
+	return sig, true
+}
+
+// verify checks the signature on its own, R = s*G - e*P must have an even y and r as x
+func (sig *schnorrSig) verify() bool {
+	var negE btcec.ModNScalar
+	negE.NegateVal(&sig.e)
+
+	var sG, eP, r btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(&sig.s, &sG)
+	btcec.ScalarMultNonConst(&negE, &sig.pubKey, &eP)
+	btcec.AddNonConst(&sG, &eP, &r)
+
+	if (r.X.IsZero() && r.Y.IsZero()) || r.Z.IsZero() {
+		return false
+	}
+	r.ToAffine()
+
+	return r.Y.IsOdd() == false && sig.r.Equals(&r.X)
+}
+
+// liftX sets the point to the one with the x coordinate and an even y
+func liftX(x *btcec.FieldVal, point *btcec.JacobianPoint) bool {
+	point.X.Set(x)
+	if btcec.DecompressY(&point.X, false, &point.Y) == false {
+		return false
+	}
+	point.Y.Normalize()
+	point.Z.SetInt(1)
+
+	return true
+}
+
+// randomScalar returns a random non zero scalar
+func randomScalar() btcec.ModNScalar {
+	var a btcec.ModNScalar
+	var b [32]byte
+
+	for {
+		_, err := rand.Read(b[:])
+		Handle(err)
+
+		if overflow := a.SetBytes(&b); overflow == 0 && a.IsZero() == false {
+			return a
+		}
+	}
+}
+
+// multiScalarMult sets the result to the sum of the points multiplied by their
+// scalars, the points share the doublings and are added from tables of their
+// odd multiples as the digits of the non-adjacent form of their scalars require
+func multiScalarMult(scalars []btcec.ModNScalar, points []btcec.JacobianPoint, result *btcec.JacobianPoint) {
+	tables := oddMultiples(points)
+
+	nafs := make([][]int8, len(scalars))
+	length := 0
+	for i := range scalars {
+		nafs[i] = wnaf(&scalars[i])
+		if len(nafs[i]) > length {
+			length = len(nafs[i])
+		}
+	}
+
+	// Start from the point at infinity
+	result.X.SetInt(0)
+	result.Y.SetInt(0)
+	result.Z.SetInt(0)
+
+	var neg btcec.JacobianPoint
+	for bit := length - 1; bit >= 0; bit-- {
+		btcec.DoubleNonConst(result, result)
+
+		for i, naf := range nafs {
+			if bit >= len(naf) || naf[bit] == 0 {
+				continue
+			}
+
+			if digit := naf[bit]; digit > 0 {
+				btcec.AddNonConst(result, &tables[i][digit/2], result)
+			} else {
+				neg.Set(&tables[i][-digit/2])
+				neg.Y.Negate(1).Normalize()
+				btcec.AddNonConst(result, &neg, result)
+			}
+		}
+	}
+}
+
+// oddMultiples returns P, 3P, 5P... of every point in affine coordinates
+// so they are added with the faster mixed addition
+func oddMultiples(points []btcec.JacobianPoint) [][]btcec.JacobianPoint {
+	size := 1 << (batchWindow - 2)
+	tables := make([][]btcec.JacobianPoint, len(points))
+
+	for i := range points {
+		table := make([]btcec.JacobianPoint, size)
+		table[0].Set(&points[i])
+
+		var double btcec.JacobianPoint
+		btcec.DoubleNonConst(&points[i], &double)
+		for j := 1; j < size; j++ {
+			btcec.AddNonConst(&table[j-1], &double, &table[j])
+		}
+
+		tables[i] = table
+	}
+
+	// Montgomery's trick, the z of every point is inverted with a single inversion
+	products := make([]btcec.FieldVal, len(points)*size)
+	var product btcec.FieldVal
+	product.SetInt(1)
+	for i, table := range tables {
+		for j := range table {
+			products[i*size+j].Set(&product)
+			product.Mul(&table[j].Z)
+		}
+	}
+
+	inverse := product.Inverse()
+	for i := len(tables) - 1; i >= 0; i-- {
+		for j := size - 1; j >= 0; j-- {
+			p := &tables[i][j]
+
+			var zInv, zInv2 btcec.FieldVal
+			zInv.Mul2(inverse, &products[i*size+j])
+			inverse.Mul(&p.Z)
+
+			zInv2.SquareVal(&zInv)
+			p.X.Mul(&zInv2).Normalize()
+			p.Y.Mul(zInv2.Mul(&zInv)).Normalize()
+			p.Z.SetInt(1)
+		}
+	}
+
+	return tables
+}
+
+// wnaf returns the digits of the width batchWindow non-adjacent form of the scalar,
+// least significant first, every digit is odd or zero and smaller than 2^(batchWindow-1)
+func wnaf(k *btcec.ModNScalar) []int8 {
+	b := k.Bytes()
+
+	var n [5]uint64
+	for i := 0; i < 4; i++ {
+		n[i] = binary.BigEndian.Uint64(b[24-8*i : 32-8*i])
+	}
+
+	naf := make([]int8, 0, 257)
+	for n != [5]uint64{} {
+		var digit int64
+
+		if n[0]&1 == 1 {
+			digit = int64(n[0] & (1<<batchWindow - 1))
+			if digit >= 1<<(batchWindow-1) {
+				digit -= 1 << batchWindow
+			}
+
+			// Subtract the digit so the next batchWindow-1 bits are zero
+			if digit > 0 {
+				n[0] -= uint64(digit)
+			} else {
+				var carry uint64
+				n[0], carry = bits.Add64(n[0], uint64(-digit), 0)
+				for i := 1; i < len(n) && carry != 0; i++ {
+					n[i], carry = bits.Add64(n[i], 0, carry)
+				}
+			}
+		}
+
+		naf = append(naf, int8(digit))
+
+		for i := 0; i < len(n)-1; i++ {
+			n[i] = n[i]>>1 | n[i+1]<<63
+		}
+		n[len(n)-1] >>= 1
+	}
+
+	return naf
+}
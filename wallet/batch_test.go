@@ -0,0 +1,358 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Verification vectors of BIP340, the ones with a secret key
+// are signed with their auxiliary randomness by the BIP
+var bip340Vectors = []struct {
+	publicKey string
+	message   string
+	signature string
+	valid     bool
+}{
+	{
+		"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		true,
+	},
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		true,
+	},
+	{
+		"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+		"7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+		"5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7",
+		true,
+	},
+	{
+		"25D1DFF95105F5253C4022F628A996AD3A0D95FBF21D468A1B33F8C160D8F517",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		"7EB0509757E246F19449885651611CB965ECC1A187DD51B64FDA1EDC9637D5EC97582B9CB13DB3933705B32BA982AF5AF25FD78881EBB32771FC5922EFC66EA3",
+		true,
+	},
+	{
+		"D69C3509BB99E412E68B0FE8544E72837DFA30746D8BE2AA65975F29D22DC7B9",
+		"4DF3C3F68FCC83B27E9D42C90431A72499F17875C81A599B566C9889B9696703",
+		"00000000000000000000003B78CE563F89A0ED9414F5AA28AD0D96D6795F9C6376AFB1548AF603B3EB45C9F8207DEE1060CB71C04E80F593060B07D28308D7F4",
+		true,
+	},
+	// Public key not on the curve
+	{
+		"EEFDEA4CDB677750A420FEE807EACF21EB9898AE79B9768766E4FAA04A2D4A34",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false,
+	},
+	// R has an odd y
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"FFF97BD5755EEEA420453A14355235D382F6472F8568A18B2F057A14602975563CC27944640AC607CD107AE10923D9EF7A73C643E166BE5EBEAFA34B1AC553E2",
+		false,
+	},
+	// Negated message
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"1FA62E331EDBC21C394792D2AB1100A7B432B013DF3F6FF4F99FCB33E0E1515F28890B3EDB6E7189B630448B515CE4F8622A954CFE545735AAEA5134FCCDB2BD",
+		false,
+	},
+	// Negated s
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769961764B3AA9B2FFCB6EF947B6887A226E8D7C93E00C5ED0C1834FF0D0C2E6DA6",
+		false,
+	},
+	// R is the point at infinity
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"0000000000000000000000000000000000000000000000000000000000000000123DDA8328AF9C23A94C1FEECFD123BA4FB73476F0D594DCB65C6425BD186051",
+		false,
+	},
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"00000000000000000000000000000000000000000000000000000000000000017615FBAF5AE28864013C099742DEADB4DBA87F11AC6754F93780D5A1837CF197",
+		false,
+	},
+	// r isn't the x of a point on the curve
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"4A298DACAE57395A15D0795DDBFD1DCB564DA82B0F269BC70A74F8220429BA1D69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false,
+	},
+	// r is the field size
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F69E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false,
+	},
+	// s is the curve order
+	{
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E177769FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141",
+		false,
+	},
+	// Public key exceeds the field size
+	{
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6CFF5C3BA86C69EA4B7376F31A9BCB4F74C1976089B2D9963DA2E5543E17776969E89B4C5564D00349106B8497785DD7D1D713A8AE82B32FA79D5F7FC407D39B",
+		false,
+	},
+}
+
+func decodeHex(t testing.TB, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// signedHashes signs count random hashes with new keys on the curve
+func signedHashes(t testing.TB, curve Curve, count int) (pubKeys, hashes, signatures [][]byte) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		privKey, pubKey := NewKeyPair(curve)
+
+		hash := make([]byte, 32)
+		if _, err := rand.Read(hash); err != nil {
+			t.Fatal(err)
+		}
+
+		pubKeys = append(pubKeys, pubKey)
+		hashes = append(hashes, hash)
+		signatures = append(signatures, Sign(curve, privKey, hash))
+	}
+
+	return pubKeys, hashes, signatures
+}
+
+func TestBIP340Vectors(t *testing.T) {
+	valid := &BatchVerifier{}
+
+	for i, v := range bip340Vectors {
+		pubKey, message, signature := decodeHex(t, v.publicKey), decodeHex(t, v.message), decodeHex(t, v.signature)
+
+		if got := Verify(Schnorr, pubKey, message, signature); got != v.valid {
+			t.Errorf("vector %d: the signature verified %v", i, got)
+		}
+
+		single := &BatchVerifier{}
+		single.Add(Schnorr, pubKey, message, signature)
+		if got := single.Verify(); got != v.valid {
+			t.Errorf("vector %d: the batch of the signature verified %v", i, got)
+		}
+
+		if v.valid {
+			valid.Add(Schnorr, pubKey, message, signature)
+		} else {
+			// A bad signature fails the batch of the valid ones
+			bad := &BatchVerifier{}
+			for _, w := range bip340Vectors[:5] {
+				bad.Add(Schnorr, decodeHex(t, w.publicKey), decodeHex(t, w.message), decodeHex(t, w.signature))
+			}
+			bad.Add(Schnorr, pubKey, message, signature)
+			if bad.Verify() {
+				t.Errorf("vector %d: the batch with the invalid signature verified", i)
+			}
+		}
+	}
+
+	if valid.Verify() == false {
+		t.Error("the batch of the valid vectors failed")
+	}
+}
+
+func TestBatchVerify(t *testing.T) {
+	for _, count := range []int{0, 1, 2, 3, 16, 65} {
+		pubKeys, hashes, signatures := signedHashes(t, Schnorr, count)
+
+		b := &BatchVerifier{}
+		for i := range pubKeys {
+			b.Add(Schnorr, pubKeys[i], hashes[i], signatures[i])
+		}
+		if b.Verify() == false {
+			t.Errorf("a batch of %d valid signatures failed", count)
+		}
+
+		// Every single bad signature is caught, whatever its position
+		for bad := 0; bad < count; bad++ {
+			b := &BatchVerifier{}
+			for i := range pubKeys {
+				hash := hashes[i]
+				if i == bad {
+					hash = hashes[(i+1)%count]
+				}
+				b.Add(Schnorr, pubKeys[i], hash, signatures[i])
+			}
+			if count > 1 && b.Verify() {
+				t.Errorf("a batch of %d signatures with a bad one at %d verified", count, bad)
+			}
+		}
+	}
+}
+
+func TestBatchVerifyMixedCurves(t *testing.T) {
+	b := &BatchVerifier{}
+	for _, curve := range []Curve{P256, Secp256k1, Schnorr, Schnorr} {
+		pubKeys, hashes, signatures := signedHashes(t, curve, 1)
+		b.Add(curve, pubKeys[0], hashes[0], signatures[0])
+	}
+	if b.Verify() == false {
+		t.Fatal("a batch of valid signatures of every curve failed")
+	}
+
+	// A signature checked with the scheme of another curve fails
+	pubKeys, hashes, signatures := signedHashes(t, Secp256k1, 1)
+	b.Add(Schnorr, pubKeys[0][1:], hashes[0], signatures[0])
+	if b.Verify() {
+		t.Error("an ECDSA signature verified as a Schnorr signature")
+	}
+}
+
+// wnafValue rebuilds the scalar from its digits
+func wnafValue(naf []int8) btcec.ModNScalar {
+	var k, digit btcec.ModNScalar
+
+	for i := len(naf) - 1; i >= 0; i-- {
+		k.Add(&k)
+
+		d := int(naf[i])
+		if d < 0 {
+			digit.SetInt(uint32(-d))
+			digit.Negate()
+		} else {
+			digit.SetInt(uint32(d))
+		}
+		k.Add(&digit)
+	}
+
+	return k
+}
+
+func TestWnafRoundTrip(t *testing.T) {
+	var one, minusOne btcec.ModNScalar
+	one.SetInt(1)
+	minusOne.SetInt(1).Negate()
+
+	scalars := []btcec.ModNScalar{one, minusOne}
+	for i := 0; i < 100; i++ {
+		scalars = append(scalars, randomScalar())
+	}
+
+	for _, k := range scalars {
+		naf := wnaf(&k)
+
+		if value := wnafValue(naf); value.Equals(&k) == false {
+			t.Fatalf("the digits of %x add up to %x", k.Bytes(), value.Bytes())
+		}
+
+		last := -batchWindow
+		for i, d := range naf {
+			if d == 0 {
+				continue
+			}
+			if d%2 == 0 || d >= 1<<(batchWindow-1) || d <= -(1<<(batchWindow-1)) {
+				t.Fatalf("the digit %d of %x is %d", i, k.Bytes(), d)
+			}
+			if i-last < batchWindow {
+				t.Fatalf("the digits %d and %d of %x are both non zero", last, i, k.Bytes())
+			}
+			last = i
+		}
+	}
+}
+
+func TestMultiScalarMult(t *testing.T) {
+	var scalars []btcec.ModNScalar
+	var points []btcec.JacobianPoint
+	var want btcec.JacobianPoint
+
+	for i := 0; i < 5; i++ {
+		_, pubKey := NewKeyPair(Secp256k1)
+		key, err := btcec.ParsePubKey(pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var point, product btcec.JacobianPoint
+		key.AsJacobian(&point)
+		scalar := randomScalar()
+
+		btcec.ScalarMultNonConst(&scalar, &point, &product)
+		btcec.AddNonConst(&want, &product, &want)
+
+		scalars = append(scalars, scalar)
+		points = append(points, point)
+	}
+
+	var got btcec.JacobianPoint
+	multiScalarMult(scalars, points, &got)
+
+	want.ToAffine()
+	got.ToAffine()
+	if got.X.Equals(&want.X) == false || got.Y.Equals(&want.Y) == false {
+		t.Error("the sum of the products doesn't match the products added one at a time")
+	}
+}
+
+// BenchmarkVerifyECDSA verifies signatures one at a time on the ECDSA curves
+func BenchmarkVerifyECDSA(b *testing.B) {
+	for _, curve := range []Curve{Secp256k1, P256} {
+		b.Run(string(curve), func(b *testing.B) {
+			pubKeys, hashes, signatures := signedHashes(b, curve, 1)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if Verify(curve, pubKeys[0], hashes[0], signatures[0]) == false {
+					b.Fatal("the signature failed to verify")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBatchSchnorr verifies batches of Schnorr signatures of growing sizes,
+// the time of a signature is reported as ns/sig to compare with the single one
+func BenchmarkBatchSchnorr(b *testing.B) {
+	for _, count := range []int{1, 4, 16, 64, 256} {
+		b.Run(fmt.Sprintf("batch=%d", count), func(b *testing.B) {
+			pubKeys, hashes, signatures := signedHashes(b, Schnorr, count)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				batch := &BatchVerifier{}
+				for j := range pubKeys {
+					batch.Add(Schnorr, pubKeys[j], hashes[j], signatures[j])
+				}
+				if batch.Verify() == false {
+					b.Fatal("the batch failed to verify")
+				}
+			}
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*count), "ns/sig")
+		})
+	}
+}
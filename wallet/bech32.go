@@ -0,0 +1,281 @@
+package wallet
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sheghun/blockchain/network"
+)
+
+// AddressFormat is how the public key hash of a wallet is written as an address
+type AddressFormat byte
+
+const (
+	Base58  AddressFormat = iota // Version byte, hash and checksum in base58, the default
+	Bech32                       // Network prefix, hash and BCH checksum in lowercase base32
+	Bech32m                      // Bech32 with the improved checksum constant of BIP350
+)
+
+const (
+	bech32Charset     = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const       = 1
+	bech32mConst      = 0x2bc830a3
+	bech32ChecksumLen = 6
+	bech32MaxLength   = 90
+)
+
+// The first data symbol is the version of the address, like segwit
+// each version is bound to the checksum it's written with
+var bech32Versions = map[AddressFormat]byte{Bech32: 0, Bech32m: 1}
+
+var formatNames = map[AddressFormat]string{Base58: "base58", Bech32: "bech32", Bech32m: "bech32m"}
+
+func (f AddressFormat) String() string {
+	return formatNames[f]
+}
+
+// ParseAddressFormat returns the format with the name
+func ParseAddressFormat(name string) (AddressFormat, error) {
+	for format, n := range formatNames {
+		if n == name {
+			return format, nil
+		}
+	}
+
+	return Base58, fmt.Errorf("unknown address format %q, use base58, bech32 or bech32m", name)
+}
+
+// EncodeAddress writes the public key hash as an address of the active network in the format
+func EncodeAddress(pubHash []byte, format AddressFormat) string {
+	if format == Base58 {
+		return PubKeyHashToAddress(pubHash)
+	}
+
+	data, err := convertBits(pubHash, 8, 5, true)
+	Handle(err)
+
+	return bech32Encode(network.Active.Bech32HRP, append([]byte{bech32Versions[format]}, data...), format)
+}
+
+// isBech32 checks if the address starts with the prefix of a network and the separator,
+// base58 addresses never do as their first character is the network version
+func isBech32(addr string) bool {
+	sep := strings.LastIndexByte(addr, '1')
+	if sep < 1 {
+		return false
+	}
+
+	return network.ForBech32HRP(strings.ToLower(addr[:sep])) != nil
+}
+
+// decodeBech32Address returns the public key hash of a bech32 or bech32m address,
+// the checksum must be the one of the address version
+func decodeBech32Address(addr string) ([]byte, error) {
+	hrp, data, format, err := bech32Decode(addr)
+	if err != nil {
+		return nil, fmt.Errorf("address %q is not valid: %s", addr, err)
+	}
+
+	if hrp != network.Active.Bech32HRP {
+		return nil, fmt.Errorf("address %q belongs to %s, not %s", addr, network.ForBech32HRP(hrp).Name, network.Active.Name)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("address %q has no version", addr)
+	}
+	if version, ok := bech32Versions[format]; !ok || data[0] != version {
+		for f, v := range bech32Versions {
+			if v == data[0] {
+				return nil, fmt.Errorf("address %q is version %d which has a %s checksum, not %s", addr, data[0], f, format)
+			}
+		}
+		return nil, fmt.Errorf("address %q has the unknown version %d", addr, data[0])
+	}
+
+	pubHash, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("address %q is not valid: %s", addr, err)
+	}
+
+	return pubHash, nil
+}
+
+// bech32Encode appends the checksum of the format to the 5 bit data
+func bech32Encode(hrp string, data []byte, format AddressFormat) string {
+	checksum := bech32Checksum(hrp, data, format)
+
+	var addr strings.Builder
+	addr.WriteString(hrp)
+	addr.WriteByte('1')
+	for _, d := range append(data, checksum...) {
+		addr.WriteByte(bech32Charset[d])
+	}
+
+	return addr.String()
+}
+
+// bech32Decode returns the prefix and 5 bit data of the string and which checksum it has,
+// a checksum error tells the position of the wrong character when a single one is wrong
+func bech32Decode(s string) (string, []byte, AddressFormat, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, 0, fmt.Errorf("longer than %d characters", bech32MaxLength)
+	}
+
+	lower := strings.ToLower(s)
+	if lower != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("mixes upper and lower case")
+	}
+
+	sep := strings.LastIndexByte(lower, '1')
+	if sep < 1 || sep+bech32ChecksumLen+1 > len(lower) {
+		return "", nil, 0, fmt.Errorf("separator at position %d leaves no room for the prefix or checksum", sep)
+	}
+
+	hrp := lower[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, fmt.Errorf("invalid character in the prefix at position %d", i)
+		}
+	}
+
+	data := make([]byte, len(lower)-sep-1)
+	for i := range data {
+		d := strings.IndexByte(bech32Charset, lower[sep+1+i])
+		if d < 0 {
+			return "", nil, 0, fmt.Errorf("invalid character %q at position %d", s[sep+1+i], sep+1+i)
+		}
+		data[i] = byte(d)
+	}
+
+	format, ok := bech32Verify(hrp, data)
+	if !ok {
+		return "", nil, 0, bech32LocateError(hrp, data, sep+1)
+	}
+
+	return hrp, data[:len(data)-bech32ChecksumLen], format, nil
+}
+
+// bech32LocateError finds the character to change to fix the checksum, bech32 detects any
+// 4 wrong characters but only a single wrong one can be pointed out without guessing
+func bech32LocateError(hrp string, data []byte, offset int) error {
+	position := -1
+
+	for i := range data {
+		original := data[i]
+
+		for d := byte(0); d < 32; d++ {
+			if d == original {
+				continue
+			}
+
+			data[i] = d
+			if _, ok := bech32Verify(hrp, data); ok {
+				if position >= 0 && position != offset+i {
+					data[i] = original
+					return fmt.Errorf("checksum error, more than one character is wrong")
+				}
+				position = offset + i
+			}
+		}
+
+		data[i] = original
+	}
+
+	if position < 0 {
+		return fmt.Errorf("checksum error, more than one character is wrong")
+	}
+
+	return fmt.Errorf("checksum error, the character at position %d is wrong", position)
+}
+
+// bech32Verify checks the checksum and returns the variant it was made with
+func bech32Verify(hrp string, data []byte) (AddressFormat, bool) {
+	switch bech32Polymod(append(bech32HrpExpand(hrp), data...)) {
+	case bech32Const:
+		return Bech32, true
+	case bech32mConst:
+		return Bech32m, true
+	}
+
+	return 0, false
+}
+
+func bech32Checksum(hrp string, data []byte, format AddressFormat) []byte {
+	constant := uint32(bech32Const)
+	if format == Bech32m {
+		constant = bech32mConst
+	}
+
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLen)...)
+	mod := bech32Polymod(values) ^ constant
+
+	checksum := make([]byte, bech32ChecksumLen)
+	for i := range checksum {
+		checksum[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+
+	return checksum
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+
+	return expanded
+}
+
+// convertBits regroups the bits of the data in groups of a different size, the last
+// group is padded with zeros when pad is set and must be zero padding otherwise
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var out []byte
+	acc, bits := uint(0), uint(0)
+	max := uint(1)<<to - 1
+
+	for _, d := range data {
+		if uint(d)>>from != 0 {
+			return nil, fmt.Errorf("value %d doesn't fit in %d bits", d, from)
+		}
+
+		acc = acc<<from | uint(d)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&max))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&max))
+		}
+	} else if bits >= from || acc<<(to-bits)&max != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+
+	return out, nil
+}
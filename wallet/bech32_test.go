@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"bytes"
+	"github.com/sheghun/blockchain/network"
+	"strings"
+	"testing"
+)
+
+// Valid checksums from BIP173 and BIP350
+var bech32Vectors = []struct {
+	str    string
+	format AddressFormat
+}{
+	{"A12UEL5L", Bech32},
+	{"a12uel5l", Bech32},
+	{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", Bech32},
+	{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", Bech32},
+	{"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j", Bech32},
+	{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", Bech32},
+	{"?1ezyfcl", Bech32},
+
+	{"A1LQFN3A", Bech32m},
+	{"a1lqfn3a", Bech32m},
+	{"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6", Bech32m},
+	{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", Bech32m},
+	{"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8", Bech32m},
+	{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", Bech32m},
+	{"?1v759aa", Bech32m},
+}
+
+// Invalid strings from BIP173 and BIP350
+var bech32InvalidVectors = []string{
+	// BIP173
+	" 1nwldj5",
+	"\x7f1axkwrx",
+	"\x801eym55h",
+	"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx",
+	"pzry9x0s0muk",
+	"1pzry9x0s0muk",
+	"x1b4n0q5v",
+	"li1dgmt3",
+	"de1lg7wt\xff",
+	"A1G7SGD8",
+	"10a06t8",
+	"1qzzfhee",
+	"a12UEL5L", // Mixed case
+
+	// BIP350
+	"\x201xj0phk",
+	"\x7f1g6xzxy",
+	"\x801vctc34",
+	"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4",
+	"qyrz8wqd2c9m",
+	"1qyrz8wqd2c9m",
+	"y1b0jsk6g",
+	"lt1igcx5c0",
+	"in1muywd",
+	"mm1crxm3i",
+	"au1s5cgom",
+	"M1VUXWEZ",
+	"16plkw9",
+	"1p2gdwpf",
+}
+
+func TestBech32Vectors(t *testing.T) {
+	for _, v := range bech32Vectors {
+		hrp, data, format, err := bech32Decode(v.str)
+		if err != nil {
+			t.Errorf("%q: %s", v.str, err)
+			continue
+		}
+		if format != v.format {
+			t.Errorf("%q has a %s checksum instead of %s", v.str, format, v.format)
+		}
+
+		if encoded := bech32Encode(hrp, data, format); encoded != strings.ToLower(v.str) {
+			t.Errorf("%q was encoded again as %q", v.str, encoded)
+		}
+	}
+
+	for _, s := range bech32InvalidVectors {
+		if _, _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("%q was decoded", s)
+		}
+	}
+}
+
+func TestBech32Address(t *testing.T) {
+	defer func(active *network.Params) { network.Active = active }(network.Active)
+	network.Active = &network.Regtest
+
+	pubHash := PublicKeyHash(MakeWallet(Secp256k1).PublicKey)
+
+	for _, format := range []AddressFormat{Bech32, Bech32m} {
+		address := EncodeAddress(pubHash, format)
+		if !strings.HasPrefix(address, network.Regtest.Bech32HRP+"1") {
+			t.Errorf("the %s address %s doesn't start with the network prefix", format, address)
+		}
+
+		decoded, err := DecodeAddress(strings.ToUpper(address))
+		if err != nil {
+			t.Fatalf("%s: %s", address, err)
+		}
+		if !bytes.Equal(decoded, pubHash) {
+			t.Errorf("the %s address %s holds %x instead of %x", format, address, decoded, pubHash)
+		}
+	}
+
+	// A version 0 address with the bech32m checksum and the other way round
+	data, err := convertBits(pubHash, 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for format, version := range map[AddressFormat]byte{Bech32m: 0, Bech32: 1} {
+		address := bech32Encode(network.Regtest.Bech32HRP, append([]byte{version}, data...), format)
+		if _, err := DecodeAddress(address); err == nil {
+			t.Errorf("the version %d address %s with a %s checksum was decoded", version, address, format)
+		}
+	}
+
+	// A mistyped character is pointed out
+	address := []byte(EncodeAddress(pubHash, Bech32))
+	position := len(address) - 10
+	address[position] = bech32Charset[(strings.IndexByte(bech32Charset, address[position])+1)%32]
+	if _, err := DecodeAddress(string(address)); err == nil || !strings.Contains(err.Error(), "position") {
+		t.Errorf("the mistyped address %s gave the error %v", address, err)
+	}
+}
+
+func TestConvertBits(t *testing.T) {
+	data := []byte{0xff, 0x00, 0x5a}
+
+	fives, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eights, err := convertBits(fives, 5, 8, false)
+	if err != nil || !bytes.Equal(eights, data) {
+		t.Fatalf("%x was converted back to %x, %v", data, eights, err)
+	}
+
+	// The padding must be zeros and shorter than a group
+	if _, err := convertBits([]byte{31, 31}, 5, 8, false); err == nil {
+		t.Error("non zero padding was accepted")
+	}
+	if _, err := convertBits(append(fives, 0), 5, 8, false); err == nil {
+		t.Error("a whole group of padding was accepted")
+	}
+	if _, err := convertBits([]byte{32}, 5, 8, false); err == nil {
+		t.Error("a value larger than 5 bits was accepted")
+	}
+}
@@ -0,0 +1,194 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// Curve is the elliptic curve and signature scheme of a wallet key, it's recorded
+// with the public keys and signatures so verifiers never have to guess it
+type Curve string
+
+const (
+	// P256 keys are 64 byte X||Y public keys signed with 64 byte r||s signatures,
+	// the curve of the wallets created before curves could be chosen
+	P256 Curve = "p256"
+
+	// Secp256k1 keys are 33 byte compressed public keys signed with DER signatures
+	// like bitcoin, the addresses are the same as bitcoin P2PKH addresses
+	Secp256k1 Curve = "secp256k1"
+
+	// Schnorr keys are secp256k1 keys with 32 byte x-only public keys signed with
+	// 64 byte BIP340 Schnorr signatures, which are verified in batches in blocks
+	Schnorr Curve = "schnorr"
+)
+
+// DefaultCurve is the curve of new wallets
+const DefaultCurve = Secp256k1
+
+const (
+	p256PubKeyLength      = 64
+	secp256k1PubKeyLength = 33
+	schnorrPubKeyLength   = 32
+)
+
+// ParseCurve returns the curve with the name
+func ParseCurve(name string) (Curve, error) {
+	switch curve := Curve(name); curve {
+	case P256, Secp256k1, Schnorr:
+		return curve, nil
+	}
+
+	return "", fmt.Errorf("unknown curve %q, use %s, %s or %s", name, P256, Secp256k1, Schnorr)
+}
+
+// PublicKeyCurve guesses the curve of a public key from its length, only for
+// the keys recorded before their curve was
+func PublicKeyCurve(pubKey []byte) (Curve, error) {
+	switch len(pubKey) {
+	case p256PubKeyLength:
+		return P256, nil
+	case secp256k1PubKeyLength:
+		return Secp256k1, nil
+	case schnorrPubKeyLength:
+		return Schnorr, nil
+	}
+
+	return "", fmt.Errorf("public key of %d bytes has no known curve", len(pubKey))
+}
+
+// NewKeyPair generates returns the private and public keys on the curve
+func NewKeyPair(curve Curve) (ecdsa.PrivateKey, []byte) {
+	switch curve {
+	case Secp256k1:
+		private, err := btcec.NewPrivateKey()
+		Handle(err)
+
+		return *private.ToECDSA(), private.PubKey().SerializeCompressed()
+	case Schnorr:
+		private, err := btcec.NewPrivateKey()
+		Handle(err)
+
+		return *private.ToECDSA(), schnorr.SerializePubKey(private.PubKey())
+	}
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Handle(err)
+
+	return *private, p256PublicKey(private)
+}
+
+// privateKeyFromBytes rebuilds the private key on the curve from its scalar
+func privateKeyFromBytes(curve Curve, d []byte) ecdsa.PrivateKey {
+	if curve == Secp256k1 || curve == Schnorr {
+		private, _ := btcec.PrivKeyFromBytes(d)
+
+		return *private.ToECDSA()
+	}
+
+	c := elliptic.P256()
+	x, y := c.ScalarBaseMult(d)
+
+	return ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: c, X: x, Y: y}, D: new(big.Int).SetBytes(d)}
+}
+
+// p256PublicKey encodes the public key as X||Y
+func p256PublicKey(private *ecdsa.PrivateKey) []byte {
+	// Pad both coordinates to the curve size so the key can be split in half
+	keySize := (private.Curve.Params().BitSize + 7) / 8
+	pub := make([]byte, 2*keySize)
+	copy(pub[keySize-len(private.PublicKey.X.Bytes()):keySize], private.PublicKey.X.Bytes())
+	copy(pub[2*keySize-len(private.PublicKey.Y.Bytes()):], private.PublicKey.Y.Bytes())
+
+	return pub
+}
+
+// Sign signs the hash with the private key using the signature scheme of the curve
+func Sign(curve Curve, privKey ecdsa.PrivateKey, hash []byte) []byte {
+	switch curve {
+	case Secp256k1:
+		private, _ := btcec.PrivKeyFromBytes(privKey.D.Bytes())
+
+		return btcecdsa.Sign(private, hash).Serialize()
+	case Schnorr:
+		private, _ := btcec.PrivKeyFromBytes(privKey.D.Bytes())
+
+		signature, err := schnorr.Sign(private, hash)
+		Handle(err)
+
+		return signature.Serialize()
+	}
+
+	if curve != P256 {
+		Handle(fmt.Errorf("unknown curve %q", curve))
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	Handle(err)
+
+	// Pad r and s to the curve size so the signature can be split in half
+	keySize := (privKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*keySize)
+	copy(signature[keySize-len(r.Bytes()):keySize], r.Bytes())
+	copy(signature[2*keySize-len(s.Bytes()):], s.Bytes())
+
+	return signature
+}
+
+// Verify checks the signature of the hash was made by the public key on the curve
+func Verify(curve Curve, pubKey, hash, signature []byte) bool {
+	switch curve {
+	case Secp256k1:
+		key, err := btcec.ParsePubKey(pubKey)
+		if err != nil {
+			return false
+		}
+
+		sig, err := btcecdsa.ParseDERSignature(signature)
+		if err != nil {
+			return false
+		}
+
+		return sig.Verify(hash, key)
+	case Schnorr:
+		key, err := schnorr.ParsePubKey(pubKey)
+		if err != nil {
+			return false
+		}
+
+		sig, err := schnorr.ParseSignature(signature)
+		if err != nil {
+			return false
+		}
+
+		return sig.Verify(hash, key)
+	}
+
+	if curve != P256 || len(pubKey) != p256PubKeyLength {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	// Create a new public key
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
@@ -1,34 +1,87 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"github.com/sheghun/blockchain/network"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
-	checksumLength = 4
-	version        = byte(0x00)
+	checksumLength   = 4
+	pubKeyHashLength = 20
 )
 
 // Wallet struct contains the private key and public keys
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	Format     AddressFormat // Format of the address of the wallet
+	Curve      Curve         // Curve and signature scheme of the key
+}
+
+// walletData is how a wallet is stored in the wallets file
+// only the private scalar is kept as the curve itself can't be gob encoded
+type walletData struct {
+	D         []byte
+	PublicKey []byte
+	Format    AddressFormat
+	Curve     Curve // Empty for the P-256 wallets stored before curves could be chosen
+}
+
+// GobEncode encodes the wallet for the wallets file
+func (w Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+
+	err := gob.NewEncoder(&content).Encode(walletData{w.PrivateKey.D.Bytes(), w.PublicKey, w.Format, w.Curve})
+
+	return content.Bytes(), err
+}
+
+// GobDecode rebuilds the wallet keys from the wallets file
+func (w *Wallet) GobDecode(data []byte) error {
+	var wd walletData
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wd); err != nil {
+		return err
+	}
+
+	if wd.Curve == "" {
+		wd.Curve = P256
+	}
+	if _, err := ParseCurve(string(wd.Curve)); err != nil {
+		return err
+	}
+	if _, ok := formatNames[wd.Format]; !ok {
+		return fmt.Errorf("unknown address format %d", wd.Format)
+	}
+
+	w.PrivateKey = privateKeyFromBytes(wd.Curve, wd.D)
+	w.PublicKey = wd.PublicKey
+	w.Format = wd.Format
+	w.Curve = wd.Curve
+
+	return nil
 }
 
 // Address generates an address for the wallet
 func (w Wallet) Address() []byte {
 	pubHash := PublicKeyHash(w.PublicKey)
 
-	versionedHash := append([]byte{version}, pubHash...)
+	return []byte(EncodeAddress(pubHash, w.Format))
+}
+
+// PubKeyHashToAddress returns the address of the public key hash on the active network
+func PubKeyHashToAddress(pubHash []byte) string {
+	versionedHash := append([]byte{network.Active.AddressVersion}, pubHash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
 	address := Base58Encode(fullHash)
-	return address
+	return string(address)
 }
 
 // Example address
@@ -37,29 +90,51 @@ func (w Wallet) Address() []byte {
 // [Version] 00
 // [Pub Key Hash] a8e5bfbae31b2e7f410d9bc9b8ab898e01818451
 // [CheckSum] 730af9a6
-func ValidateAddress(addr string) bool {
-	_, _, err := Base58Decode([]byte(addr))
+// ValidateAddress returns why the address can't receive coins on the active network, nil if it can
+func ValidateAddress(addr string) error {
+	_, err := DecodeAddress(addr)
 
-	return err == nil
+	return err
 }
 
-// NewKeyPair generates returns the private and public keys
-func NewKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
-
-	private, err := ecdsa.GenerateKey(curve, rand.Reader)
-	Handle(err)
-
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-
-	return *private, pub
+// DecodeAddress returns the public key hash of the address, the checksum, the
+// version of the active network and the length of the hash are all checked
+// bech32 addresses are recognised by the network prefix they start with
+func DecodeAddress(addr string) ([]byte, error) {
+	var pubHash []byte
+
+	if isBech32(addr) {
+		var err error
+		if pubHash, err = decodeBech32Address(addr); err != nil {
+			return nil, err
+		}
+	} else {
+		var version byte
+		var err error
+		if pubHash, version, err = Base58Decode([]byte(addr)); err != nil {
+			return nil, fmt.Errorf("address %q is not valid: %s", addr, err)
+		}
+
+		if version != network.Active.AddressVersion {
+			if params := network.ForAddressVersion(version); params != nil {
+				return nil, fmt.Errorf("address %q belongs to %s, not %s", addr, params.Name, network.Active.Name)
+			}
+			return nil, fmt.Errorf("address %q has the unknown version %#02x", addr, version)
+		}
+	}
+
+	if len(pubHash) != pubKeyHashLength {
+		return nil, fmt.Errorf("address %q holds %d bytes instead of a %d byte public key hash", addr, len(pubHash), pubKeyHashLength)
+	}
+
+	return pubHash, nil
 }
 
-// MakeWallet creates a new wallet
-func MakeWallet() *Wallet {
-	private, public := NewKeyPair()
+// MakeWallet creates a new wallet with a key on the curve
+func MakeWallet(curve Curve) *Wallet {
+	private, public := NewKeyPair(curve)
 
-	wallet := &Wallet{private, public}
+	wallet := &Wallet{private, public, Base58, curve}
 
 	return wallet
 }
@@ -2,15 +2,22 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
+	"path/filepath"
 )
 
-const walletFile = "./tmp/blocks/wallets.data"
+// DataDir is the directory the wallets file is stored in
+var DataDir = "./tmp/blocks"
+
+// walletFile returns the path of the wallets file in the data directory
+func walletFile() string {
+	return filepath.Join(DataDir, "wallets.data")
+}
 
 // Wallets struct
 type Wallets struct {
@@ -22,14 +29,18 @@ func CreateWallets() *Wallets {
 	wallets := &Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
 
-	wallets.LoadFile()
+	// Wallets that can't be read must not be replaced by the next SaveFile
+	err := wallets.LoadFile()
+	Handle(err)
 
 	return wallets
 }
 
-// AddWallet creates and adds a wallet to a user's address
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+// AddWallet creates and adds a wallet with a key on the curve
+// to a user's address written in the format
+func (ws *Wallets) AddWallet(curve Curve, format AddressFormat) string {
+	wallet := MakeWallet(curve)
+	wallet.Format = format
 	address := fmt.Sprintf("%s", wallet.Address())
 
 	ws.Wallets[address] = wallet
@@ -38,8 +49,33 @@ func (ws *Wallets) AddWallet() string {
 }
 
 // GetWallet returns the wallet details of the address
+// the address can be written in any format
 func (ws Wallets) GetWallet(addr string) Wallet {
-	return *ws.Wallets[addr]
+	if w, ok := ws.Wallets[addr]; ok {
+		return *w
+	}
+
+	pubKeyHash, err := DecodeAddress(addr)
+	Handle(err)
+
+	w, _ := ws.GetWalletByPubKeyHash(pubKeyHash)
+	if w == nil {
+		Handle(fmt.Errorf("no wallet found for %s", addr))
+	}
+
+	return *w
+}
+
+// GetWalletByPubKeyHash returns the wallet owning the public key hash
+// and its address, the wallet is nil if none of the wallets owns it
+func (ws *Wallets) GetWalletByPubKeyHash(pubKeyHash []byte) (*Wallet, string) {
+	for address, w := range ws.Wallets {
+		if bytes.Equal(PublicKeyHash(w.PublicKey), pubKeyHash) {
+			return w, address
+		}
+	}
+
+	return nil, ""
 }
 
 // GetAllAddresses returns all the addresses in the wallet
@@ -54,46 +90,150 @@ func (ws *Wallets) GetAllAddresses() []string {
 }
 
 // Opens and loads the wallet file
-func (ws *Wallets) LoadFile() {
-	var wallets Wallets
-
+func (ws *Wallets) LoadFile() error {
 	// If no data has been saved
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
-		_, _ = os.Create(walletFile)
-		return // exit the function without modifying the wallet struct
+	if _, err := os.Stat(walletFile()); os.IsNotExist(err) {
+		_ = os.MkdirAll(DataDir, 0755)
+		_, _ = os.Create(walletFile())
+		return nil // exit the function without modifying the wallet struct
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
-	Handle(err)
+	fileContent, err := ioutil.ReadFile(walletFile())
+	if err != nil {
+		return err
+	}
 
-	gob.Register(elliptic.P256())
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
+	wallets, err := decodeWallets(fileContent)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", walletFile(), err)
+	}
 
 	// If no wallets exists/nil
-	if len(wallets.Wallets) == 0 {
-		return
+	if len(wallets) == 0 {
+		return nil
 	}
 
-	ws.Wallets = wallets.Wallets
+	ws.Wallets = wallets
+
+	return nil
+}
 
+// legacyWallet is how wallets were stored before the wallet encoded itself,
+// the ecdsa.PrivateKey gob encoded with its fields. The curve was always P-256
+type legacyWallet struct {
+	PrivateKey struct {
+		PublicKey struct {
+			X, Y *big.Int
+		}
+		D *big.Int
+	}
+	PublicKey []byte
+}
+
+// decodeWallets decodes the content of a wallets file, written by SaveFile
+// or in the layout of the legacy wallets, an empty file holds no wallets
+func decodeWallets(content []byte) (map[string]*Wallet, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	var wallets Wallets
+	err := gob.NewDecoder(bytes.NewReader(content)).Decode(&wallets)
+	if err == nil {
+		// bech32m addresses were version 0 before each version had its own checksum
+		for address, w := range wallets.Wallets {
+			if w.Format == Bech32m && string(w.Address()) != address {
+				delete(wallets.Wallets, address)
+				wallets.Wallets[string(w.Address())] = w
+			}
+		}
+
+		return wallets.Wallets, nil
+	}
+
+	var legacy struct {
+		Wallets map[string]*legacyWallet
+	}
+	if gob.NewDecoder(bytes.NewReader(content)).Decode(&legacy) != nil || len(legacy.Wallets) == 0 {
+		return nil, err
+	}
+
+	decoded := make(map[string]*Wallet)
+	for address, lw := range legacy.Wallets {
+		if lw.PrivateKey.D == nil {
+			return nil, fmt.Errorf("the wallet of %s has no private key", address)
+		}
+
+		decoded[address] = &Wallet{
+			PrivateKey: privateKeyFromBytes(P256, lw.PrivateKey.D.Bytes()),
+			PublicKey:  lw.PublicKey,
+			Format:     Base58,
+			Curve:      P256,
+		}
+	}
+
+	return decoded, nil
 }
 
 // SaveFile saves the wallets to a file
 func (ws *Wallets) SaveFile() {
 	var content bytes.Buffer
 
-	gob.Register(elliptic.P256())
-
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 	Handle(err)
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = os.MkdirAll(DataDir, 0755)
+	Handle(err)
+
+	err = writeFile(content.Bytes())
 	Handle(err)
 
 }
 
+// ReadFile returns the content of the wallets file as stored, nil when there's none
+func ReadFile() ([]byte, error) {
+	content, err := ioutil.ReadFile(walletFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if content == nil && err == nil {
+		content = []byte{}
+	}
+
+	return content, err
+}
+
+// RestoreFile writes the content of a wallets file read with ReadFile once it decodes,
+// the wallets of the data directory are never overwritten
+func RestoreFile(content []byte) error {
+	if _, err := decodeWallets(content); err != nil {
+		return fmt.Errorf("the wallets file is corrupted: %s", err)
+	}
+
+	if existing, err := ReadFile(); err != nil {
+		return err
+	} else if len(existing) > 0 {
+		return fmt.Errorf("%s already holds wallets", walletFile())
+	}
+
+	if err := os.MkdirAll(DataDir, 0755); err != nil {
+		return err
+	}
+
+	return writeFile(content)
+}
+
+// writeFile writes the wallets file readable by the owner only, a file written
+// by an older binary keeps its mode on write so it's changed as well
+func writeFile(content []byte) error {
+	if err := ioutil.WriteFile(walletFile(), content, 0600); err != nil {
+		return err
+	}
+
+	return os.Chmod(walletFile(), 0600)
+}
+
 // Handle takes the error and prints it out
 func Handle(err error) {
 	if err != nil {
@@ -0,0 +1,237 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/gob"
+	"github.com/sheghun/blockchain/network"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// The layout gob wrote for the wallets before the wallet encoded itself,
+// the P-256 curve was registered and written with its parameters
+type oldCurveParams struct {
+	P, N, B, Gx, Gy *big.Int
+	BitSize         int
+	Name            string
+}
+
+type oldP256Curve struct {
+	CurveParams *oldCurveParams
+}
+
+type oldPublicKey struct {
+	Curve interface{}
+	X, Y  *big.Int
+}
+
+type oldPrivateKey struct {
+	PublicKey oldPublicKey
+	D         *big.Int
+}
+
+type oldWallet struct {
+	PrivateKey oldPrivateKey
+	PublicKey  []byte
+}
+
+type oldWallets struct {
+	Wallets map[string]*oldWallet
+}
+
+func useTempDataDir(t *testing.T) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "wallets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := DataDir
+	DataDir = dir
+
+	return func() {
+		DataDir = previous
+		os.RemoveAll(dir)
+	}
+}
+
+func writeWalletFile(t *testing.T, content []byte) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(walletFile(), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLegacyWallets(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	private, public := NewKeyPair(P256)
+	address := PubKeyHashToAddress(PublicKeyHash(public))
+
+	params := elliptic.P256().Params()
+	gob.RegisterName("crypto/elliptic.p256Curve", oldP256Curve{})
+
+	old := oldWallets{Wallets: map[string]*oldWallet{
+		address: {
+			PrivateKey: oldPrivateKey{
+				PublicKey: oldPublicKey{
+					Curve: oldP256Curve{&oldCurveParams{params.P, params.N, params.B, params.Gx, params.Gy, params.BitSize, params.Name}},
+					X:     private.X,
+					Y:     private.Y,
+				},
+				D: private.D,
+			},
+			PublicKey: public,
+		},
+	}}
+
+	var content bytes.Buffer
+	if err := gob.NewEncoder(&content).Encode(old); err != nil {
+		t.Fatal(err)
+	}
+	writeWalletFile(t, content.Bytes())
+
+	wallets := CreateWallets()
+	w, ok := wallets.Wallets[address]
+	if !ok {
+		t.Fatalf("the wallet of %s wasn't loaded, got %v", address, wallets.GetAllAddresses())
+	}
+	if w.PrivateKey.D.Cmp(private.D) != 0 || !bytes.Equal(w.PublicKey, public) {
+		t.Fatal("the legacy wallet was loaded with another key")
+	}
+
+	hash := sha256.Sum256([]byte("legacy wallet"))
+	if !Verify(w.Curve, w.PublicKey, hash[:], Sign(w.Curve, w.PrivateKey, hash[:])) {
+		t.Fatal("the legacy wallet key doesn't sign for its public key")
+	}
+
+	// Saved in the current layout the keys survive the next load
+	wallets.SaveFile()
+
+	reloaded := CreateWallets()
+	if w, ok := reloaded.Wallets[address]; !ok || w.PrivateKey.D.Cmp(private.D) != 0 {
+		t.Fatal("the legacy wallet was lost once saved again")
+	}
+}
+
+func TestLoadCorruptedWallets(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	wallets := CreateWallets()
+	wallets.AddWallet(Secp256k1, Base58)
+	wallets.SaveFile()
+
+	content, err := ioutil.ReadFile(walletFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := content[:len(content)/2]
+	writeWalletFile(t, corrupted)
+
+	loaded := &Wallets{Wallets: make(map[string]*Wallet)}
+	if err := loaded.LoadFile(); err == nil {
+		t.Fatal("a truncated wallets file was loaded")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("CreateWallets returned wallets it couldn't read")
+			}
+		}()
+		CreateWallets()
+	}()
+
+	after, err := ioutil.ReadFile(walletFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, corrupted) {
+		t.Error("the wallets file was changed")
+	}
+}
+
+func TestLoadEmptyWallets(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	// The first load creates an empty file which holds no wallets
+	if wallets := CreateWallets(); len(wallets.Wallets) != 0 {
+		t.Fatalf("a new wallets file holds %d wallets", len(wallets.Wallets))
+	}
+	if wallets := CreateWallets(); len(wallets.Wallets) != 0 {
+		t.Fatalf("an empty wallets file holds %d wallets", len(wallets.Wallets))
+	}
+}
+
+func TestLoadBech32mWalletOfVersionZero(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	wallets := CreateWallets()
+	address := wallets.AddWallet(Secp256k1, Bech32m)
+	w := wallets.Wallets[address]
+
+	// Written before each address version had its own checksum
+	data, err := convertBits(PublicKeyHash(w.PublicKey), 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := bech32Encode(network.Active.Bech32HRP, append([]byte{0}, data...), Bech32m)
+
+	delete(wallets.Wallets, address)
+	wallets.Wallets[old] = w
+	wallets.SaveFile()
+
+	loaded := CreateWallets()
+	if _, ok := loaded.Wallets[address]; !ok {
+		t.Fatalf("the wallet isn't found by its address %s, got %v", address, loaded.GetAllAddresses())
+	}
+	if _, ok := loaded.Wallets[old]; ok {
+		t.Error("the wallet is still found by its version 0 address")
+	}
+}
+
+func TestWalletsFileMode(t *testing.T) {
+	defer useTempDataDir(t)()
+
+	// Written by an older binary readable by everyone
+	writeWalletFile(t, nil)
+	if err := os.Chmod(walletFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wallets := CreateWallets()
+	wallets.AddWallet(Secp256k1, Base58)
+	wallets.SaveFile()
+
+	info, err := os.Stat(walletFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("the saved wallets file has the mode %o", mode)
+	}
+
+	content, err := ReadFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(walletFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreFile(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err = os.Stat(walletFile()); err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("the restored wallets file has the mode %o", mode)
+	}
+}